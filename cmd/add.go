@@ -6,14 +6,17 @@ import (
 	"bufio"
 	"context"
 	"flag"
+	"fmt"
 	"io"
 	"os"
+	"runtime"
 
 	"github.com/alphazero/gart"
 	"github.com/alphazero/gart/index"
 	"github.com/alphazero/gart/syslib/bench"
 	"github.com/alphazero/gart/syslib/debug"
 	"github.com/alphazero/gart/syslib/errors"
+	"github.com/alphazero/gart/syslib/fs"
 	"github.com/alphazero/gart/system"
 	"github.com/alphazero/gart/system/log"
 )
@@ -22,28 +25,68 @@ type addOption struct {
 	cmdOption
 	text    bool
 	url     bool
+	data    bool
+	name    string
+	store   bool
+	chunked bool
 	tagspec string
 	args    []string
 	otype   system.Otype
+	workers int
+	rehash  bool
+	walk    bool // -r
+	depth   int
+	follow  bool
+	explain string // -explain-ignore path
 }
 
 // gart add -tags "tag1, tag 2, tag 3" f1.ext f2.ext ...
 // gart add --strict -tags "tag1, tag 2, tag 3" f1.ext f2.ext ...
+// gart add -store -tags "tag1, tag 2, tag 3" f1.ext f2.ext ...
+// gart add -chunked -tags "tag1, tag 2, tag 3" f1.ext f2.ext ...
+// gart add -r -tags "tag1, tag 2, tag 3" dir1 dir2 f1.ext ...
+// gart add -r -depth 2 -follow -tags "tag1, tag 2, tag 3" dir1 ...
+// gart add -explain-ignore path
 // gart add --text -tags "tag1, tag 2, tag 3" "quote 1" "quote 2" ...
+// gart add --url -tags "tag1, tag 2, tag 3" https://golang.org/doc/ ...
+// gart add -data -tags "tag1, tag 2" < blob.bin
+// gart add -data -store -name "report" -tags "tag1, tag 2" < report.out
 // cat pithy.txt | gart add --test -tags "pithy quotes"
 // find . -type f -name "*.pdf" | gart add --test -tags "pithy quotes"
 func parseAddArgs(args []string) (Command, Option, error) {
 	var option = addOption{
-		otype: system.File,
+		otype:   system.File,
+		workers: runtime.NumCPU(),
 	}
 
 	option.flags = flag.NewFlagSet("gart add", flag.ExitOnError)
 	option.usingVerboseFlag0()
+	option.usingWaitFlag()
 	option.usingStrictFlag("add new objects only - no updates")
 	option.flags.BoolVar(&option.text, "text", option.text,
 		"archive text object(s) -- overrides default file type")
 	option.flags.BoolVar(&option.url, "url", option.url,
 		"archive url object(s) -- overrides default file type")
+	option.flags.BoolVar(&option.data, "data", option.data,
+		"archive data object read from stdin -- overrides default file type")
+	option.flags.StringVar(&option.name, "name", option.name,
+		"label of data object")
+	option.flags.BoolVar(&option.store, "store", option.store,
+		"copy file content to the repo object store")
+	option.flags.BoolVar(&option.chunked, "chunked", option.chunked,
+		"store file content as deduplicated chunks (implies store)")
+	option.flags.BoolVar(&option.walk, "r", option.walk,
+		"add files of directory args recursively")
+	option.flags.IntVar(&option.depth, "depth", option.depth,
+		"max depth of recursive add - 1 is files of directory args only (0 is unlimited)")
+	option.flags.BoolVar(&option.follow, "follow", option.follow,
+		"follow symlinks on recursive add")
+	option.flags.StringVar(&option.explain, "explain-ignore", option.explain,
+		"report the ignore rule (if any) matching the path - nothing is added")
+	option.flags.IntVar(&option.workers, "workers", option.workers,
+		"number of concurrent file hashing workers")
+	option.flags.BoolVar(&option.rehash, "rehash", option.rehash,
+		"hash all file content - do not use the hash cache")
 	option.flags.StringVar(&option.tagspec, "tags", option.tagspec,
 		"required - csv list of tags to apply to object")
 
@@ -55,11 +98,12 @@ func parseAddArgs(args []string) (Command, Option, error) {
 	}
 
 	option.flags.Parse(args[1:])
-	if option.tagspec == "" {
+	if option.tagspec == "" && option.explain == "" {
 		debug.Printf("tags flag is required")
 		return nil, option, ErrUsage
 	}
 	option.args = option.flags.Args()
+	option.store = option.store || option.chunked
 
 	return addCommand, option, nil
 }
@@ -73,15 +117,41 @@ func addCommand(ctx context.Context, option0 Option) error {
 		return err.InvalidArg("expecting addOption - %v", option0)
 	}
 
-	// text, file, url flags are mutually exclusive
+	if option.explain != "" {
+		return explainIgnore(option.explain)
+	}
+
+	// text, file, url, data flags are mutually exclusive
 	switch {
-	case option.text && option.url:
-		return err.InvalidArg("flags text and url are mutually exlusive")
+	case option.text && option.url, option.data && (option.text || option.url):
+		return err.InvalidArg("flags text, url, and data are mutually exlusive")
+	case (option.text || option.url) && option.store:
+		return err.InvalidArg("flag store is only supported for file and data objects")
+	case option.data && option.chunked:
+		return err.InvalidArg("flag chunked is only supported for file objects")
+	case option.name != "" && !option.data:
+		return err.InvalidArg("flag name is only supported for data objects")
 	case option.text:
 		option.otype = system.Text
 	case option.url:
 		option.otype = system.URL
-		return err.Error("Url objects not yet supported")
+	case option.data:
+		option.otype = system.Data
+	}
+	if option.walk {
+		if option.otype != system.File {
+			return err.InvalidArg("flag r is only supported for file objects")
+		}
+		if len(option.args) == 0 {
+			return err.InvalidArg("flag r requires directory args")
+		}
+		return addWalkedObjects(ctx, option)
+	}
+	if option.data {
+		if len(option.args) > 0 {
+			return err.InvalidArg("data object is read from stdin - args: %q", option.args)
+		}
+		return addDataObject(ctx, option)
 	}
 	if len(option.args) == 0 {
 		return addStreamedObjects(ctx, option)
@@ -98,16 +168,135 @@ func addSpecifiedObjects(ctx context.Context, option addOption) error {
 	}
 	log.Log("session - begin")
 
-	var tags = parseTags(option.tagspec)
+	var specs = make(chan string, len(option.args))
 	for _, spec := range option.args {
 		if len(spec) == 0 {
 			continue
 		}
-		if e = interruptibleAdd(ctx, session, option.strict, option.otype, spec, tags...); e != nil {
-			log.Error(e.Error())
-			break
+		specs <- spec
+	}
+	close(specs)
+
+	if _, e = addObjects(ctx, session, option, specs); e != nil {
+		log.Error(e.Error())
+	}
+	var commit = e == nil // do not commit on any error
+	if ec := session.Close(commit); ec != nil {
+		panic(err.Fault("on session close - %v", ec))
+	} else {
+		log.Log("session - close")
+	}
+	return e
+}
+
+// explainIgnore reports if the path is ignored, and the deciding rule.
+func explainIgnore(path string) error {
+	rule, ignored, e := gart.ExplainIgnore(path)
+	switch {
+	case e != nil:
+		return e
+	case rule == nil:
+		fmt.Printf("%s: not ignored - no matching rule\n", path)
+	case ignored:
+		fmt.Printf("%s: ignored - %s\n", path, rule)
+	default:
+		fmt.Printf("%s: not ignored - %s\n", path, rule)
+	}
+	return nil
+}
+
+// addWalkedObjects adds the files of the file trees rooted at the args. The
+// trees are walked (see gart.WalkFiles) concurrently with the adding of files.
+func addWalkedObjects(ctx context.Context, option addOption) error {
+	var err = errors.For("cmd.addWalkedObjects")
+
+	session, e := gart.OpenSession(ctx, gart.Add)
+	if e != nil {
+		return err.Error("could not open session - %v", e)
+	}
+	log.Log("session - begin")
+
+	var t0 = bench.NewTimestamp()
+
+	// the walk is stopped on return
+	var ctxChild, cancel = context.WithCancel(ctx)
+	defer cancel()
+
+	// the walk error (nil on completion) is sent to walkc once walking stops.
+	var specs = make(chan string)
+	var walkc = make(chan error, 1)
+	go func() {
+		defer close(specs)
+		var walkOption = fs.WalkOption{
+			MaxDepth:       option.depth,
+			FollowSymlinks: option.follow,
+		}
+		for _, root := range option.args {
+			e := gart.WalkFiles(root, walkOption, func(path string, e error) error {
+				if e != nil {
+					log.Log("%v - skipped", e)
+					return nil
+				}
+				select {
+				case specs <- path:
+					return nil
+				case <-ctxChild.Done():
+					return ErrInterrupt
+				}
+			})
+			if e != nil {
+				walkc <- e
+				return
+			}
 		}
+		walkc <- nil
+	}()
+
+	var n int
+	if n, e = addObjects(ctx, session, option, specs); e != nil {
+		log.Error(e.Error())
+	} else if e = <-walkc; e != nil {
+		log.Error(e.Error())
 	}
+	t0.Mark("add completed")
+
+	var commit = e == nil // do not commit on any error
+	if ec := session.Close(commit); ec != nil {
+		panic(err.Fault("on session close - %v", ec))
+	}
+	t0.Mark("commit completed")
+	log.Log("session - close - %d items processed - commit:%t", n, commit)
+
+	return e
+}
+
+// addDataObject adds the data object read from stdin.
+func addDataObject(ctx context.Context, option addOption) error {
+	var err = errors.For("cmd.addDataObject")
+
+	session, e := gart.OpenSession(ctx, gart.Add)
+	if e != nil {
+		return err.Error("could not open session - %v", e)
+	}
+	log.Log("session - begin")
+
+	var r = &interruptibleReader{ctx, bufio.NewReader(os.Stdin)}
+	card, added, e := session.AddData(option.strict, r, option.name, option.store, parseTags(option.tagspec)...)
+	switch {
+	case ctx.Err() != nil:
+		e = ErrInterrupt
+	case e == nil:
+		log.Log("%s (added: %t) (bytes: %d) stdin", card.Oid().Fingerprint(), added, card.(index.DataCard).Size())
+		if option.store {
+			log.Log("%s (stored: %t) stdin", card.Oid().Fingerprint(), card.IsStored())
+		}
+	case index.IsObjectExistErr(e):
+		log.Log("%s exists - stdin", e.(index.Error).Oid.Fingerprint())
+		e = nil
+	default:
+		log.Error(e.Error())
+	}
+
 	var commit = e == nil // do not commit on any error
 	if ec := session.Close(commit); ec != nil {
 		panic(err.Fault("on session close - %v", ec))
@@ -117,6 +306,19 @@ func addSpecifiedObjects(ctx context.Context, option addOption) error {
 	return e
 }
 
+// interruptibleReader stops reading once the context is done.
+type interruptibleReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *interruptibleReader) Read(p []byte) (int, error) {
+	if r.ctx.Err() != nil {
+		return 0, ErrInterrupt
+	}
+	return r.r.Read(p)
+}
+
 func addStreamedObjects(ctx context.Context, option addOption) error {
 	var err = errors.For("cmd.addStreamedObjects")
 
@@ -128,27 +330,44 @@ func addStreamedObjects(ctx context.Context, option addOption) error {
 
 	var t0 = bench.NewTimestamp()
 
-	var tags = parseTags(option.tagspec)
-	var r = bufio.NewReader(os.Stdin)
-	var line []byte
-	var n int
-	for {
-		// REVU BUG - if interrupt happens here (e.g. find command pipe breaks) then
-		//            this returns EOF which below is interpreted as 'expected error' and certainly
-		//            not interrupt! So commit actually happens.
-		//
-		line, e = r.ReadBytes('\n')
-		if e != nil {
-			break
-		}
-		n++
-		spec := string(line[:len(line)-1])
-		if len(spec) == 0 {
-			continue
+	// stdin is read by its own goroutine. the read error (io.EOF on end of
+	// input) is sent to readc once reading stops.
+	var specs = make(chan string)
+	var readc = make(chan error, 1)
+	go func() {
+		defer close(specs)
+		var r = bufio.NewReader(os.Stdin)
+		for {
+			// REVU BUG - if interrupt happens here (e.g. find command pipe breaks) then
+			//            this returns EOF which below is interpreted as 'expected error' and certainly
+			//            not interrupt! So commit actually happens.
+			//
+			line, e := r.ReadBytes('\n')
+			if e != nil {
+				readc <- e
+				return
+			}
+			spec := string(line[:len(line)-1])
+			if len(spec) == 0 {
+				continue
+			}
+			select {
+			case specs <- spec:
+			case <-ctx.Done():
+				readc <- ErrInterrupt
+				return
+			}
 		}
-		if e = interruptibleAdd(ctx, session, option.strict, option.otype, spec, tags...); e != nil {
-			log.Error(e.Error())
-			break
+	}()
+
+	var n int
+	if n, e = addObjects(ctx, session, option, specs); e != nil {
+		log.Error(e.Error())
+	} else {
+		select {
+		case e = <-readc:
+		case <-ctx.Done():
+			e = ErrInterrupt
 		}
 	}
 	t0.Mark("add completed")
@@ -174,28 +393,95 @@ func addStreamedObjects(ctx context.Context, option addOption) error {
 	return e
 }
 
-func interruptibleAdd(ctx context.Context, session gart.Session, strict bool, typ system.Otype, spec string, tags ...string) error {
+// addObjects adds the objects specified by specs, in order, until specs is
+// closed. The digests of file objects are computed concurrently by a pool of
+// workers (see gart.Session#HashFiles), while objects are added to the index by the
+// calling goroutine.
+//
+// Returns the number of processed specs, and nil on success.
+func addObjects(ctx context.Context, session gart.Session, option addOption, specs <-chan string) (int, error) {
+	var tags = parseTags(option.tagspec)
+
+	var n int
+	if option.otype != system.File {
+		for spec := range specs {
+			n++
+			if e := interruptibleAdd(ctx, session, option, spec, tags...); e != nil {
+				return n, e
+			}
+		}
+		return n, nil
+	}
+
+	// the hashing pipeline is stopped on return
+	var ctxChild, cancel = context.WithCancel(ctx)
+	defer cancel()
+
+	session.SetRehash(option.rehash)
+	for fd := range session.HashFiles(ctxChild, specs, option.workers) {
+		n++
+		if e := interruptibleAddDigest(ctx, session, option, fd, tags...); e != nil {
+			return n, e
+		}
+	}
+	if ctx.Err() != nil {
+		return n, ErrInterrupt
+	}
+	return n, nil
+}
+
+func interruptibleAdd(ctx context.Context, session gart.Session, option addOption, spec string, tags ...string) error {
 	select {
 	case <-ctx.Done():
 		return ErrInterrupt
 	default:
-		card, added, e := session.AddObject(strict, typ, spec, tags...)
+		card, added, e := session.AddObject(option.strict, option.otype, spec, tags...)
+		return onAdd(session, option, spec, card, added, e)
+	}
+}
+
+func interruptibleAddDigest(ctx context.Context, session gart.Session, option addOption, fd *gart.FileDigest, tags ...string) error {
+	select {
+	case <-ctx.Done():
+		return ErrInterrupt
+	default:
+		card, added, e := session.AddFileDigest(option.strict, fd, tags...)
+		return onAdd(session, option, fd.Spec, card, added, e)
+	}
+}
+
+// onAdd logs the result of adding the object, and stores the object content
+// if the store option is set. Expected (per object) errors are logged and
+// are not returned.
+func onAdd(session gart.Session, option addOption, spec string, card index.Card, added bool, e error) error {
+	if e != nil {
+		switch {
+		case index.IsObjectExistErr(e):
+			log.Log("%s exists - %q", e.(index.Error).Oid.Fingerprint(), spec)
+			return nil
+		case index.IsObjectTypeErr(e):
+			log.Log("%s exists as another type - %q ignored", e.(index.Error).Oid.Fingerprint(), spec)
+			return nil
+		case os.IsNotExist(e):
+			log.Log("%v", e)
+			return nil
+		case e == gart.ErrIgnoredPath:
+			log.Log("%s ignored", spec)
+			return nil
+		case e == system.ErrInvalidURL:
+			log.Log("%q ignored - %v", spec, e)
+			return nil
+		default:
+			return e
+		}
+	}
+	log.Log("%s (added: %t) %q", card.Oid().Fingerprint(), added, spec)
+	if option.store {
+		stored, e := session.StoreFile(card.Oid(), spec, option.chunked)
 		if e != nil {
-			switch {
-			case index.IsObjectExistErr(e):
-				log.Log("%s exists - %q", e.(index.Error).Oid.Fingerprint(), spec)
-				return nil
-			case os.IsNotExist(e):
-				log.Log("%v", e)
-				return nil
-			case e == gart.ErrIgnoredPath:
-				log.Log("%s ignored", spec)
-				return nil
-			default:
-				return e
-			}
+			return e
 		}
-		log.Log("%s (added: %t) %q", card.Oid().Fingerprint(), added, spec)
+		log.Log("%s (stored: %t) %q", card.Oid().Fingerprint(), stored, spec)
 	}
 	return nil
 }
@@ -9,9 +9,13 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"time"
 
+	"github.com/alphazero/gart/index"
+	"github.com/alphazero/gart/repo"
 	"github.com/alphazero/gart/syslib/debug"
 	"github.com/alphazero/gart/syslib/errors"
+	"github.com/alphazero/gart/system"
 	"github.com/alphazero/gart/system/log"
 )
 
@@ -25,6 +29,7 @@ type Option interface {
 	flagSet() *flag.FlagSet
 	isVerbose() bool
 	isStrict() bool
+	lockWait() time.Duration
 }
 
 // cmdOption supports Option interface and provides struct base for command
@@ -33,6 +38,7 @@ type cmdOption struct {
 	flags   *flag.FlagSet
 	verbose bool
 	strict  bool
+	wait    time.Duration
 }
 
 func (p *cmdOption) setFlagSet(fs *flag.FlagSet) { p.flags = fs }
@@ -49,12 +55,35 @@ func (p *cmdOption) usingStrictFlag(info string) {
 	p.flags.BoolVar(&(*p).strict, "strict", p.strict, info)
 }
 
-func (v cmdOption) flagSet() *flag.FlagSet { return v.flags }
-func (v cmdOption) isVerbose() bool        { return v.verbose }
-func (v cmdOption) isStrict() bool         { return v.strict }
+// commands that open the repo index use the wait flag for the repo lock.
+func (p *cmdOption) usingWaitFlag() {
+	p.flags.DurationVar(&(*p).wait, "wait", p.wait,
+		"max wait for the repo lock if in use, e.g. 30s (default no wait)")
+}
+
+func (v cmdOption) flagSet() *flag.FlagSet  { return v.flags }
+func (v cmdOption) isVerbose() bool         { return v.verbose }
+func (v cmdOption) isStrict() bool          { return v.strict }
+func (v cmdOption) lockWait() time.Duration { return v.wait }
 
 /// uniform command-line arg pre-processing ////////////////////////////////////
 
+// gart [-repo path] <command> ...
+//
+// Global flags precede the command. Returns the repo location spec and the
+// args, sans the global flags.
+func parseGlobalArgs(args []string) (string, []string) {
+	var repoSpec string
+
+	var flags = flag.NewFlagSet("gart", flag.ExitOnError)
+	flags.StringVar(&repoSpec, "repo", repoSpec,
+		"repo location - the .gart dir or its parent dir "+
+			"(default $"+system.RepoEnv+", or the nearest repo to cwd, or user home)")
+	flags.Parse(args[1:])
+
+	return repoSpec, append([]string{args[0]}, flags.Args()...)
+}
+
 func parseArgs(args []string) (Command, Option, error) {
 	var cname string
 
@@ -88,6 +117,14 @@ func parseArgs(args []string) (Command, Option, error) {
 		return parseFindArgs(args[1:])
 	case "tag":
 		return parseTagArgs(args[1:])
+	case "fsck":
+		return parseFsckArgs(args[1:])
+	case "reindex":
+		return parseReindexArgs(args[1:])
+	case "compact":
+		return parseCompactArgs(args[1:])
+	case "get":
+		return parseGetArgs(args[1:])
 	}
 
 	debug.Printf("unknown command - args: %q", args)
@@ -121,7 +158,8 @@ func main() {
 		exitOnError(errors.Fault("gart will not run as root"))
 	}
 
-	command, option, e := parseArgs(os.Args)
+	repoSpec, args := parseGlobalArgs(os.Args)
+	command, option, e := parseArgs(args)
 	switch e {
 	case nil:
 	case ErrUsage:
@@ -140,6 +178,25 @@ func main() {
 	if option != nil && option.isVerbose() {
 		log.Verbose(os.Stderr)
 	}
+	if option != nil {
+		index.LockTimeout = option.lockWait()
+	}
+
+	// gart init creates a new repo in cwd, unless specified.
+	var isInit = len(args) > 1 && args[1] == "init"
+	root, e := system.LocateRepo(repoSpec, isInit)
+	if e != nil {
+		exitOnError(e)
+	}
+	system.SetRepoRoot(root)
+	log.Log("repo - %s", repo.RepoPath)
+
+	// help, etc. do not use the repo
+	if option != nil && !isInit {
+		if _, e := os.Stat(repo.RepoPath); os.IsNotExist(e) {
+			exitOnError(errors.Error("no gart repo at %q - see gart init", repo.RepoPath))
+		}
+	}
 
 	var ctx = interruptibleContext(context.Background())
 	e = command(ctx, option)
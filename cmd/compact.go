@@ -0,0 +1,54 @@
+// Doost!
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/alphazero/gart"
+	"github.com/alphazero/gart/syslib/errors"
+)
+
+type compactOption struct {
+	cmdOption
+}
+
+// gart compact
+func parseCompactArgs(args []string) (Command, Option, error) {
+	var option compactOption
+
+	option.flags = flag.NewFlagSet("gart compact", flag.ExitOnError)
+	option.usingVerboseFlag0()
+	option.usingWaitFlag()
+
+	if len(args) > 1 {
+		option.flags.Parse(args[1:])
+	}
+
+	return compactCommand, option, nil
+}
+
+func compactCommand(ctx context.Context, option0 Option) error {
+	var err = errors.For("cmd.compactCommand")
+
+	_, ok := option0.(compactOption)
+	if !ok {
+		return err.InvalidArg("expecting compactOption - %v", option0)
+	}
+
+	stats, e := gart.CompactIndex()
+	if e != nil {
+		return e
+	}
+	if stats.Live == stats.Objects && stats.Removed == 0 {
+		fmt.Fprintf(os.Stdout, "nothing to compact - objects:%d\n", stats.Objects)
+		return nil
+	}
+	fmt.Fprintf(os.Stdout, "compacted - objects:%d -> %d (removed cards:%d) tagmaps:%d bitmaps:%d -> %d bytes\n",
+		stats.Objects, stats.Live, stats.Removed, stats.Tagmaps,
+		stats.BitmapBytes[0], stats.BitmapBytes[1])
+	return nil
+}
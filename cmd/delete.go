@@ -6,32 +6,107 @@ import (
 	"context"
 	"flag"
 
+	"github.com/alphazero/gart"
+	"github.com/alphazero/gart/syslib/debug"
 	"github.com/alphazero/gart/syslib/errors"
+	"github.com/alphazero/gart/system"
+	"github.com/alphazero/gart/system/log"
 )
 
 type deleteOption struct {
 	cmdOption
-	force bool
+	tagspec string
+	usepath bool
+	args    []string
 }
 
+// gart delete <oid-prefix> ...
+// gart delete -file f1.ext f2.ext ...
+// gart delete -tags "tag1, tag2"
 func parseDeleteArgs(args []string) (Command, Option, error) {
 	var option deleteOption
 
-	flags := flag.NewFlagSet("gart-delete", flag.ExitOnError)
-	flags.BoolVar(&option.force, "force", option.force, "force re-deleteialization of repo")
-	if len(args) > 1 {
-		flags.Parse(args[1:])
+	option.flags = flag.NewFlagSet("gart delete", flag.ExitOnError)
+	option.usingVerboseFlag0()
+	option.usingWaitFlag()
+	option.flags.StringVar(&option.tagspec, "tags", option.tagspec,
+		"delete objects with tags (csv list) instead of args")
+	option.flags.BoolVar(&option.usepath, "file", option.usepath,
+		"args are files instead of oids")
+
+	var debug = debug.For("cmd.parseDeleteArgs")
+
+	if len(args) < 2 {
+		debug.Printf("no args specified")
+		return nil, option, ErrUsage
+	}
+
+	option.flags.Parse(args[1:])
+	option.args = option.flags.Args()
+	if (option.tagspec == "") == (len(option.args) == 0) {
+		debug.Printf("either tags flag or object args are required")
+		return nil, option, ErrUsage
 	}
 
 	return deleteCommand, option, nil
 }
 
-func deleteCommand(ctx context.Context, option Option) error {
+func deleteCommand(ctx context.Context, option0 Option) error {
 	var err = errors.For("cmd.deleteCommand")
 
-	option, ok := option.(deleteOption)
+	option, ok := option0.(deleteOption)
 	if !ok {
-		return err.InvalidArg("expecting deleteOption - %v", option)
+		return err.InvalidArg("expecting deleteOption - %v", option0)
+	}
+
+	session, e := gart.OpenSession(ctx, gart.Remove)
+	if e != nil {
+		return err.Error("could not open session - %v", e)
+	}
+	log.Log("session - begin")
+
+	var n int
+	switch option.tagspec {
+	case "":
+		var oids []*system.Oid
+		if oids, e = resolveOids(option.args, option.usepath); e != nil {
+			break
+		}
+		for _, oid := range oids {
+			var deleted bool
+			if deleted, e = interruptibleDelete(ctx, session, oid); e != nil {
+				break
+			}
+			if deleted {
+				n++
+			}
+		}
+	default:
+		n, e = session.DeleteObjectsByTag(parseCsv(option.tagspec)...)
+	}
+	if e != nil {
+		log.Error(e.Error())
+	}
+
+	var commit = e == nil // do not commit on any error
+	if ec := session.Close(commit); ec != nil {
+		panic(err.Fault("on session close - %v", ec))
+	}
+	log.Log("session - close - %d objects deleted - commit:%t", n, commit)
+
+	return e
+}
+
+func interruptibleDelete(ctx context.Context, session gart.Session, oid *system.Oid) (bool, error) {
+	select {
+	case <-ctx.Done():
+		return false, ErrInterrupt
+	default:
+		deleted, e := session.DeleteObject(oid)
+		if e != nil {
+			return false, e
+		}
+		log.Log("%s (deleted: %t)", oid.Fingerprint(), deleted)
+		return deleted, nil
 	}
-	return err.NotImplemented()
 }
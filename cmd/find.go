@@ -7,6 +7,9 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/alphazero/gart"
 	"github.com/alphazero/gart/index"
@@ -22,8 +25,10 @@ type findOption struct {
 	incTags, exTags   string
 	incTypes, exTypes string
 	incExts, exExts   string
-	date              string // TODO ex: -d [-/+]mar-19-2018
+	expr              string
+	since, until      string // see parseDate
 	digest            bool
+	explain           bool
 }
 
 func parseFindArgs(args []string) (Command, Option, error) {
@@ -31,13 +36,16 @@ func parseFindArgs(args []string) (Command, Option, error) {
 
 	option.flags = flag.NewFlagSet("gart find", flag.ExitOnError)
 	option.usingVerboseFlag("verbose cmd op")
+	option.usingWaitFlag()
 	option.flags.BoolVar(&option.digest, "digest", option.digest,
 		"print single line digest of matching object")
+	option.flags.BoolVar(&option.explain, "explain", option.explain,
+		"print the query plan instead of matching objects")
 
 	option.flags.StringVar(&option.incTypes, "types", option.incTypes,
-		"objects of type (csv list of {file, text, url, uri})")
+		"objects of type (csv list of {file, text, url, data})")
 	option.flags.StringVar(&option.exTypes, "x-types", option.exTypes,
-		"exclude objects of type (csv list of {file, text, url, uri})")
+		"exclude objects of type (csv list of {file, text, url, data})")
 
 	option.flags.StringVar(&option.incExts, "exts", option.incExts,
 		"file objects with extention (csv list)")
@@ -49,6 +57,14 @@ func parseFindArgs(args []string) (Command, Option, error) {
 	option.flags.StringVar(&option.exTags, "x-tags", option.exTags,
 		"exclude objects with tags (csv list)")
 
+	option.flags.StringVar(&option.since, "since", option.since,
+		"objects added on or after date (e.g. 2018-03-01, mar-01-2018, 7d, 2w)")
+	option.flags.StringVar(&option.until, "until", option.until,
+		"objects added on or before date (e.g. 2018-04-15, apr-15-2018, 7d, 2w)")
+
+	option.flags.StringVar(&option.expr, "q", option.expr,
+		"objects matching query expression, e.g. \"(jazz | blues) & !bootleg & ext:flac\" (quote tags with spaces: '\"tag 2\" & a')")
+
 	// default gart find w/ no tags returns all objects
 	if len(args) > 1 {
 		option.flags.Parse(args[1:])
@@ -68,6 +84,26 @@ func findCommand(ctx context.Context, option0 Option) error {
 	}
 	debug.Printf("options:%v\n", option)
 
+	/// query expression ////////////////////////////////////////////
+
+	var qexpr index.Query
+	var e error
+	if option.expr != "" {
+		if qexpr, e = index.ParseQuery(option.expr); e != nil {
+			return e
+		}
+	}
+
+	/// date range //////////////////////////////////////////////////
+
+	var since, until time.Time
+	if since, e = parseDate(option.since, time.Now()); e != nil {
+		return err.InvalidArg("since - %v", e)
+	}
+	if until, e = parseDate(option.until, time.Now()); e != nil {
+		return err.InvalidArg("until - %v", e)
+	}
+
 	/// gart session ////////////////////////////////////////////////
 
 	var ctxChild, cancel = context.WithCancel(ctx)
@@ -102,6 +138,28 @@ func findCommand(ctx context.Context, option0 Option) error {
 		qbuilder.ExcludeTags(systemic.ExtTag(s))
 	}
 
+	// query expression
+	if qexpr != nil {
+		qbuilder.Where(qexpr)
+	}
+
+	// date range
+	if !since.IsZero() || !until.IsZero() {
+		qbuilder.InDateRange(since, until)
+	}
+
+	/// explain /////////////////////////////////////////////////////
+
+	if option.explain {
+		plan, e := session.Explain(qbuilder.Build())
+		if e != nil {
+			return e
+		}
+		plan.Print(os.Stdout)
+		cancel()
+		return nil
+	}
+
 	/// async exec //////////////////////////////////////////////////
 
 	oc, ec := session.AsyncExec(qbuilder.Build())
@@ -125,12 +183,21 @@ loop:
 				switch card.Type() {
 				case system.Text:
 					digest = card.(index.TextCard).Text()
+				case system.URL:
+					digest = card.(index.URLCard).URL()
+				case system.Data:
+					dcard := card.(index.DataCard)
+					digest = fmt.Sprintf("(bytes: %d) %s", dcard.Size(), dcard.Label())
 				case system.File:
 					fcard := card.(index.FileCard)
 					paths := fcard.Paths()
 					if len(paths) > 1 {
 						digest = fmt.Sprintf("(dup:%d) ", len(paths)-1)
 					}
+					if len(paths) == 0 {
+						digest = "(no paths)"
+						break
+					}
 					digest += fmt.Sprintf("%s", paths[0])
 				}
 				fmt.Printf("oid:%s version:%d [%s] %s\n",
@@ -146,3 +213,28 @@ loop:
 
 	return e
 }
+
+// parseDate parses the date spec. Supported forms are yyyy-mm-dd, mmm-dd-yyyy
+// (as in systemic day tags), and relative to now, Nd (days) and Nw (weeks).
+//
+// Returns the zero time for a zero-len spec.
+func parseDate(spec string, now time.Time) (time.Time, error) {
+	spec = strings.ToLower(strings.TrimSpace(spec))
+	if spec == "" {
+		return time.Time{}, nil
+	}
+	if n := len(spec) - 1; n > 0 && (spec[n] == 'd' || spec[n] == 'w') {
+		if cnt, e := strconv.Atoi(spec[:n]); e == nil && cnt >= 0 {
+			if spec[n] == 'w' {
+				cnt *= 7
+			}
+			return now.AddDate(0, 0, -cnt), nil
+		}
+	}
+	for _, layout := range []string{"2006-01-02", "Jan-02-2006"} {
+		if t, e := time.ParseInLocation(layout, spec, time.Local); e == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.Error("invalid date %q", spec)
+}
@@ -0,0 +1,57 @@
+// Doost!
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDate(t *testing.T) {
+	var now = time.Date(2018, time.March, 15, 13, 30, 0, 0, time.Local)
+	var date = func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+	}
+	var tests = []struct {
+		spec   string
+		expect time.Time
+	}{
+		{"", time.Time{}},
+		{"  ", time.Time{}},
+		// yyyy-mm-dd
+		{"2018-03-01", date(2018, 3, 1)},
+		{" 2018-12-31 ", date(2018, 12, 31)},
+		{"2020-02-29", date(2020, 2, 29)},
+		// mmm-dd-yyyy
+		{"mar-01-2018", date(2018, 3, 1)},
+		{"Apr-15-2018", date(2018, 4, 15)},
+		{"DEC-31-2017", date(2017, 12, 31)},
+		// relative - the date of now is significant (see query.InDateRange)
+		{"0d", now},
+		{"1d", now.AddDate(0, 0, -1)},
+		{"7d", now.AddDate(0, 0, -7)},
+		{"15d", date(2018, 2, 28).Add(13*time.Hour + 30*time.Minute)},
+		{"2w", now.AddDate(0, 0, -14)},
+		{"1W", now.AddDate(0, 0, -7)},
+	}
+	for _, test := range tests {
+		have, e := parseDate(test.spec, now)
+		if e != nil {
+			t.Fatalf("parseDate(%q) - %v", test.spec, e)
+		}
+		if !have.Equal(test.expect) {
+			t.Fatalf("parseDate(%q) have:%s - expect:%s", test.spec, have, test.expect)
+		}
+	}
+
+	for _, spec := range []string{
+		"d", "w", "-1d", "7x", "1.5d", "7 d",
+		"2018-3-1", "2018/03/01", "2018-02-30", "2018-13-01",
+		"mar-1-2018", "march-01-2018", "xyz-01-2018", "03-01-2018",
+		"yesterday",
+	} {
+		if have, e := parseDate(spec, now); e == nil {
+			t.Fatalf("parseDate(%q) - expected error - have:%s", spec, have)
+		}
+	}
+}
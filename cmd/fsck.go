@@ -0,0 +1,54 @@
+// Doost!
+
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+
+	"github.com/alphazero/gart"
+	"github.com/alphazero/gart/syslib/errors"
+)
+
+type fsckOption struct {
+	cmdOption
+	repair bool
+}
+
+// gart fsck
+// gart fsck -repair
+func parseFsckArgs(args []string) (Command, Option, error) {
+	var option fsckOption
+
+	option.flags = flag.NewFlagSet("gart fsck", flag.ExitOnError)
+	option.usingVerboseFlag0()
+	option.usingWaitFlag()
+	option.flags.BoolVar(&option.repair, "repair", option.repair,
+		"repair the discrepancies that can be fixed")
+
+	if len(args) > 1 {
+		option.flags.Parse(args[1:])
+	}
+
+	return fsckCommand, option, nil
+}
+
+func fsckCommand(ctx context.Context, option0 Option) error {
+	var err = errors.For("cmd.fsckCommand")
+
+	option, ok := option0.(fsckOption)
+	if !ok {
+		return err.InvalidArg("expecting fsckOption - %v", option0)
+	}
+
+	report, e := gart.Fsck(option.repair)
+	if e != nil {
+		return e
+	}
+	report.Print(os.Stdout)
+	if n := report.Unrepaired(); n > 0 {
+		return err.Error("%d unrepaired issues", n)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+// Doost!
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alphazero/gart"
+	"github.com/alphazero/gart/index"
+	"github.com/alphazero/gart/syslib/debug"
+	"github.com/alphazero/gart/syslib/errors"
+	"github.com/alphazero/gart/system"
+	"github.com/alphazero/gart/system/log"
+)
+
+type getOption struct {
+	cmdOption
+	tagspec string
+	output  string
+	dest    string
+	force   bool
+	args    []string
+}
+
+// gart get <oid-prefix> ...
+// gart get <oid-prefix> -o path
+// gart get -tags "tag1, tag2" -dest dir/
+func parseGetArgs(args []string) (Command, Option, error) {
+	var option = getOption{
+		dest: ".",
+	}
+
+	option.flags = flag.NewFlagSet("gart get", flag.ExitOnError)
+	option.usingVerboseFlag0()
+	option.usingWaitFlag()
+	option.flags.StringVar(&option.tagspec, "tags", option.tagspec,
+		"get objects with tags (csv list) instead of args")
+	option.flags.StringVar(&option.output, "o", option.output,
+		"output file path - single object only")
+	option.flags.StringVar(&option.dest, "dest", option.dest,
+		"output directory - files are named per the original path basename, and same\n"+
+			"named files are disambiguated by oid prefix, e.g. a.<oid-prefix>.txt")
+	option.flags.BoolVar(&option.force, "force", option.force,
+		"overwrite existing files")
+
+	var debug = debug.For("cmd.parseGetArgs")
+
+	if len(args) < 2 {
+		debug.Printf("no args specified")
+		return nil, option, ErrUsage
+	}
+
+	// flags may follow the object args, e.g. gart get <oid-prefix> -o path
+	option.flags.Parse(args[1:])
+	for rest := option.flags.Args(); len(rest) > 0; rest = option.flags.Args() {
+		option.args = append(option.args, rest[0])
+		option.flags.Parse(rest[1:])
+	}
+	if (option.tagspec == "") == (len(option.args) == 0) {
+		debug.Printf("either tags flag or object args are required")
+		return nil, option, ErrUsage
+	}
+	if option.output != "" && len(option.args) != 1 {
+		debug.Printf("output flag requires a single object arg")
+		return nil, option, ErrUsage
+	}
+
+	return getCommand, option, nil
+}
+
+func getCommand(ctx context.Context, option0 Option) error {
+	var err = errors.For("cmd.getCommand")
+
+	option, ok := option0.(getOption)
+	if !ok {
+		return err.InvalidArg("expecting getOption - %v", option0)
+	}
+
+	session, e := gart.OpenSession(ctx, gart.Find)
+	if e != nil {
+		return err.Error("could not open session - %v", e)
+	}
+	defer func() {
+		session.Close(false)
+		log.Log("session - close")
+	}()
+	log.Log("session - begin")
+
+	var cards []index.Card
+	switch option.tagspec {
+	case "":
+		cards, e = resolveCards(option.args, false)
+	default:
+		cards, e = selectCards(ctx, session, parseCsv(option.tagspec))
+	}
+	if e != nil {
+		return e
+	}
+
+	// all files are checked before any is written
+	var filenames = getFilenames(cards, option.dest)
+	if option.output != "" {
+		filenames[0] = option.output
+	}
+	for i, filename := range filenames {
+		if filename == "" || option.force {
+			continue
+		}
+		if _, e := os.Stat(filename); e == nil {
+			return err.Error("%s - file %q exists", cards[i].Oid().Fingerprint(), filename)
+		}
+	}
+
+	var n int
+	for i, card := range cards {
+		select {
+		case <-ctx.Done():
+			return ErrInterrupt
+		default:
+		}
+		switch card.Type() {
+		case system.Text:
+			fmt.Fprintf(os.Stdout, "%s\n", card.(index.TextCard).Text())
+		case system.URL:
+			fmt.Fprintf(os.Stdout, "%s\n", card.(index.URLCard).URL())
+		case system.File, system.Data:
+			// objects selected by tags may not be stored
+			if !card.IsStored() && option.tagspec != "" {
+				log.Log("%s is not stored - skipped", card.Oid().Fingerprint())
+				continue
+			}
+			var filename = filenames[i]
+			size, e := gart.RestoreObject(card, filename)
+			if e != nil {
+				return e
+			}
+			log.Log("%s (bytes: %d) %q", card.Oid().Fingerprint(), size, filename)
+		default:
+			log.Log("%s is a %s object - skipped", card.Oid().Fingerprint(), card.Type())
+			continue
+		}
+		n++
+	}
+	log.Log("got %d of %d objects", n, len(cards))
+
+	return nil
+}
+
+// getFilenames returns the filenames in dest of the file and data objects (see
+// getFilename), or "" for objects of other types. Objects with the same
+// filename are disambiguated by their oid prefix, e.g. a.<oid-prefix>.txt.
+func getFilenames(cards []index.Card, dest string) []string {
+	var filenames = make([]string, len(cards))
+	var count = make(map[string]int)
+	for i, card := range cards {
+		switch card.Type() {
+		case system.File, system.Data:
+			filenames[i] = getFilename(card)
+			count[filenames[i]]++
+		}
+	}
+	for i, filename := range filenames {
+		if filename == "" {
+			continue
+		}
+		if count[filename] > 1 {
+			var ext = filepath.Ext(filename)
+			var prefix = cards[i].Oid().String()[:system.FingerprintSize]
+			filename = fmt.Sprintf("%s.%s%s", strings.TrimSuffix(filename, ext), prefix, ext)
+		}
+		filenames[i] = filepath.Join(dest, filename)
+	}
+	return filenames
+}
+
+// getFilename returns the basename of the first recorded path of the file
+// object, or the basename of the label of the data object, or the oid if the
+// object has neither.
+func getFilename(card index.Card) string {
+	switch card := card.(type) {
+	case index.FileCard:
+		if paths := card.Paths(); len(paths) > 0 {
+			return filepath.Base(paths[0])
+		}
+	case index.DataCard:
+		switch label := filepath.Base(card.Label()); label {
+		case ".", "..", string(filepath.Separator):
+		default:
+			return label
+		}
+	}
+	return card.Oid().String()
+}
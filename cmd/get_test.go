@@ -0,0 +1,52 @@
+// Doost!
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/alphazero/gart/index"
+	"github.com/alphazero/gart/syslib/digest"
+	"github.com/alphazero/gart/system"
+)
+
+func TestGetFilenames(t *testing.T) {
+	var oid = func(content string) *system.Oid {
+		var md = digest.Sum([]byte(content))
+		oid, e := system.NewOid(md[:])
+		if e != nil {
+			t.Fatalf("NewOid - %v", e)
+		}
+		return oid
+	}
+	var prefix = func(oid *system.Oid) string { return oid.String()[:system.FingerprintSize] }
+	var oids = []*system.Oid{oid("0"), oid("1"), oid("2"), oid("3"), oid("4"), oid("5")}
+
+	var cards = make([]index.Card, len(oids))
+	var must = func(card index.Card, e error) index.Card {
+		if e != nil {
+			t.Fatalf("%v", e)
+		}
+		return card
+	}
+	cards[0] = must(index.NewFileCard(oids[0], "/a/x.txt"))
+	cards[1] = must(index.NewFileCard(oids[1], "/b/x.txt"))
+	cards[2] = must(index.NewFileCard(oids[2], "/a/y.txt"))
+	cards[3] = must(index.NewDataCard(oids[3], 1, "x.txt"))
+	cards[4] = must(index.NewTextCard(oids[4], "x.txt"))
+	cards[5] = must(index.NewDataCard(oids[5], 1, ""))
+
+	var expect = []string{
+		fmt.Sprintf("dest/x.%s.txt", prefix(oids[0])),
+		fmt.Sprintf("dest/x.%s.txt", prefix(oids[1])),
+		"dest/y.txt",
+		fmt.Sprintf("dest/x.%s.txt", prefix(oids[3])),
+		"",
+		"dest/" + oids[5].String(),
+	}
+	var have = getFilenames(cards, "dest")
+	if fmt.Sprint(have) != fmt.Sprint(expect) {
+		t.Fatalf("filenames have:%q - expect:%q", have, expect)
+	}
+}
@@ -5,22 +5,34 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
+	"os"
 
+	"github.com/alphazero/gart"
 	"github.com/alphazero/gart/syslib/errors"
 )
 
 type listOption struct {
 	cmdOption
-	force bool // XXX
+	systemics bool
+	prefix    string
 }
 
+// gart list
+// gart list -systemic -prefix "systemic:ext:"
 func parseListArgs(args []string) (Command, Option, error) {
 	var option listOption
 
-	flags := flag.NewFlagSet("gart-list", flag.ExitOnError)
-	flags.BoolVar(&option.force, "force", option.force, "force re-listialization of repo")
+	option.flags = flag.NewFlagSet("gart list", flag.ExitOnError)
+	option.usingVerboseFlag0()
+	option.usingWaitFlag()
+	option.flags.BoolVar(&option.systemics, "systemic", option.systemics,
+		"include systemic tags")
+	option.flags.StringVar(&option.prefix, "prefix", option.prefix,
+		"list tags with prefix")
+
 	if len(args) > 1 {
-		flags.Parse(args[1:])
+		option.flags.Parse(args[1:])
 	}
 
 	return listCommand, option, nil
@@ -29,9 +41,17 @@ func parseListArgs(args []string) (Command, Option, error) {
 func listCommand(ctx context.Context, option0 Option) error {
 	var err = errors.For("cmd.listCommand")
 
-	_, ok := option0.(listOption)
+	option, ok := option0.(listOption)
 	if !ok {
 		return err.InvalidArg("expecting listOption - %v", option0)
 	}
-	return err.NotImplemented()
+
+	tags, e := gart.ListTags(option.systemics, option.prefix)
+	if e != nil {
+		return e
+	}
+	for _, tag := range tags {
+		fmt.Fprintf(os.Stdout, "%8d %s\n", tag.Count, tag.Name)
+	}
+	return nil
 }
@@ -0,0 +1,49 @@
+// Doost!
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/alphazero/gart"
+	"github.com/alphazero/gart/syslib/errors"
+)
+
+type reindexOption struct {
+	cmdOption
+}
+
+// gart reindex
+func parseReindexArgs(args []string) (Command, Option, error) {
+	var option reindexOption
+
+	option.flags = flag.NewFlagSet("gart reindex", flag.ExitOnError)
+	option.usingVerboseFlag0()
+	option.usingWaitFlag()
+
+	if len(args) > 1 {
+		option.flags.Parse(args[1:])
+	}
+
+	return reindexCommand, option, nil
+}
+
+func reindexCommand(ctx context.Context, option0 Option) error {
+	var err = errors.For("cmd.reindexCommand")
+
+	_, ok := option0.(reindexOption)
+	if !ok {
+		return err.InvalidArg("expecting reindexOption - %v", option0)
+	}
+
+	stats, e := gart.Reindex()
+	if e != nil {
+		return e
+	}
+	fmt.Fprintf(os.Stdout, "reindexed - cards:%d (deleted:%d migrated:%d) tagmaps:%d\n",
+		stats.Cards, stats.Deleted, stats.Migrated, stats.Tagmaps)
+	return nil
+}
@@ -6,21 +6,57 @@ import (
 	"context"
 	"flag"
 
+	"github.com/alphazero/gart"
+	"github.com/alphazero/gart/index"
+	"github.com/alphazero/gart/syslib/debug"
 	"github.com/alphazero/gart/syslib/errors"
+	"github.com/alphazero/gart/system"
+	"github.com/alphazero/gart/system/log"
 )
 
 type tagOption struct {
 	cmdOption
-	force bool // XXX
+	addspec    string
+	removespec string
+	queryspec  string
+	usepath    bool
+	args       []string
 }
 
+// gart tag -add "tag1, tag2" -remove "tag3" <oid-prefix> ...
+// gart tag -add "tag1" -file f1.ext f2.ext ...
+// gart tag -remove "tag3" -query "tag1, tag2"
 func parseTagArgs(args []string) (Command, Option, error) {
 	var option tagOption
 
-	flags := flag.NewFlagSet("gart-tag", flag.ExitOnError)
-	flags.BoolVar(&option.force, "force", option.force, "force re-tagialization of repo")
-	if len(args) > 1 {
-		flags.Parse(args[1:])
+	option.flags = flag.NewFlagSet("gart tag", flag.ExitOnError)
+	option.usingVerboseFlag0()
+	option.usingWaitFlag()
+	option.flags.StringVar(&option.addspec, "add", option.addspec,
+		"csv list of tags to apply to object(s)")
+	option.flags.StringVar(&option.removespec, "remove", option.removespec,
+		"csv list of tags to remove from object(s)")
+	option.flags.StringVar(&option.queryspec, "query", option.queryspec,
+		"select objects with tags (csv list) instead of args")
+	option.flags.BoolVar(&option.usepath, "file", option.usepath,
+		"args are files instead of oids")
+
+	var debug = debug.For("cmd.parseTagArgs")
+
+	if len(args) < 2 {
+		debug.Printf("no flags specified")
+		return nil, option, ErrUsage
+	}
+
+	option.flags.Parse(args[1:])
+	if option.addspec == "" && option.removespec == "" {
+		debug.Printf("one of add or remove flags is required")
+		return nil, option, ErrUsage
+	}
+	option.args = option.flags.Args()
+	if (option.queryspec == "") == (len(option.args) == 0) {
+		debug.Printf("either query flag or object args are required")
+		return nil, option, ErrUsage
 	}
 
 	return tagCommand, option, nil
@@ -29,9 +65,144 @@ func parseTagArgs(args []string) (Command, Option, error) {
 func tagCommand(ctx context.Context, option0 Option) error {
 	var err = errors.For("cmd.tagCommand")
 
-	_, ok := option0.(tagOption)
+	option, ok := option0.(tagOption)
 	if !ok {
 		return err.InvalidArg("expecting tagOption - %v", option0)
 	}
-	return err.NotImplemented()
+
+	session, e := gart.OpenSession(ctx, gart.Tag)
+	if e != nil {
+		return err.Error("could not open session - %v", e)
+	}
+	log.Log("session - begin")
+
+	var oids []*system.Oid
+	switch option.queryspec {
+	case "":
+		oids, e = resolveOids(option.args, option.usepath)
+	default:
+		oids, e = selectOids(ctx, session, parseCsv(option.queryspec))
+	}
+
+	var add = parseCsv(option.addspec)
+	var remove = parseCsv(option.removespec)
+	var n int
+	if e == nil {
+		for _, oid := range oids {
+			var updated bool
+			if updated, e = interruptibleTag(ctx, session, oid, add, remove); e != nil {
+				break
+			}
+			if updated {
+				n++
+			}
+		}
+	}
+	if e != nil {
+		log.Error(e.Error())
+	}
+
+	var commit = e == nil // do not commit on any error
+	if ec := session.Close(commit); ec != nil {
+		panic(err.Fault("on session close - %v", ec))
+	}
+	log.Log("session - close - %d of %d objects updated - commit:%t", n, len(oids), commit)
+
+	return e
+}
+
+func interruptibleTag(ctx context.Context, session gart.Session, oid *system.Oid, add, remove []string) (bool, error) {
+	select {
+	case <-ctx.Done():
+		return false, ErrInterrupt
+	default:
+		added, removed, e := session.UpdateTags(oid, add, remove)
+		if e != nil {
+			return false, e
+		}
+		log.Log("%s added:%q removed:%q", oid.Fingerprint(), added, removed)
+		return len(added)+len(removed) > 0, nil
+	}
+}
+
+// resolveOids returns the oids of the objects specified by args. See
+// resolveCards.
+func resolveOids(args []string, usepath bool) ([]*system.Oid, error) {
+	cards, e := resolveCards(args, usepath)
+	if e != nil {
+		return nil, e
+	}
+	var oids = make([]*system.Oid, len(cards))
+	for i, card := range cards {
+		oids[i] = card.Oid()
+	}
+	return oids, nil
+}
+
+// resolveCards returns the cards of the objects specified by args. Args are
+// either oid prefixes or, if usepath is true, paths of files. An oid prefix
+// must identify exactly one object.
+func resolveCards(args []string, usepath bool) ([]index.Card, error) {
+	var err = errors.For("cmd.resolveCards")
+
+	var resolved []index.Card
+	for _, spec := range args {
+		var oidspec = spec
+		if usepath {
+			oid, e := getOidForFile(spec)
+			if e != nil {
+				return nil, err.ErrorWithCause(e, "file %q", spec)
+			}
+			oidspec = oid.String()
+		}
+		cards, e := gart.FindCard(oidspec)
+		if e != nil {
+			return nil, e
+		}
+		switch len(cards) {
+		case 0:
+			return nil, err.Error("no objects found for %q", spec)
+		case 1:
+			resolved = append(resolved, cards[0])
+		default:
+			return nil, err.Error("ambiguous oid %q matches %d objects", spec, len(cards))
+		}
+	}
+	return resolved, nil
+}
+
+// selectOids returns the oids of all objects tagged with all of the given tags.
+func selectOids(ctx context.Context, session gart.Session, tags []string) ([]*system.Oid, error) {
+	cards, e := selectCards(ctx, session, tags)
+	if e != nil {
+		return nil, e
+	}
+	var oids = make([]*system.Oid, len(cards))
+	for i, card := range cards {
+		oids[i] = card.Oid()
+	}
+	return oids, nil
+}
+
+// selectCards returns the cards of all objects tagged with all of the given
+// tags.
+func selectCards(ctx context.Context, session gart.Session, tags []string) ([]index.Card, error) {
+	var qbuilder = gart.NewQuery()
+	qbuilder.IncludeTags(tags...)
+
+	var cards []index.Card
+	oc, ec := session.AsyncExec(qbuilder.Build())
+	for {
+		select {
+		case obj := <-oc:
+			if obj == nil {
+				return cards, nil // done
+			}
+			cards = append(cards, obj.(index.Card))
+		case e := <-ec:
+			if e != nil {
+				return nil, e
+			}
+		}
+	}
 }
@@ -5,22 +5,50 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
+	"os"
 
+	"github.com/alphazero/gart"
+	"github.com/alphazero/gart/index"
 	"github.com/alphazero/gart/syslib/errors"
+	"github.com/alphazero/gart/system"
+	"github.com/alphazero/gart/system/log"
+	"github.com/alphazero/gart/system/systemic"
 )
 
 type updateOption struct {
 	cmdOption
-	force bool // XXX
+	tagspec string
 }
 
+const updateUsage = `Usage of gart update:
+  Re-verifies the recorded paths of file objects. A changed file is added as a
+  new object, which supersedes the object once none of its paths remain. A
+  missing file is reported as moved if a file with the same content is found in
+  any directory of the recorded paths of file objects. If the content is not in
+  the hash cache (i.e. its size is not known), only visible files with the same
+  extension in the same directory are considered, and the search is abandoned
+  if there are more than %d such files. Otherwise the path is reported as
+  missing.
+`
+
+// gart update
+// gart update -tags "tag1, tag2"
 func parseUpdateArgs(args []string) (Command, Option, error) {
 	var option updateOption
 
-	flags := flag.NewFlagSet("gart-update", flag.ExitOnError)
-	flags.BoolVar(&option.force, "force", option.force, "force re-updateialization of repo")
+	option.flags = flag.NewFlagSet("gart update", flag.ExitOnError)
+	option.usingVerboseFlag0()
+	option.usingWaitFlag()
+	option.flags.StringVar(&option.tagspec, "tags", option.tagspec,
+		"update file objects with tags (csv list)")
+	option.flags.Usage = func() {
+		fmt.Fprintf(option.flags.Output(), updateUsage, index.MaxMoveCandidates)
+		option.flags.PrintDefaults()
+	}
+
 	if len(args) > 1 {
-		flags.Parse(args[1:])
+		option.flags.Parse(args[1:])
 	}
 
 	return updateCommand, option, nil
@@ -29,9 +57,67 @@ func parseUpdateArgs(args []string) (Command, Option, error) {
 func updateCommand(ctx context.Context, option0 Option) error {
 	var err = errors.For("cmd.updateCommand")
 
-	_, ok := option0.(updateOption)
+	option, ok := option0.(updateOption)
 	if !ok {
 		return err.InvalidArg("expecting updateOption - %v", option0)
 	}
-	return err.NotImplemented()
+
+	session, e := gart.OpenSession(ctx, gart.Update)
+	if e != nil {
+		return err.Error("could not open session - %v", e)
+	}
+	log.Log("session - begin")
+
+	var tags = append(parseCsv(option.tagspec), systemic.TypeTag(system.File.String()))
+	oids, e := selectOids(ctx, session, tags)
+
+	var n int
+	if e == nil {
+		for _, oid := range oids {
+			var updates []index.PathUpdate
+			if updates, e = interruptibleUpdate(ctx, session, oid); e != nil {
+				break
+			}
+			if len(updates) > 0 {
+				n++
+			}
+			for _, update := range updates {
+				emitPathUpdate(oid, update)
+			}
+		}
+	}
+	if e != nil {
+		log.Error(e.Error())
+	}
+
+	var commit = e == nil // do not commit on any error
+	if ec := session.Close(commit); ec != nil {
+		panic(err.Fault("on session close - %v", ec))
+	}
+	log.Log("session - close - %d of %d objects updated - commit:%t", n, len(oids), commit)
+
+	return e
+}
+
+func interruptibleUpdate(ctx context.Context, session gart.Session, oid *system.Oid) ([]index.PathUpdate, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ErrInterrupt
+	default:
+		return session.UpdateFile(oid)
+	}
+}
+
+func emitPathUpdate(oid *system.Oid, update index.PathUpdate) {
+	switch update.Status {
+	case index.PathMoved:
+		fmt.Fprintf(os.Stdout, "%-8s oid:%s %s -> %s\n",
+			update.Status, oid.Fingerprint(), update.Path, update.NewPath)
+	case index.PathMissing:
+		fmt.Fprintf(os.Stdout, "%-8s oid:%s %s\n",
+			update.Status, oid.Fingerprint(), update.Path)
+	case index.PathModified:
+		fmt.Fprintf(os.Stdout, "%-8s oid:%s %s -> oid:%s\n",
+			update.Status, oid.Fingerprint(), update.Path, update.NewOid.Fingerprint())
+	}
 }
@@ -4,15 +4,20 @@ package gart
 
 import (
 	"context"
-	"os"
+	"io"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/alphazero/gart/index"
 	"github.com/alphazero/gart/repo"
+	"github.com/alphazero/gart/store"
 	"github.com/alphazero/gart/syslib/debug"
+	"github.com/alphazero/gart/syslib/digest"
 	"github.com/alphazero/gart/syslib/errors"
+	"github.com/alphazero/gart/syslib/fs"
 	"github.com/alphazero/gart/system"
+	"github.com/alphazero/gart/system/systemic"
 )
 
 /// errors /////////////////////////////////////////////////////////////////////
@@ -21,12 +26,6 @@ var (
 	ErrIgnoredPath = errors.Error("ignored path")
 )
 
-/// invariants /////////////////////////////////////////////////////////////////
-
-// TODO .gartignore files and paths
-var ignoredPaths = []string{".gart/", ".git/", ".git_vendor/"}
-var ignoredExts = []string{".jar", ".pom", ".bin", ".class", ".xml", ".lock", ".o"}
-
 /// stateless ops //////////////////////////////////////////////////////////////
 
 func InitRepo(force bool) (bool, error) {
@@ -53,8 +52,109 @@ func FindCard(oidspec string) ([]index.Card, error) {
 	return index.FindCard(strings.Split(oidspec, ".")[0])
 }
 
+// RestoreObject writes the stored content of the file or data object to the
+// named file. The content is verified against the object oid. See store.Get.
+//
+// Returns the number of bytes written, and nil on success.
+func RestoreObject(card index.Card, filename string) (int64, error) {
+	var err = errors.For("gart.RestoreObject")
+
+	if t := card.Type(); t != system.File && t != system.Data {
+		return 0, err.InvalidArg("%s is a %s object", card.Oid().Fingerprint(), t)
+	}
+	if !card.IsStored() {
+		return 0, err.Error("%s is not stored", card.Oid().Fingerprint())
+	}
+	n, e := store.Get(card.Oid(), filename)
+	if e != nil {
+		return 0, err.ErrorWithCause(e, "oid:%s", card.Oid().Fingerprint())
+	}
+	return n, nil
+}
+
+// WalkFiles walks the file tree rooted at root (see fs.WalkTree), calling fn
+// with the absolute path of each regular file that is not ignored. Ignored
+// directories (e.g. .git/) are pruned, and are not read. Paths that could not
+// be read are passed to fn with the error.
+//
+// The walk stops on the first error returned by fn, which is returned.
+func WalkFiles(root string, option fs.WalkOption, fn func(path string, e error) error) error {
+	var err = errors.For("gart.WalkFiles")
+
+	root, e := filepath.Abs(root)
+	if e != nil {
+		return err.ErrorWithCause(e, "unexpected error on filepath.Abs")
+	}
+	option.Prune = func(path string, isDir bool) bool {
+		if isDir {
+			return ignoreDir(path)
+		}
+		return ignoreFile(path)
+	}
+	return fs.WalkTree(root, option, fn)
+}
+
 func NewQuery() index.QueryBuilder { return index.NewQuery() }
 
+// ListTags returns the tag catalog of the repo. Systemic tags are only
+// included if systemics is true. If prefix is not zero-len, only tags with
+// the prefix are listed.
+func ListTags(systemics bool, prefix string) ([]index.TagInfo, error) {
+	var err = errors.For("gart.ListTags")
+
+	tags, e := index.ListTags()
+	if e != nil {
+		return nil, err.ErrorWithCause(e, "on index.ListTags")
+	}
+	var list []index.TagInfo
+	for _, tag := range tags {
+		if !systemics && systemic.IsSystemic(tag.Name) {
+			continue
+		}
+		if !strings.HasPrefix(tag.Name, prefix) {
+			continue
+		}
+		list = append(list, tag)
+	}
+	return list, nil
+}
+
+// Fsck verifies the integrity of the repo index and, if repair is true,
+// repairs the discrepancies that can be fixed. See index.Fsck.
+func Fsck(repair bool) (*index.FsckReport, error) {
+	var err = errors.For("gart.Fsck")
+
+	report, e := index.Fsck(repair)
+	if e != nil {
+		return nil, err.ErrorWithCause(e, "on index.Fsck")
+	}
+	return report, nil
+}
+
+// Reindex rebuilds the tagmaps and tag dictionary of the repo index from the
+// index cards. See index.Reindex.
+func Reindex() (*index.ReindexStats, error) {
+	var err = errors.For("gart.Reindex")
+
+	stats, e := index.Reindex()
+	if e != nil {
+		return nil, err.ErrorWithCause(e, "on index.Reindex")
+	}
+	return stats, nil
+}
+
+// CompactIndex reclaims the keys of deleted objects of the repo index. See
+// index.CompactIndex.
+func CompactIndex() (*index.CompactStats, error) {
+	var err = errors.For("gart.CompactIndex")
+
+	stats, e := index.CompactIndex()
+	if e != nil {
+		return nil, err.ErrorWithCause(e, "on index.CompactIndex")
+	}
+	return stats, nil
+}
+
 /// Session ////////////////////////////////////////////////////////////////////
 
 // Session represents a multi-op gart session.
@@ -66,10 +166,60 @@ type Session interface {
 	// Returns card for object, bool flag indicating if newly added, and nil
 	// on success. On error, the card and flag values are undefined.
 	AddObject(bool, system.Otype, string, ...string) (index.Card, bool, error)
+	// adds the file object with the (pre-computed) digest to the index. If
+	// the file digest has an error, the error is returned. See HashFiles.
+	//
+	// Returns card for object, bool flag indicating if newly added, and nil
+	// on success. On error, the card and flag values are undefined.
+	AddFileDigest(bool, *FileDigest, ...string) (index.Card, bool, error)
+	// adds the data object with content read from the reader (to EOF) and the
+	// (optional) label to the index. If the store flag is true, the content is
+	// also copied to the repo object store. See index.IndexManager#IndexData.
+	//
+	// Returns card for object, bool flag indicating if newly added, and nil
+	// on success. On error, the card and flag values are undefined.
+	AddData(bool, io.Reader, string, bool, ...string) (index.Card, bool, error)
+	// HashFiles computes the digests of the specified files concurrently, with
+	// the given number of workers. Digests are emitted in the order of specs.
+	// See index.IndexManager#SumFile.
+	HashFiles(context.Context, <-chan string, int) <-chan *FileDigest
+	// Sets the rehash flag. If true, file digests are always computed from the
+	// file content, and the hash cache is not consulted.
+	SetRehash(bool)
+	// Updates the tags of an existing object. Tags in the first set are applied
+	// and tags in the second set are removed. Systemic tags can not be updated.
+	//
+	// Returns the tags actually added and removed, and nil on success. On error,
+	// the returned tag sets are undefined.
+	UpdateTags(*system.Oid, []string, []string) ([]string, []string, error)
+	// Updates the file object by re-verifying its recorded paths. See
+	// index.IndexManager#UpdateFile.
+	//
+	// Returns the path updates, and nil on success.
+	UpdateFile(*system.Oid) ([]index.PathUpdate, error)
+	// Stores the content of the file object in the repo object store, as
+	// deduplicated chunks if the flag is true. The file content is verified
+	// against the oid. See index.IndexManager#StoreFile.
+	//
+	// Returns true if object was newly stored, and nil on success.
+	StoreFile(*system.Oid, string, bool) (bool, error)
+	// Deletes the object. The object is removed from all tagmaps, including
+	// the systemic tagmaps, and its card is marked deleted.
+	//
+	// Returns true if object was deleted, false if it was already deleted,
+	// and nil on success.
+	DeleteObject(*system.Oid) (bool, error)
+	// Deletes all objects tagged with all of the specified tags.
+	//
+	// Returns the number of deleted objects, and nil on success.
+	DeleteObjectsByTag(...string) (int, error)
 	// Async processes the given query asynchronously, emitting selected objects
 	// in the first returned channel, and any errors encountered in the second
 	// channel.
 	AsyncExec(query index.Query) (<-chan interface{}, <-chan error)
+	// Explain evaluates the query and returns the query plan. See
+	// index.IndexManager#Explain.
+	Explain(query index.Query) (*index.QueryPlan, error)
 
 	Log() []string
 
@@ -85,6 +235,7 @@ type session struct {
 	idxMode       index.OpMode
 	interrupted   bool
 	transactional bool
+	hashers       sync.WaitGroup // HashFiles workers - joined on Close
 }
 
 func OpenSession(ctx context.Context, op Op) (Session, error) {
@@ -95,7 +246,7 @@ func OpenSession(ctx context.Context, op Op) (Session, error) {
 	var idxMode index.OpMode
 	var transactional bool
 	switch op {
-	case Add, Update, Compact, Tag:
+	case Add, Remove, Update, Compact, Tag:
 		idxMode = index.Write
 		transactional = true
 	case Find:
@@ -124,16 +275,22 @@ func (s *session) Close(commit bool) error {
 	var debug = debug.For("gart#session.Close")
 	debug.Printf("called - op:%s commit:%t s.transactional:%t", s.op, commit, s.transactional)
 
+	// hashing workers use the index
+	s.hashers.Wait()
+
 	if commit {
 		// REVU for now ignore if commit is 'true' on non-transactional sessions
 		if e := s.idx.Close(commit); e != nil {
 			return err.ErrorWithCause(e, "on idx.Close(%t) - op:%s idxMode:%s", commit, s.op, s.idxMode)
 		}
+	} else if s.transactional {
+		if e := s.idx.Rollback(); e != nil {
+			return err.ErrorWithCause(e, "on rollback - op:%s idxMode:%s", s.op, s.idxMode)
+		}
 	} else {
-		if s.transactional {
-			if e := s.idx.Rollback(); e != nil {
-				return err.ErrorWithCause(e, "on rollback - op:%s idxMode:%s", s.op, s.idxMode)
-			}
+		// read-only - releases the repo lock and the index
+		if e := s.idx.Close(false); e != nil {
+			return err.ErrorWithCause(e, "on idx.Close(false) - op:%s idxMode:%s", s.op, s.idxMode)
 		}
 	}
 
@@ -157,43 +314,250 @@ func (s *session) AddObject(strict bool, otype system.Otype, spec string, tags .
 			return nil, false, ErrIgnoredPath
 		}
 		return s.idx.IndexFile(strict, path, tags...)
-	case system.URL, system.URI:
+	case system.URL:
+		return s.idx.IndexURL(strict, spec, tags...)
+	case system.Data:
+		return nil, false, err.InvalidArg("%s objects are added with AddData", otype)
+	case system.URI:
 		return nil, false, err.InvalidArg("%s type not supported", otype)
 	}
 	panic(err.Bug("unreachable"))
 }
 
-var hiddenDir = []byte{os.PathSeparator, '.'}
+func (s *session) AddFileDigest(strict bool, fd *FileDigest, tags ...string) (index.Card, bool, error) {
+	var err = errors.For("gart#session.AddFileDigest")
+	var debug = debug.For("gart#session.AddFileDigest")
+	debug.Printf("called - strict:%t spec:%q tags: %q", strict, fd.Spec, tags)
 
-// ignore returns true if file should be ignored.
-func ignoreFile(path string) bool {
-	// filter hidden files
-	fname := filepath.Base(path)
-	if fname[0] == '.' {
-		return true
+	if s.idxMode != index.Write {
+		return nil, false, err.Bug("invalid idx opmode: %s", s.idxMode)
+	}
+	if fd.Err != nil {
+		return nil, false, fd.Err
 	}
+	return s.idx.IndexFileDigest(strict, fd.Path, fd.Digest, tags...)
+}
+
+func (s *session) AddData(strict bool, r io.Reader, label string, stored bool, tags ...string) (index.Card, bool, error) {
+	var err = errors.For("gart#session.AddData")
+	var debug = debug.For("gart#session.AddData")
+	debug.Printf("called - strict:%t label:%q stored:%t tags: %q", strict, label, stored, tags)
 
-	// filter hidden paths
-	if strings.Contains(path, string(hiddenDir)) {
-		return true
+	if s.idxMode != index.Write {
+		return nil, false, err.Bug("invalid idx opmode: %s", s.idxMode)
 	}
 
-	// filter if path contains ignored path element
-	for _, ignore := range ignoredPaths {
-		if strings.Contains(path, ignore) {
-			return true
+	var oid *system.Oid
+	var size int64
+	var e error
+	if stored {
+		oid, size, _, e = store.PutReader(r)
+		if e != nil {
+			return nil, false, err.ErrorWithCause(e, "on store.PutReader")
+		}
+	} else {
+		var h = digest.New()
+		if size, e = io.Copy(h, r); e != nil {
+			return nil, false, err.ErrorWithCause(e, "on read")
+		}
+		if oid, e = system.NewOid(h.Sum(nil)); e != nil {
+			return nil, false, err.BugWithCause(e, "unexpected")
 		}
 	}
+	return s.idx.IndexData(strict, oid, size, label, stored, tags...)
+}
+
+func (s *session) UpdateTags(oid *system.Oid, add, remove []string) ([]string, []string, error) {
+	var err = errors.For("gart#session.UpdateTags")
+	var debug = debug.For("gart#session.UpdateTags")
+	debug.Printf("called - oid:%s add:%q remove:%q", oid.Fingerprint(), add, remove)
 
-	// filter ignored extensions
-	ext := filepath.Ext(path)
-	for _, ignore := range ignoredExts {
-		if ext == ignore {
-			return true
+	if s.idxMode != index.Write {
+		return nil, nil, err.Bug("invalid idx opmode: %s", s.idxMode)
+	}
+	for _, tags := range [][]string{add, remove} {
+		for _, tag := range tags {
+			if systemic.IsSystemic(tag) {
+				return nil, nil, err.InvalidArg("systemic tag %q", tag)
+			}
 		}
 	}
 
-	return false
+	added, e := s.idx.AddTags(oid, add...)
+	if e != nil {
+		return nil, nil, err.ErrorWithCause(e, "on add tags - oid:%s", oid.Fingerprint())
+	}
+	removed, e := s.idx.RemoveTags(oid, remove...)
+	if e != nil {
+		return nil, nil, err.ErrorWithCause(e, "on remove tags - oid:%s", oid.Fingerprint())
+	}
+	return added, removed, nil
+}
+
+func (s *session) UpdateFile(oid *system.Oid) ([]index.PathUpdate, error) {
+	var err = errors.For("gart#session.UpdateFile")
+	var debug = debug.For("gart#session.UpdateFile")
+	debug.Printf("called - oid:%s", oid.Fingerprint())
+
+	if s.idxMode != index.Write {
+		return nil, err.Bug("invalid idx opmode: %s", s.idxMode)
+	}
+	return s.idx.UpdateFile(oid)
+}
+
+func (s *session) StoreFile(oid *system.Oid, spec string, chunked bool) (bool, error) {
+	var err = errors.For("gart#session.StoreFile")
+	var debug = debug.For("gart#session.StoreFile")
+	debug.Printf("called - oid:%s spec:%q chunked:%t", oid.Fingerprint(), spec, chunked)
+
+	if s.idxMode != index.Write {
+		return false, err.Bug("invalid idx opmode: %s", s.idxMode)
+	}
+	path, e := filepath.Abs(spec)
+	if e != nil {
+		return false, err.ErrorWithCause(e, "unexpected error on filepath.Abs")
+	}
+	return s.idx.StoreFile(oid, path, chunked)
+}
+
+func (s *session) DeleteObject(oid *system.Oid) (bool, error) {
+	var err = errors.For("gart#session.DeleteObject")
+	var debug = debug.For("gart#session.DeleteObject")
+	debug.Printf("called - oid:%s", oid.Fingerprint())
+
+	if s.idxMode != index.Write {
+		return false, err.Bug("invalid idx opmode: %s", s.idxMode)
+	}
+	return s.idx.DeleteObject(oid)
+}
+
+func (s *session) DeleteObjectsByTag(tags ...string) (int, error) {
+	var err = errors.For("gart#session.DeleteObjectsByTag")
+	var debug = debug.For("gart#session.DeleteObjectsByTag")
+	debug.Printf("called - tags:%q", tags)
+
+	if s.idxMode != index.Write {
+		return 0, err.Bug("invalid idx opmode: %s", s.idxMode)
+	}
+	if len(tags) == 0 {
+		return 0, err.InvalidArg("tags is zero-len")
+	}
+	return s.idx.DeleteObjectsByTag(tags...)
+}
+
+/// file hashing ///////////////////////////////////////////////////////////////
+
+// FileDigest is the digest of the content of a file object. See
+// Session#HashFiles.
+type FileDigest struct {
+	Spec   string // file spec as provided
+	Path   string // absolute path of file
+	Digest []byte
+	Err    error // ErrIgnoredPath, or error on read of file
+}
+
+type hashJob struct {
+	spec string
+	res  chan<- *FileDigest
+}
+
+func (s *session) SetRehash(rehash bool) { s.idx.SetRehash(rehash) }
+
+// HashFiles computes the digests of the files specified by specs with a pool
+// of concurrent workers. Ignored files are not read. Digests are emitted in
+// the order of specs, and the returned channel is closed when specs is closed
+// and all digests have been emitted, or when the context is done.
+//
+// At most 2 x workers files are in-flight, regardless of the rate at which
+// digests are consumed. Queued files are skipped once the context is done.
+//
+// Session Close waits for the workers to exit, so either the context must be
+// done or specs closed (and all digests consumed) before the session is closed.
+func (s *session) HashFiles(ctx context.Context, specs <-chan string, workers int) <-chan *FileDigest {
+	var debug = debug.For("gart#session.HashFiles")
+	debug.Printf("called - workers:%d", workers)
+
+	if workers < 1 {
+		workers = 1
+	}
+	var jobs = make(chan hashJob, workers)
+	var pending = make(chan chan *FileDigest, 2*workers) // in spec order
+	var out = make(chan *FileDigest)
+
+	s.hashers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer s.hashers.Done()
+			for job := range jobs {
+				if ctx.Err() != nil {
+					continue // drain - digests are no longer emitted
+				}
+				job.res <- s.hashFile(job.spec) // res is buffered
+			}
+		}()
+	}
+
+	// dispatch
+	go func() {
+		defer close(jobs)
+		defer close(pending)
+		for {
+			var spec string
+			var ok bool
+			select {
+			case spec, ok = <-specs:
+				if !ok {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+			var res = make(chan *FileDigest, 1)
+			select {
+			case pending <- res:
+				jobs <- hashJob{spec, res}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// emit in order
+	go func() {
+		defer close(out)
+		for res := range pending {
+			var fd *FileDigest
+			select {
+			case fd = <-res:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case out <- fd:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+func (s *session) hashFile(spec string) *FileDigest {
+	var fd = &FileDigest{Spec: spec}
+	path, e := filepath.Abs(spec)
+	if e != nil {
+		fd.Err = errors.ErrorWithCause(e, "gart#session.hashFile: unexpected error on filepath.Abs")
+		return fd
+	}
+	fd.Path = path
+	if ignoreFile(path) {
+		fd.Err = ErrIgnoredPath
+		return fd
+	}
+	// note: error is not wrapped - see index.IndexFile
+	fd.Digest, fd.Err = s.idx.SumFile(path)
+	return fd
 }
 
 func (s *session) Log() []string {
@@ -210,25 +574,33 @@ func (s *session) AsyncExec(query index.Query) (<-chan interface{}, <-chan error
 	var ec = make(chan error, 1)
 
 	go func() {
-		oids, e := s.idx.Search(query)
+		rs, e := s.idx.Search(query)
 		if e != nil {
 			debug.Printf("err: %v", e)
 			ec <- e
 			return
 		}
-		debug.Printf("gart.Exec: found %v objects\n", len(oids))
-		//		close(oc) // XXX
-		//		close(ec) // XXX
-		//	return
+		debug.Printf("gart.Exec: found %v objects\n", rs.Count())
 
-		for i, oid := range oids {
+		// oids are resolved as cards are loaded
+		var it = rs.Iterator()
+	loop:
+		for i := 0; ; i++ {
 			select {
 			case <-s.ctx.Done():
 				debug.Printf("loading cards - interrupted")
-				ec <- err.Error("interrupted (loaded %d of %d cards)", i, len(oids))
+				ec <- err.Error("interrupted (loaded %d of %d cards)", i, rs.Count())
 				s.interrupted = true
 				return
 			default:
+				oid, ok, e := it.Next()
+				if e != nil {
+					ec <- err.ErrorWithCause(e, "on resolve of oid %d", i)
+					return
+				}
+				if !ok {
+					break loop // done
+				}
 				card, e := index.LoadCard(oid)
 				if e != nil {
 					ec <- err.ErrorWithCause(e, "on load of oid:%s", oid.Fingerprint())
@@ -243,6 +615,10 @@ func (s *session) AsyncExec(query index.Query) (<-chan interface{}, <-chan error
 	return oc, ec
 }
 
+func (s *session) Explain(query index.Query) (*index.QueryPlan, error) {
+	return s.idx.Explain(query)
+}
+
 /// Op /////////////////////////////////////////////////////////////////////////
 
 type Op byte
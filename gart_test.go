@@ -0,0 +1,381 @@
+// Doost!
+
+package gart
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alphazero/gart/index"
+	"github.com/alphazero/gart/repo"
+	"github.com/alphazero/gart/syslib/digest"
+	"github.com/alphazero/gart/system"
+	"github.com/alphazero/gart/system/systemic"
+)
+
+/// test support ///////////////////////////////////////////////////////////////
+
+// testRepo initializes a repo in a temp directory and relocates the repo paths
+// to it. The returned func restores the repo paths and removes the directory.
+func testRepo(t *testing.T) func() {
+	dir, e := ioutil.TempDir("", "gart")
+	if e != nil {
+		t.Fatalf("%v", e)
+	}
+	var root = filepath.Dir(repo.RepoPath)
+	system.SetRepoRoot(dir)
+	var done = func() {
+		system.SetRepoRoot(root)
+		os.RemoveAll(dir)
+	}
+	if _, e := InitRepo(false); e != nil {
+		done()
+		t.Fatalf("InitRepo - %v", e)
+	}
+	return done
+}
+
+// helperEnv is set in the env of a helper process - see TestHelperProcess.
+const helperEnv = "GART_TEST_HELPER"
+
+// openSessionInProcess opens and closes a session of the op in a separate
+// process, as repo locks are per process.
+func openSessionInProcess(op Op) error {
+	var cmd = exec.Command(os.Args[0], "-test.run=^TestHelperProcess$")
+	cmd.Env = append(os.Environ(),
+		helperEnv+"="+fmt.Sprintf("%d", op),
+		system.RepoEnv+"="+filepath.Dir(repo.RepoPath),
+	)
+	if out, e := cmd.CombinedOutput(); e != nil {
+		return fmt.Errorf("%v - %s", e, out)
+	}
+	return nil
+}
+
+// TestHelperProcess is not a test. It is run by openSessionInProcess.
+func TestHelperProcess(t *testing.T) {
+	var spec = os.Getenv(helperEnv)
+	if spec == "" {
+		return
+	}
+	var op Op
+	if _, e := fmt.Sscanf(spec, "%d", &op); e != nil {
+		fmt.Fprintf(os.Stderr, "invalid op %q\n", spec)
+		os.Exit(2)
+	}
+	session, e := OpenSession(context.Background(), op)
+	if e != nil {
+		fmt.Fprintf(os.Stderr, "OpenSession - %v\n", e)
+		os.Exit(1)
+	}
+	if e := session.Close(false); e != nil {
+		fmt.Fprintf(os.Stderr, "Close - %v\n", e)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+/// tests //////////////////////////////////////////////////////////////////////
+
+func TestSessionCloseReleasesLock(t *testing.T) {
+	defer testRepo(t)()
+
+	for _, commit := range []bool{false, true} {
+		session, e := OpenSession(context.Background(), Find)
+		if e != nil {
+			t.Fatalf("OpenSession - %v", e)
+		}
+		// read sessions do not exclude each other
+		if e := openSessionInProcess(Find); e != nil {
+			t.Fatalf("Find session of another process - %v", e)
+		}
+		if e := openSessionInProcess(Add); e == nil {
+			t.Fatalf("Add session of another process - expected lock error")
+		}
+		if e := session.Close(commit); e != nil {
+			t.Fatalf("Close(%t) - %v", commit, e)
+		}
+		if e := openSessionInProcess(Add); e != nil {
+			t.Fatalf("Close(%t) - Add session of another process - %v", commit, e)
+		}
+	}
+}
+
+func TestSessionUpdateTags(t *testing.T) {
+	defer testRepo(t)()
+
+	session, e := OpenSession(context.Background(), Tag)
+	if e != nil {
+		t.Fatalf("OpenSession - %v", e)
+	}
+	defer session.Close(false)
+
+	card, _, e := session.AddObject(false, system.Text, "x", "a")
+	if e != nil {
+		t.Fatalf("AddObject - %v", e)
+	}
+	var oid = card.Oid()
+	var textTag = systemic.TypeTag(system.Text.String())
+	for _, tags := range [][2][]string{
+		{{textTag}, nil},
+		{{"b"}, {systemic.GartTag()}},
+	} {
+		if _, _, e := session.UpdateTags(oid, tags[0], tags[1]); e == nil {
+			t.Fatalf("UpdateTags(%q, %q) - expected error", tags[0], tags[1])
+		}
+	}
+	added, removed, e := session.UpdateTags(oid, []string{"b", "a"}, []string{"a", "c"})
+	if e != nil {
+		t.Fatalf("UpdateTags - %v", e)
+	}
+	if fmt.Sprint(added, removed) != "[b] [a]" {
+		t.Fatalf("UpdateTags have:%q %q - expect:[b] [a]", added, removed)
+	}
+	var tags []string
+	for _, tag := range card.Tags() {
+		if !systemic.IsSystemic(tag) {
+			tags = append(tags, tag)
+		}
+	}
+	if fmt.Sprint(tags) != "[b]" {
+		t.Fatalf("tags have:%q - expect:[b]", tags)
+	}
+}
+
+// writeFiles writes n files of distinct content and size to dir, and returns
+// their names and contents.
+func writeFiles(t *testing.T, dir string, n int) ([]string, [][]byte) {
+	var names = make([]string, n)
+	var contents = make([][]byte, n)
+	for i := range names {
+		names[i] = filepath.Join(dir, fmt.Sprintf("f%03d.txt", i))
+		// earlier files are larger, so later files tend to be hashed first
+		contents[i] = bytes.Repeat([]byte(fmt.Sprintf("%d\n", i)), (n-i)*1024)
+		if e := ioutil.WriteFile(names[i], contents[i], repo.FilePerm); e != nil {
+			t.Fatalf("%v", e)
+		}
+	}
+	return names, contents
+}
+
+func TestSessionHashFiles(t *testing.T) {
+	defer testRepo(t)()
+	names, contents := writeFiles(t, filepath.Dir(repo.RepoPath), 64)
+	var specs = append(names, filepath.Join(filepath.Dir(repo.RepoPath), "undefined.txt"))
+
+	for _, workers := range []int{0, 1, 4, 16} {
+		session, e := OpenSession(context.Background(), Add)
+		if e != nil {
+			t.Fatalf("OpenSession - %v", e)
+		}
+		var in = make(chan string)
+		go func() {
+			defer close(in)
+			for _, spec := range specs {
+				in <- spec
+			}
+		}()
+
+		// digests are emitted in the order of specs
+		var i int
+		for fd := range session.HashFiles(context.Background(), in, workers) {
+			if i >= len(specs) || fd.Spec != specs[i] {
+				t.Fatalf("workers:%d - digest %d have:%q - expect:%q", workers, i, fd.Spec, specs[i])
+			}
+			if i == len(names) {
+				if fd.Err == nil {
+					t.Fatalf("workers:%d - %q - expected error", workers, fd.Spec)
+				}
+			} else if md := digest.Sum(contents[i]); fd.Err != nil || !bytes.Equal(fd.Digest, md[:]) {
+				t.Fatalf("workers:%d - %q digest have:%x %v - expect:%x", workers, fd.Spec, fd.Digest, fd.Err, md)
+			}
+			i++
+		}
+		if i != len(specs) {
+			t.Fatalf("workers:%d - digests have:%d - expect:%d", workers, i, len(specs))
+		}
+		if e := session.Close(false); e != nil {
+			t.Fatalf("Close - %v", e)
+		}
+	}
+}
+
+func TestSessionHashFilesCancel(t *testing.T) {
+	defer testRepo(t)()
+	names, _ := writeFiles(t, filepath.Dir(repo.RepoPath), 64)
+
+	var goroutines = runtime.NumGoroutine()
+	session, e := OpenSession(context.Background(), Add)
+	if e != nil {
+		t.Fatalf("OpenSession - %v", e)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// specs are not closed, and are provided until cancel
+	var in = make(chan string)
+	var fed = make(chan struct{})
+	go func() {
+		defer close(fed)
+		for i := 0; ; i++ {
+			select {
+			case in <- names[i%len(names)]:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var out = session.HashFiles(ctx, in, 4)
+	for i := 0; i < 8; i++ {
+		if fd := <-out; fd == nil || fd.Err != nil {
+			t.Fatalf("digest %d have:%+v", i, fd)
+		}
+	}
+	cancel()
+	<-fed
+
+	// the digests channel is closed, and Close joins the workers
+	var done = make(chan struct{})
+	go func() {
+		defer close(done)
+		for range out {
+		}
+		if e := session.Close(false); e != nil {
+			t.Errorf("Close - %v", e)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("digests not closed or workers not joined after cancel")
+	}
+
+	// and no goroutines are leaked
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > goroutines; {
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutines have:%d - expect:%d", runtime.NumGoroutine(), goroutines)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSessionAddData(t *testing.T) {
+	defer testRepo(t)()
+
+	var content = make([]byte, 100*1024)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+	var md = digest.Sum(content)
+
+	// stored and unstored data objects have the same oid. the label is set
+	// if the object has none.
+	var oids = make([]*system.Oid, 3)
+	for i, label := range []string{"", "reports/r1.bin", "other"} {
+		var stored = i > 0
+		session, e := OpenSession(context.Background(), Add)
+		if e != nil {
+			t.Fatalf("OpenSession - %v", e)
+		}
+		card, added, e := session.AddData(false, bytes.NewReader(content), label, stored, "x")
+		if e != nil {
+			session.Close(false)
+			t.Fatalf("AddData(stored:%t) - %v", stored, e)
+		}
+		if added != (i == 0) || card.IsStored() != stored {
+			session.Close(false)
+			t.Fatalf("AddData(stored:%t) - added:%t stored:%t", stored, added, card.IsStored())
+		}
+		oids[i] = card.Oid()
+		if e := session.Close(true); e != nil {
+			t.Fatalf("Close - %v", e)
+		}
+	}
+	var expect, _ = system.NewOid(md[:])
+	for _, oid := range oids {
+		if oid.String() != expect.String() {
+			t.Fatalf("oid have:%s - expect:%s", oid, expect)
+		}
+	}
+
+	cards, e := FindCard(oids[0].String()[:system.FingerprintSize])
+	if e != nil || len(cards) != 1 {
+		t.Fatalf("FindCard - have:%d %v - expect 1 card", len(cards), e)
+	}
+	card, ok := cards[0].(index.DataCard)
+	if !ok {
+		t.Fatalf("card have:%s - expect data card", cards[0].Type())
+	}
+	if card.Size() != int64(len(content)) || card.Label() != "reports/r1.bin" || !card.IsStored() {
+		t.Fatalf("card have size:%d label:%q stored:%t", card.Size(), card.Label(), card.IsStored())
+	}
+	var tags = fmt.Sprint(card.Tags())
+	for _, tag := range []string{"x", systemic.TypeTag(system.Data.String())} {
+		if !strings.Contains(tags, tag) {
+			t.Fatalf("tags have:%s - expect:%s", tags, tag)
+		}
+	}
+
+	// the stored content is restored
+	var filename = filepath.Join(filepath.Dir(repo.RepoPath), "r1.bin")
+	size, e := RestoreObject(card, filename)
+	if e != nil || size != int64(len(content)) {
+		t.Fatalf("RestoreObject - have:%d %v - expect:%d", size, e, len(content))
+	}
+	restored, e := ioutil.ReadFile(filename)
+	if e != nil {
+		t.Fatalf("%v", e)
+	}
+	if !bytes.Equal(restored, content) {
+		t.Fatalf("restored content differs")
+	}
+}
+
+func TestGlobalIgnoreRules(t *testing.T) {
+	defer testRepo(t)()
+	defer func(path string) { repo.GlobalIgnorePath = path }(repo.GlobalIgnorePath)
+
+	var root = filepath.Dir(repo.RepoPath)
+	var repoRules = len(loadIgnoreFile(repo.IgnorePath, "/"))
+	if repoRules == 0 {
+		t.Fatalf("repo ignore file has no rules")
+	}
+	var home = filepath.Join(root, "home")
+	if e := os.MkdirAll(filepath.Join(home, repo.RepoDir), repo.DirPerm); e != nil {
+		t.Fatalf("%v", e)
+	}
+	var filename = filepath.Join(home, repo.RepoDir, repo.IgnoreFilename)
+	if e := ioutil.WriteFile(filename, []byte("*.log\n"), repo.FilePerm); e != nil {
+		t.Fatalf("%v", e)
+	}
+	var link = filepath.Join(root, "link")
+	if e := os.Symlink(root, link); e != nil {
+		t.Fatalf("%v", e)
+	}
+
+	// the repo ignore file is not loaded again if it is the global ignore file
+	for _, test := range []struct {
+		home   string
+		expect int
+	}{
+		{home, 1 + repoRules},
+		{root, repoRules}, // repo in home dir
+		{link, repoRules}, // by a symlinked home dir
+	} {
+		repo.InitHomePaths(test.home)
+		var ig = &ignorer{}
+		if have := len(ig.globalRules()) - len(builtinIgnores); have != test.expect {
+			t.Fatalf("home %q - global rules have:%d - expect:%d", test.home, have, test.expect)
+		}
+	}
+}
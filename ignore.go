@@ -0,0 +1,190 @@
+// Doost!
+
+package gart
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/alphazero/gart/repo"
+	"github.com/alphazero/gart/syslib/errors"
+	"github.com/alphazero/gart/syslib/ignore"
+	"github.com/alphazero/gart/system/log"
+)
+
+/// ignored paths //////////////////////////////////////////////////////////////
+
+// Ignored paths are specified by gitignore style rules (see syslib/ignore),
+// in order of precedence (lowest first):
+//
+//	built-in rules             - hidden files and directories
+//	global ignore file         - ~/.gart/ignore of the user
+//	repo ignore file           - .gart/ignore of the repo, if not the above
+//	.gartignore file of dirs   - from the root dir down to the path's dir
+//
+// The last matching rule decides. A path is also ignored if any of its
+// ancestor directories is ignored. The repo directory is always ignored.
+
+// IgnoreFilename is the name of per-directory ignore files.
+const IgnoreFilename = ".gartignore"
+
+var builtinIgnores = []string{".*"}
+
+// rule of paths in the repo - can not be negated
+var repoIgnore = &ignore.Rule{Text: repo.RepoDir + "/"}
+
+var ignores = &ignorer{
+	rules: make(map[string][]*ignore.Rule),
+	dirs:  make(map[string]*ignore.Rule),
+}
+
+// ignorer caches the rules of visited directories. Safe for concurrent use.
+type ignorer struct {
+	sync.Mutex
+	global []*ignore.Rule
+	loaded bool
+	rules  map[string][]*ignore.Rule // effective rules of paths in dir
+	dirs   map[string]*ignore.Rule   // excluding rule of dir or ancestor, or nil
+}
+
+// ignoreFile returns true if file should be ignored.
+func ignoreFile(path string) bool { return ignored(ignores.match(path, false)) }
+
+// ignoreDir returns true if directory should be ignored.
+func ignoreDir(path string) bool { return ignored(ignores.match(path, true)) }
+
+func ignored(rule *ignore.Rule) bool { return rule != nil && !rule.Negate }
+
+// ExplainIgnore returns the rule deciding if the path is ignored, and true if
+// the path is ignored. The rule is either the ignoring rule, or a negated rule
+// re-including the path. The rule is nil if no rule matches the path, i.e.
+// the path is not ignored.
+func ExplainIgnore(path string) (rule *ignore.Rule, isIgnored bool, e error) {
+	var err = errors.For("gart.ExplainIgnore")
+
+	path, e = filepath.Abs(path)
+	if e != nil {
+		return nil, false, err.ErrorWithCause(e, "unexpected error on filepath.Abs")
+	}
+	var isDir bool
+	if finfo, e := os.Stat(path); e == nil {
+		isDir = finfo.IsDir()
+	}
+	rule = ignores.match(path, isDir)
+	return rule, ignored(rule), nil
+}
+
+// match returns the rule deciding if the absolute path is ignored, or nil.
+func (ig *ignorer) match(path string, isDir bool) *ignore.Rule {
+	ig.Lock()
+	defer ig.Unlock()
+
+	if isRepoPath(path) {
+		return repoIgnore
+	}
+	var dir = filepath.Dir(path)
+	if rule := ig.dirRule(dir); rule != nil {
+		return rule
+	}
+	return ignore.Match(ig.dirRules(dir), path, isDir)
+}
+
+func isRepoPath(path string) bool {
+	for _, name := range strings.Split(path, string(os.PathSeparator)) {
+		if name == repo.RepoDir {
+			return true
+		}
+	}
+	return false
+}
+
+// dirRule returns the rule excluding dir or any of its ancestors, or nil.
+func (ig *ignorer) dirRule(dir string) *ignore.Rule {
+	if rule, ok := ig.dirs[dir]; ok {
+		return rule
+	}
+	var rule *ignore.Rule
+	if parent := filepath.Dir(dir); parent != dir {
+		if rule = ig.dirRule(parent); rule == nil {
+			if rule = ignore.Match(ig.dirRules(parent), dir, true); rule != nil && rule.Negate {
+				rule = nil
+			}
+		}
+	}
+	ig.dirs[dir] = rule
+	return rule
+}
+
+// dirRules returns the effective rules of paths in dir: the global rules and
+// the rules of the ignore files of dir and all of its ancestors.
+func (ig *ignorer) dirRules(dir string) []*ignore.Rule {
+	if rules, ok := ig.rules[dir]; ok {
+		return rules
+	}
+	var inherited []*ignore.Rule
+	if parent := filepath.Dir(dir); parent != dir {
+		inherited = ig.dirRules(parent)
+	} else {
+		inherited = ig.globalRules()
+	}
+	var rules = inherited
+	if local := loadIgnoreFile(filepath.Join(dir, IgnoreFilename), dir); len(local) > 0 {
+		rules = append(append([]*ignore.Rule{}, inherited...), local...)
+	}
+	ig.rules[dir] = rules
+	return rules
+}
+
+func (ig *ignorer) globalRules() []*ignore.Rule {
+	if !ig.loaded {
+		var root = string(os.PathSeparator)
+		for _, text := range builtinIgnores {
+			rule, e := ignore.NewRule(text, root)
+			if e != nil {
+				panic(errors.BugWithCause(e, "gart: built-in ignore %q", text))
+			}
+			ig.global = append(ig.global, rule)
+		}
+		// the rules of the global and repo ignore files apply to all paths
+		// note: the repo ignore file of the repo in the home dir is the global
+		// ignore file, possibly by another path (e.g. a symlinked home dir).
+		ig.global = append(ig.global, loadIgnoreFile(repo.GlobalIgnorePath, root)...)
+		if !sameFile(repo.IgnorePath, repo.GlobalIgnorePath) {
+			ig.global = append(ig.global, loadIgnoreFile(repo.IgnorePath, root)...)
+		}
+		ig.loaded = true
+	}
+	return ig.global
+}
+
+// sameFile returns true if the paths are the same, or name the same (existing)
+// file.
+func sameFile(path1, path2 string) bool {
+	if filepath.Clean(path1) == filepath.Clean(path2) {
+		return true
+	}
+	finfo1, e1 := os.Stat(path1)
+	finfo2, e2 := os.Stat(path2)
+	return e1 == nil && e2 == nil && os.SameFile(finfo1, finfo2)
+}
+
+// loadIgnoreFile returns the (valid) rules of the ignore file, with the given
+// base directory (see ignore.Parse). Invalid patterns are reported and skipped.
+func loadIgnoreFile(filename, base string) []*ignore.Rule {
+	file, e := os.Open(filename)
+	if e != nil {
+		if !os.IsNotExist(e) {
+			log.Error("warning: %v - skipped", e)
+		}
+		return nil
+	}
+	defer file.Close()
+
+	rules, e := ignore.Parse(file, filename, base)
+	if e != nil {
+		log.Error("warning: %v - skipped", e)
+	}
+	return rules
+}
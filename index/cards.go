@@ -44,13 +44,17 @@ type Card interface {
 	Tags() []string
 	/* -- index package private ----- */
 	setKey(int64) error               // index use only
+	rekey(int64) error                // index compaction use only
 	addTag(tag ...string) []string    // returns updated tags, if any
 	removeTag(tag ...string) []string // returns removed tags, if any
 	isModified() bool                 //
 	markDeleted() bool                // returns false if locked
+	markUndeleted() bool              // returns false if not marked deleted
 	IsDeleted() bool                  // returns true if card is marked deleted
 	markLocked()                      // marks card as deleted
 	IsLocked() bool                   // returns true if card is locked
+	markStored() bool                 // returns false if already marked stored
+	IsStored() bool                   // returns true if object content is stored
 	saveWip() (bool, error)           // saves wip file - return true if card modified
 	removeWip() error                 // error if called without a saveWip | card not modified
 	save() (bool, error)              // swaps the wip file with the actual card (if any)
@@ -62,8 +66,14 @@ const cardHeaderSize = 40
 const (
 	cardDeleted byte = 1 << iota
 	cardLocked
+	cardLinked // (obsolete - see cardLinks) card data is prefixed with the oid of the superseding object
+	cardStored // object content is in the repo object store
+	cardLinks  // card data is prefixed with the oids of the superseding objects
 )
 
+// maximum number of superseding objects of a file object (see cardLinks).
+const maxCardLinks = 255
+
 type cardFileHeader struct {
 	crc32   uint32
 	otype   system.Otype
@@ -168,6 +178,9 @@ func (c *cardFile) Print(w io.Writer) {
 	if c.IsLocked() {
 		fmt.Fprintf(w, " locked")
 	}
+	if c.IsStored() {
+		fmt.Fprintf(w, " stored")
+	}
 	fmt.Fprintf(w, "\n")
 
 	if len(c.tags) > 0 {
@@ -274,10 +287,31 @@ func (c *cardFile) markDeleted() bool {
 	}
 	return false
 }
+
+// clears the deleted mark of card. Returns false if card was not marked deleted.
+func (c *cardFile) markUndeleted() bool {
+	if c.header.flags&cardDeleted == 0 {
+		return false
+	}
+	c.header.flags &^= cardDeleted
+	c.onUpdate()
+	return true
+}
 func (c *cardFile) IsDeleted() bool { return c.header.flags&cardDeleted != 0 }
 func (c *cardFile) markLocked()     { c.header.flags |= cardLocked }
 func (c *cardFile) IsLocked() bool  { return c.header.flags&cardLocked != 0 }
 
+// marks card as stored. Returns false if card was already marked stored.
+func (c *cardFile) markStored() bool {
+	if c.header.flags&cardStored != 0 {
+		return false
+	}
+	c.header.flags |= cardStored
+	c.onUpdate()
+	return true
+}
+func (c *cardFile) IsStored() bool { return c.header.flags&cardStored != 0 }
+
 func (c *cardFile) Tags() []string {
 	var tags = make([]string, len(c.tags))
 	var n int
@@ -334,6 +368,23 @@ func (c *cardFile) removeTag(tags ...string) []string {
 	return updates
 }
 
+// rekey changes the key of the card on compaction of the index. The key is
+// not object meta-data, so the card version is not incremented.
+func (c *cardFile) rekey(key int64) error {
+	var err = errors.For("cardFile.rekey")
+	if c.header.key < 0 {
+		return err.Bug("key is not set")
+	}
+	if key < 0 {
+		return err.InvalidArg("key is < 0")
+	}
+	if key != c.header.key {
+		c.header.key = key
+		c.modified = true
+	}
+	return nil
+}
+
 func (c *cardFile) onUpdate() {
 	if !c.modified {
 		c.modified = true
@@ -383,6 +434,58 @@ func FindCard(oidstr string) ([]Card, error) {
 	return cards, nil
 }
 
+// walkCards loads every card in the index, applying function fn to each card.
+// Cards are visited in oid order. The walk stops on the first error returned
+// by fn, which is returned.
+func walkCards(fn func(Card) error) error {
+	var err = errors.For("index.walkCards")
+
+	oids, e := cardOids()
+	if e != nil {
+		return err.ErrorWithCause(e, "on cardOids")
+	}
+	for _, oid := range oids {
+		card, e := LoadCard(oid)
+		if e != nil {
+			return err.ErrorWithCause(e, "on LoadCard(%s)", oid.Fingerprint())
+		}
+		if e := fn(card); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// cardOids returns the oids of all card files in the index, in oid order.
+// Card swapfiles are ignored.
+func cardOids() ([]*system.Oid, error) {
+	var err = errors.For("index.cardOids")
+
+	dirs, e := filepath.Glob(filepath.Join(repo.IndexCardsPath, "??"))
+	if e != nil {
+		return nil, err.ErrorWithCause(e, "on Glob")
+	}
+	var oids []*system.Oid
+	for _, dir := range dirs {
+		files, e := filepath.Glob(filepath.Join(dir, "*"))
+		if e != nil {
+			return nil, err.ErrorWithCause(e, "on Glob(%s)", dir)
+		}
+		for _, f := range files {
+			var fname = filepath.Base(f)
+			if strings.HasPrefix(fname, ".") {
+				continue // swapfile
+			}
+			oid, e := system.ParseOid(filepath.Base(dir) + fname)
+			if e != nil {
+				return nil, err.Bug("unexpected - %s", e)
+			}
+			oids = append(oids, oid)
+		}
+	}
+	return oids, nil
+}
+
 func LoadCard(oid *system.Oid) (Card, error) {
 	var err = errors.For("index.LoadCard")
 
@@ -463,6 +566,20 @@ func LoadCard(oid *system.Oid) (Card, error) {
 		cardbase.encode = tcard.encode
 		e = tcard.decode(buf[offset:])
 		card = tcard
+	case system.Data:
+		tcard := &dataCard{
+			cardFile: cardbase,
+		}
+		cardbase.encode = tcard.encode
+		e = tcard.decode(buf[offset:])
+		card = tcard
+	case system.URL:
+		tcard := &urlCard{
+			cardFile: cardbase,
+		}
+		cardbase.encode = tcard.encode
+		e = tcard.decode(buf[offset:])
+		card = tcard
 	default:
 		panic(err.Bug("unexpected otype: %s", cardbase.header.otype))
 	}
@@ -536,8 +653,9 @@ func (c *cardFile) saveWip() (bool, error) {
 
 	/// create swapfile & mmap it /////////////////////////////////////////////////
 
+	// note: a stale swapfile (of an uncommitted session) is replaced.
 	swapfile := fs.SwapfileName(c.source)
-	sfile, _, e := fs.OpenNewSwapfile(swapfile, true)
+	sfile, _, e := fs.OpenNewSwapfile(swapfile, false)
 	if e != nil {
 		return false, err.Error("fs.OpenNewSwapFile: %s", e)
 	}
@@ -651,18 +769,172 @@ func (c *textCard) Debug() {
 	fmt.Fprintf(w, "------------------------\n\n")
 }
 
+/// URLCard support ////////////////////////////////////////////////////////////
+
+type urlCard struct {
+	*cardFile
+	url string // normalized
+}
+
+// URLCard interface defines an index.Card of a URL object.
+type URLCard interface {
+	Card
+	URL() string
+}
+
+// NewURLCard expects the normalized url. See system.NormalizeURL.
+func NewURLCard(oid *system.Oid, url string) (*urlCard, error) {
+	cardFile, e := newCardFile(oid, system.URL)
+	if e != nil {
+		return nil, e
+	}
+	card := &urlCard{
+		cardFile: cardFile,
+		url:      url,
+	}
+	cardFile.datalen = int64(len(url))
+	cardFile.encode = card.encode
+
+	return card, nil
+}
+
+func (c *urlCard) decode(buf []byte) error {
+	c.datalen = int64(len(buf))
+	c.url = string(buf) // copies buf
+	return nil
+}
+
+func (c *urlCard) encode(buf []byte) error {
+	var err = errors.For("urlCard.encode")
+	if len(buf) < len(c.url) {
+		return err.InvalidArg("len(buf):%d < required %d", len(buf), len(c.url))
+	}
+	copy(buf, c.url)
+	return nil
+}
+
+func (c *urlCard) URL() string { return c.url }
+
+func (c *urlCard) Info() string {
+	cfinfo := c.cardFile.Info()
+	return fmt.Sprintf("%s %s", cfinfo, c.url)
+}
+
+func (c *urlCard) Print(w io.Writer) {
+	c.cardFile.Print(w)
+	fmt.Fprintf(w, "url:       %s\n", c.url)
+	fmt.Fprintf(w, "------------------------\n\n")
+}
+func (c *urlCard) Debug() {
+	c.Print(debug.Writer)
+}
+
+/// DataCard support ///////////////////////////////////////////////////////////
+
+type dataCard struct {
+	*cardFile
+	size  int64
+	label string
+}
+
+// DataCard interface defines an index.Card of a (binary) data object, e.g.
+// data streamed from stdin. Data objects have no path.
+type DataCard interface {
+	Card
+	Size() int64
+	Label() string
+	setLabel(string) bool
+}
+
+func NewDataCard(oid *system.Oid, size int64, label string) (*dataCard, error) {
+	cardFile, e := newCardFile(oid, system.Data)
+	if e != nil {
+		return nil, e
+	}
+	card := &dataCard{
+		cardFile: cardFile,
+		size:     size,
+		label:    label,
+	}
+	cardFile.datalen = int64(8 + len(label))
+	cardFile.encode = card.encode
+
+	return card, nil
+}
+
+// data card data is the content size followed by the (optional) label.
+func (c *dataCard) encode(buf []byte) error {
+	var err = errors.For("dataCard.encode")
+	if len(buf) < 8+len(c.label) {
+		return err.InvalidArg("len(buf):%d < required %d", len(buf), 8+len(c.label))
+	}
+	*(*int64)(unsafe.Pointer(&buf[0])) = c.size
+	copy(buf[8:], c.label)
+	return nil
+}
+
+func (c *dataCard) decode(buf []byte) error {
+	var err = errors.For("dataCard.decode")
+	if len(buf) < 8 {
+		return err.Bug("data card data len:%d", len(buf))
+	}
+	c.datalen = int64(len(buf))
+	c.size = *(*int64)(unsafe.Pointer(&buf[0]))
+	c.label = string(buf[8:]) // copies buf
+	return nil
+}
+
+func (c *dataCard) Size() int64   { return c.size }
+func (c *dataCard) Label() string { return c.label }
+
+// sets the label of the card if it has none. Returns true if the label was set.
+func (c *dataCard) setLabel(label string) bool {
+	if c.label != "" || label == "" {
+		return false
+	}
+	c.label = label
+	c.datalen = int64(8 + len(label))
+	c.onUpdate()
+	return true
+}
+
+func (c *dataCard) Info() string {
+	cfinfo := c.cardFile.Info()
+	if c.label == "" {
+		return fmt.Sprintf("%s (bytes: %d)", cfinfo, c.size)
+	}
+	return fmt.Sprintf("%s (bytes: %d) %q", cfinfo, c.size, c.label)
+}
+
+func (c *dataCard) Print(w io.Writer) {
+	c.cardFile.Print(w)
+	fmt.Fprintf(w, "size:      %d\n", c.size)
+	if c.label != "" {
+		fmt.Fprintf(w, "label:     %q\n", c.label)
+	}
+	fmt.Fprintf(w, "------------------------\n\n")
+}
+func (c *dataCard) Debug() {
+	c.Print(debug.Writer)
+}
+
 /// FileCard support ///////////////////////////////////////////////////////////
 
 type fileCard struct {
 	*cardFile
+	next  []*system.Oid
 	paths *Paths
 }
 
 type FileCard interface {
 	Card
 	Paths() []string
+	// Returns the oids of the objects that superseded this object, i.e. of the
+	// changed content of each of its paths, or nil.
+	Next() []*system.Oid
 	addPath(string) (bool, error)
 	removePath(string) (bool, error)
+	addNext(*system.Oid) (bool, error)
 }
 
 // REVU oid can be directly computed from the path.
@@ -683,18 +955,57 @@ func NewFileCard(oid *system.Oid, path string) (*fileCard, error) {
 	return card, nil
 }
 
+// file card data is the (optional) count and oids of the superseding objects
+// followed by the paths. Cards flagged cardLinked (i.e. written before cardLinks)
+// have a single oid and no count.
 func (c *fileCard) encode(buf []byte) error {
+	if c.header.flags&cardLinks != 0 {
+		buf[0] = byte(len(c.next))
+		buf = buf[1:]
+	}
+	for _, oid := range c.next {
+		if e := oid.Encode(buf); e != nil {
+			return e
+		}
+		buf = buf[system.OidSize:]
+	}
 	return c.paths.Encode(buf)
 }
 
 func (c *fileCard) decode(buf []byte) error {
+	var err = errors.For("fileCard.decode")
 	c.datalen = int64(len(buf))
+	var n int
+	switch {
+	case c.header.flags&cardLinks != 0:
+		if len(buf) < 1 {
+			return err.Bug("linked card data len:%d", len(buf))
+		}
+		n = int(buf[0])
+		buf = buf[1:]
+	case c.header.flags&cardLinked != 0:
+		n = 1
+	}
+	if len(buf) < n*system.OidSize {
+		return err.Bug("linked card data len:%d - links:%d", len(buf), n)
+	}
+	for i := 0; i < n; i++ {
+		next, e := system.NewOid(buf[:system.OidSize])
+		if e != nil {
+			return err.BugWithCause(e, "linked card next oid %d", i)
+		}
+		c.next = append(c.next, next)
+		buf = buf[system.OidSize:]
+	}
 	return c.paths.Decode(buf)
 }
 
 func (c *fileCard) Info() string {
 	cfinfo := c.cardFile.Info()
 	paths := c.paths.List()
+	if len(paths) == 0 {
+		return fmt.Sprintf("%s (no paths)", cfinfo)
+	}
 	var more string
 	if len(paths) > 1 {
 		more = fmt.Sprintf(" (+dups:%d)", len(paths)-1)
@@ -704,6 +1015,9 @@ func (c *fileCard) Info() string {
 
 func (c *fileCard) Print(w io.Writer) {
 	c.cardFile.Print(w)
+	for _, oid := range c.next {
+		fmt.Fprintf(w, "next:      %s\n", oid.Fingerprint())
+	}
 	c.paths.Print(w)
 	fmt.Fprintf(w, "------------------------\n\n")
 }
@@ -715,10 +1029,14 @@ func (c *fileCard) Paths() []string {
 	return c.paths.List()
 }
 
+func (c *fileCard) Next() []*system.Oid {
+	return c.next
+}
+
 func (c *fileCard) addPath(path string) (bool, error) {
 	ok, e := c.paths.Add(path)
 	if ok {
-		c.cardFile.datalen = int64(c.paths.Buflen())
+		c.updateDatalen()
 		c.onUpdate()
 	}
 	return ok, e
@@ -727,8 +1045,37 @@ func (c *fileCard) addPath(path string) (bool, error) {
 func (c *fileCard) removePath(path string) (bool, error) {
 	ok, e := c.paths.Remove(path)
 	if ok {
-		c.cardFile.datalen = int64(c.paths.Buflen())
+		c.updateDatalen()
 		c.onUpdate()
 	}
 	return ok, e
 }
+
+// addNext links the card to an object that superseded it. Links are recorded
+// as cardLinks, and cards linked as cardLinked are converted.
+//
+// Returns true if the link was added, false if the card is already linked to
+// the object, and error if the card has maxCardLinks links.
+func (c *fileCard) addNext(oid *system.Oid) (bool, error) {
+	for _, next := range c.next {
+		if next.String() == oid.String() {
+			return false, nil
+		}
+	}
+	if len(c.next) == maxCardLinks {
+		return false, errors.Error("fileCard.addNext: card has %d links", maxCardLinks)
+	}
+	c.next = append(c.next, oid)
+	c.header.flags &^= cardLinked
+	c.header.flags |= cardLinks
+	c.updateDatalen()
+	c.onUpdate()
+	return true, nil
+}
+
+func (c *fileCard) updateDatalen() {
+	c.cardFile.datalen = int64(c.paths.Buflen()) + int64(len(c.next))*system.OidSize
+	if c.header.flags&cardLinks != 0 {
+		c.cardFile.datalen++
+	}
+}
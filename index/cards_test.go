@@ -0,0 +1,86 @@
+// Doost!
+
+package index
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/alphazero/gart/repo"
+	"github.com/alphazero/gart/syslib/digest"
+	"github.com/alphazero/gart/system"
+)
+
+func testOid(t *testing.T, content string) *system.Oid {
+	var md = digest.Sum([]byte(content))
+	oid, e := system.NewOid(md[:])
+	if e != nil {
+		t.Fatalf("NewOid - %v", e)
+	}
+	return oid
+}
+
+// saveLoadCard saves the (modified) card and returns the loaded card.
+func saveLoadCard(t *testing.T, card Card) FileCard {
+	if _, e := card.saveWip(); e != nil {
+		t.Fatalf("saveWip - %v", e)
+	}
+	if _, e := card.save(); e != nil {
+		t.Fatalf("save - %v", e)
+	}
+	loaded, e := LoadCard(card.Oid())
+	if e != nil {
+		t.Fatalf("LoadCard - %v", e)
+	}
+	return loaded.(FileCard)
+}
+
+func expectNext(t *testing.T, card FileCard, expect ...*system.Oid) {
+	var next = card.Next()
+	if len(next) != len(expect) {
+		t.Fatalf("next have:%v - expect:%v", next, expect)
+	}
+	for i := range expect {
+		if next[i].String() != expect[i].String() {
+			t.Fatalf("next[%d] have:%s - expect:%s", i, next[i], expect[i])
+		}
+	}
+}
+
+func TestFileCardLinks(t *testing.T) {
+	defer testRepo(t)()
+
+	var path = filepath.Join(filepath.Dir(repo.RepoPath), "file")
+	var next = []*system.Oid{testOid(t, "next 0"), testOid(t, "next 1")}
+
+	// a card linked before cardLinks, with a single oid
+	card, e := NewFileCard(testOid(t, "card"), path)
+	if e != nil {
+		t.Fatalf("NewFileCard - %v", e)
+	}
+	if e := card.setKey(0); e != nil {
+		t.Fatalf("setKey - %v", e)
+	}
+	card.next = next[:1]
+	card.header.flags |= cardLinked
+	card.updateDatalen()
+	card.onUpdate()
+	var loaded = saveLoadCard(t, card)
+	expectNext(t, loaded, next[0])
+
+	// is converted on link of the next object
+	if ok, e := loaded.addNext(next[0]); ok || e != nil {
+		t.Fatalf("addNext - linked oid - ok:%t e:%v", ok, e)
+	}
+	if ok, e := loaded.addNext(next[1]); !ok || e != nil {
+		t.Fatalf("addNext - ok:%t e:%v", ok, e)
+	}
+	loaded = saveLoadCard(t, loaded)
+	expectNext(t, loaded, next...)
+	if flags := loaded.(*fileCard).header.flags; flags&cardLinked != 0 || flags&cardLinks == 0 {
+		t.Fatalf("flags have:%08b", flags)
+	}
+	if paths := loaded.Paths(); len(paths) != 1 || paths[0] != path {
+		t.Fatalf("paths have:%q - expect:%q", paths, path)
+	}
+}
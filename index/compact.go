@@ -0,0 +1,227 @@
+// Doost!
+
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/alphazero/gart/repo"
+	"github.com/alphazero/gart/syslib/bitmap"
+	"github.com/alphazero/gart/syslib/debug"
+	"github.com/alphazero/gart/syslib/errors"
+	"github.com/alphazero/gart/system"
+	"github.com/alphazero/gart/system/systemic"
+)
+
+/// compaction /////////////////////////////////////////////////////////////////
+
+// Deleted objects retain their key (objects.idx record) and their cards are
+// only marked deleted. Compaction reclaims these keys: the live objects are
+// renumbered, in key order, into a dense key space. objects.idx, the cards of
+// renumbered objects, all tagmaps, and the tag dictionary are rewritten, and
+// the cards of deleted objects are removed. Tags retain their ids, and tags
+// no longer applied to any object are removed, with their tagmaps, from the
+// tag dictionary. The compaction is committed as a single journaled session
+// (see journal.go).
+
+// CompactStats are the stats of an index compaction.
+type CompactStats struct {
+	Objects     int // number of objects (keys) before compaction
+	Live        int // number of live objects after compaction
+	Removed     int // number of removed (deleted) cards
+	Tagmaps     int // number of rewritten tagmaps
+	BitmapBytes [2]int
+	// total size of tagmap bitmaps before [0] and after [1] compaction
+}
+
+// CompactIndex compacts the index. Any interrupted session is first
+// recovered.
+//
+// The repo is exclusively locked for the duration of the call, and
+// CompactIndex must not be called while an IndexManager is open.
+//
+// Returns nil, error if any card could not be loaded (see Fsck), or on any
+// other error. The index is not modified on error.
+func CompactIndex() (*CompactStats, error) {
+	var err = errors.For("index.CompactIndex")
+	var debug = debug.For("index.CompactIndex")
+
+	lock, e := lockRepo(Compact)
+	if e != nil {
+		return nil, e
+	}
+	defer lock.Unlock()
+
+	if e := recoverSession(); e != nil {
+		return nil, err.ErrorWithCause(e, "on recovery")
+	}
+
+	oidx, e := openObjectIndex(Read)
+	if e != nil {
+		return nil, err.ErrorWithCause(e, "on openObjectIndex")
+	}
+	var created, ocnt = oidx.header.created, oidx.header.ocnt
+	if e := oidx.closeIndex(false); e != nil {
+		return nil, err.ErrorWithCause(e, "on oidx.closeIndex")
+	}
+
+	/// renumber live objects ///////////////////////////////////////
+
+	var live, deleted []Card
+	if e := walkCards(func(card Card) error {
+		if card.IsDeleted() {
+			deleted = append(deleted, card)
+		} else {
+			live = append(live, card)
+		}
+		return nil
+	}); e != nil {
+		return nil, err.ErrorWithCause(e, "on walkCards")
+	}
+	sort.Slice(live, func(i, j int) bool { return live[i].Key() < live[j].Key() })
+
+	var stats = &CompactStats{
+		Objects: int(ocnt),
+		Live:    len(live),
+		Removed: len(deleted),
+	}
+	if int64(len(live)) == ocnt && len(deleted) == 0 {
+		debug.Printf("nothing to compact - objects:%d", ocnt)
+		return stats, nil
+	}
+
+	var oids = make([]*system.Oid, len(live))
+	var tagKeys = map[string][]uint{
+		systemic.GartTag(): nil, // always has a tagmap
+	}
+	for key, card := range live {
+		oids[key] = card.Oid()
+		if e := card.rekey(int64(key)); e != nil {
+			return nil, err.ErrorWithCause(e, "card %s", card.Oid().Fingerprint())
+		}
+		for _, tag := range card.Tags() {
+			tagKeys[tag] = append(tagKeys[tag], uint(key))
+		}
+	}
+
+	/// save swapfiles //////////////////////////////////////////////
+
+	var j = newJournal()
+	if e := saveObjectIndexWip(created, oids); e != nil {
+		return nil, err.ErrorWithCause(e, "on saveObjectIndexWip")
+	}
+	if e := j.addFile(repo.ObjectIndexPath); e != nil {
+		return nil, e
+	}
+	for _, card := range live {
+		if !card.isModified() {
+			continue // key unchanged
+		}
+		if _, e := card.saveWip(); e != nil {
+			return nil, err.ErrorWithCause(e, "on card(%s).saveWip", card.Oid().Fingerprint())
+		}
+		if e := j.addFile(cardFilename(card.Oid())); e != nil {
+			return nil, e
+		}
+	}
+	for _, card := range deleted {
+		if e := j.removeFile(cardFilename(card.Oid())); e != nil {
+			return nil, e
+		}
+	}
+
+	for tag, keys := range tagKeys {
+		var wahl = bitmap.NewWahl()
+		if len(keys) > 0 {
+			wahl.Set(keys...)
+		}
+		var tagmap = &Tagmap{
+			tag:      tag,
+			bitmap:   wahl,
+			source:   TagmapFilename(tag),
+			modified: true,
+		}
+		if old, e := loadTagmap(tag, false); e == nil {
+			tagmap.header = old.header
+			stats.BitmapBytes[0] += int(old.header.mapSize)
+		} else if e == ErrTagNotExist {
+			tagmap.header = newTagmapHeader()
+			if e := os.MkdirAll(filepath.Dir(tagmap.source), repo.DirPerm); e != nil {
+				return nil, err.ErrorWithCause(e, "tag %q", tag)
+			}
+		} else {
+			return nil, err.ErrorWithCause(e, "on loadTagmap(%q)", tag)
+		}
+		// note: saveWip compresses the bitmap
+		if _, e := tagmap.saveWip(); e != nil {
+			return nil, err.ErrorWithCause(e, "on tagmap(%q).saveWip", tag)
+		}
+		if e := j.addFile(tagmap.source); e != nil {
+			return nil, e
+		}
+		stats.Tagmaps++
+		stats.BitmapBytes[1] += int(tagmap.header.mapSize)
+	}
+
+	tagdict, e := buildTagDictionary()
+	if e != nil {
+		return nil, err.ErrorWithCause(e, "on buildTagDictionary")
+	}
+	var unused = tagdict.removeUnused()
+	if _, e := tagdict.saveWip(); e != nil {
+		return nil, err.ErrorWithCause(e, "on tagdict.saveWip")
+	}
+	if e := j.addFile(tagdict.source); e != nil {
+		return nil, e
+	}
+
+	// tagmaps of tags only applied to deleted objects, or removed from all
+	// objects, are removed
+	files, e := filepath.Glob(filepath.Join(repo.IndexTagmapsPath, "??", "*"))
+	if e != nil {
+		return nil, err.ErrorWithCause(e, "on Glob")
+	}
+	var tagmapFiles = make(map[string]string, len(files))
+	for _, card := range deleted {
+		for _, tag := range card.Tags() {
+			tagmapFiles[TagmapFilename(tag)] = tag
+		}
+	}
+	for _, tag := range unused {
+		tagmapFiles[TagmapFilename(tag)] = tag
+	}
+	for _, filename := range files {
+		if strings.HasPrefix(filepath.Base(filename), ".") {
+			continue // swapfile
+		}
+		tag, ok := tagmapFiles[filename]
+		if _, live := tagKeys[tag]; !ok || live {
+			continue
+		}
+		if old, e := loadTagmap(tag, false); e == nil {
+			stats.BitmapBytes[0] += int(old.header.mapSize)
+		}
+		if e := j.removeFile(filename); e != nil {
+			return nil, e
+		}
+	}
+
+	/// commit //////////////////////////////////////////////////////
+
+	if e := j.commit(); e != nil {
+		return nil, err.ErrorWithCause(e, "on journal commit")
+	}
+	debug.Printf("committed journal - files:%d", len(j.files))
+
+	if e := j.apply(); e != nil {
+		return nil, err.BugWithCause(e, "on journal apply")
+	}
+	if e := removeJournal(); e != nil {
+		return nil, err.BugWithCause(e, "on journal remove")
+	}
+
+	return stats, nil
+}
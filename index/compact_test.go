@@ -0,0 +1,175 @@
+// Doost!
+
+package index
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"testing"
+)
+
+// keyOids returns the oids of the live objects by key.
+func keyOids(t *testing.T) map[int]string {
+	var oids = make(map[int]string)
+	if e := walkCards(func(card Card) error {
+		if !card.IsDeleted() {
+			oids[int(card.Key())] = card.Oid().String()
+		}
+		return nil
+	}); e != nil {
+		t.Fatalf("walkCards - %v", e)
+	}
+	return oids
+}
+
+// searchOids returns the sorted oids of the objects selected by the query.
+func searchOids(t *testing.T, q Query) []string {
+	var oids = keyOids(t)
+	var selected []string
+	for _, key := range searchKeys(t, q) {
+		oid, ok := oids[key]
+		if !ok {
+			t.Fatalf("key %d selected by query - no live card", key)
+		}
+		selected = append(selected, oid)
+	}
+	sort.Strings(selected)
+	return selected
+}
+
+// tagIds returns the ids of the tags of the tag dictionary by name.
+func tagIds(t *testing.T) map[string]int {
+	tagdict, e := loadTagDictionary()
+	if e != nil {
+		t.Fatalf("loadTagDictionary - %v", e)
+	}
+	var ids = make(map[string]int, len(tagdict.tags))
+	for name, tag := range tagdict.tags {
+		ids[name] = tag.id
+	}
+	return ids
+}
+
+func TestCompactIndex(t *testing.T) {
+	defer testRepo(t)()
+
+	// objects with keys 1 and 3 are deleted. tag 'gone' is only applied to
+	// deleted objects, and tag 'removed' is removed from all objects.
+	oids := indexTexts(t,
+		testObject{"o0", []string{"a", "even", "removed"}},
+		testObject{"o1", []string{"a", "gone"}},
+		testObject{"o2", []string{"a", "even"}},
+		testObject{"o3", []string{"a", "gone"}},
+		testObject{"o4", []string{"a", "even", "b"}},
+		testObject{"o5", []string{"a"}},
+	)
+	idx, e := OpenIndexManager(Write)
+	if e != nil {
+		t.Fatalf("OpenIndexManager - %v", e)
+	}
+	for _, i := range []int{1, 3} {
+		if ok, e := idx.DeleteObject(oids[i]); e != nil || !ok {
+			idx.Rollback()
+			t.Fatalf("DeleteObject(o%d) - %t, %v", i, ok, e)
+		}
+	}
+	if _, e := idx.RemoveTags(oids[0], "removed"); e != nil {
+		idx.Rollback()
+		t.Fatalf("RemoveTags - %v", e)
+	}
+	if e := idx.Close(true); e != nil {
+		t.Fatalf("Close - %v", e)
+	}
+
+	var queries = []Query{
+		NewQuery().IncludeTags("a").Build(),
+		NewQuery().IncludeTags("even").Build(),
+		NewQuery().IncludeTags("b").Build(),
+		NewQuery().IncludeTags("a").ExcludeTags("even").Build(),
+		NewQuery().ExcludeTags("b").Build(),
+		mustParseQuery(t, "b | !even"),
+	}
+	var ids = tagIds(t)
+	var before = make([][]string, len(queries))
+	for i, q := range queries {
+		before[i] = searchOids(t, q)
+	}
+	if keys := searchKeys(t, queries[0]); !equalInts(keys, []int{0, 2, 4, 5}) {
+		t.Fatalf("keys of a before compaction have:%v", keys)
+	}
+
+	stats, e := CompactIndex()
+	if e != nil {
+		t.Fatalf("CompactIndex - %v", e)
+	}
+	if stats.Objects != 6 || stats.Live != 4 || stats.Removed != 2 {
+		t.Fatalf("stats have:%+v", stats)
+	}
+
+	// live objects are renumbered in key order, and all queries select the
+	// same objects
+	var expect = map[int]string{
+		0: oids[0].String(),
+		1: oids[2].String(),
+		2: oids[4].String(),
+		3: oids[5].String(),
+	}
+	if have := keyOids(t); fmt.Sprint(have) != fmt.Sprint(expect) {
+		t.Fatalf("keys have:%v - expect:%v", have, expect)
+	}
+	if keys := searchKeys(t, queries[0]); !equalInts(keys, []int{0, 1, 2, 3}) {
+		t.Fatalf("keys of a after compaction have:%v", keys)
+	}
+	for i, q := range queries {
+		if have := searchOids(t, q); fmt.Sprint(have) != fmt.Sprint(before[i]) {
+			t.Fatalf("query %d have:%v - expect:%v", i, have, before[i])
+		}
+	}
+
+	// cards of deleted objects and the tagmaps and tags no longer applied to
+	// any object are removed
+	for _, i := range []int{1, 3} {
+		if _, e := os.Stat(cardFilename(oids[i])); !os.IsNotExist(e) {
+			t.Fatalf("card of o%d not removed - %v", i, e)
+		}
+	}
+	tagdict, e := loadTagDictionary()
+	if e != nil {
+		t.Fatalf("loadTagDictionary - %v", e)
+	}
+	for _, tag := range []string{"gone", "removed"} {
+		if _, e := os.Stat(TagmapFilename(tag)); !os.IsNotExist(e) {
+			t.Fatalf("tagmap of %s not removed - %v", tag, e)
+		}
+		if _, ok := tagdict.tags[tag]; ok {
+			t.Fatalf("tag dictionary - %s is defined", tag)
+		}
+		delete(ids, tag)
+	}
+	// tags retain their ids
+	if have := tagIds(t); fmt.Sprint(have) != fmt.Sprint(ids) {
+		t.Fatalf("tag ids have:%v - expect:%v", have, ids)
+	}
+	fsckClean(t)
+
+	// the next object has the next dense key, and new tags are not assigned
+	// the ids of removed tags
+	indexTexts(t, testObject{"o6", []string{"a", "new"}})
+	if id := tagIds(t)["new"]; int64(id) != tagdict.header.nextId {
+		t.Fatalf("id of new tag have:%d - expect:%d", id, tagdict.header.nextId)
+	}
+	if keys := searchKeys(t, queries[0]); !equalInts(keys, []int{0, 1, 2, 3, 4}) {
+		t.Fatalf("keys of a have:%v - expect:[0 1 2 3 4]", keys)
+	}
+
+	// and a compact index is not modified
+	stats, e = CompactIndex()
+	if e != nil {
+		t.Fatalf("CompactIndex - %v", e)
+	}
+	if stats.Objects != 5 || stats.Live != 5 || stats.Removed != 0 || stats.Tagmaps != 0 {
+		t.Fatalf("stats have:%+v", stats)
+	}
+	fsckClean(t)
+}
@@ -0,0 +1,373 @@
+// Doost!
+
+package index
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/alphazero/gart/syslib/bitmap"
+	"github.com/alphazero/gart/syslib/errors"
+	"github.com/alphazero/gart/system/systemic"
+)
+
+/// Query expressions //////////////////////////////////////////////////////////
+
+// Query expressions are boolean expressions over tags, e.g.
+//
+//		(jazz | blues) & 1960s & !bootleg & ext:flac
+//
+// Operators, in order of precedence (highest first):
+//
+//		!	not
+//		&	and
+//		^	xor
+//		|	or
+//
+// Parenthesis group sub-expressions. A tag is any run of characters other
+// than white-space and the operator and grouping characters, or is quoted,
+// e.g. "tag 2", in which case it may contain any characters. In quoted tags,
+// '\' escapes the next character, e.g. "say \"hi\"". The 'ext:' and 'type:'
+// tag prefixes are shorthand for the corresponding systemic tags.
+//
+// Expressions are evaluated bottom-up over the tagmaps, using the WAHL bitwise
+// ops (see planner). Tags that are not defined evaluate to the empty set. Not
+// is evaluated relative to the set of all (non-deleted) gart objects.
+
+// ParseQuery parses the query expression and returns the corresponding Query.
+//
+// Returns nil, error if expression is zero-len or is not well formed.
+func ParseQuery(s string) (Query, error) {
+	var err = errors.For("index.ParseQuery")
+
+	x, e := parseExpr(s)
+	if e != nil {
+		return nil, err.ErrorWithCause(e, "query %q", s)
+	}
+	var q = NewQuery()
+	q.expr = x
+	return q, nil
+}
+
+// queryExpr is a node of the query expression tree.
+type queryExpr interface {
+	// eval returns the set of objects selected by the expression.
+	eval(p *planner) (*bitmap.Wahl, error)
+	String() string
+}
+
+type tagExpr string
+
+// String returns the tag, quoted if it is not a tag token (see tokenizeExpr).
+func (x tagExpr) String() string {
+	if len(x) > 0 && !strings.ContainsAny(string(x), exprOpChars+exprQuoteChars) &&
+		strings.IndexFunc(string(x), unicode.IsSpace) == -1 {
+		return string(x)
+	}
+	var r = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + r.Replace(string(x)) + `"`
+}
+func (x tagExpr) eval(p *planner) (*bitmap.Wahl, error) {
+	return p.tagBitmap(string(x))
+}
+
+// dayRange is the (non-parsable) expression that selects objects by the
+// systemic date tags of the (inclusive) date range.
+type dayRange struct {
+	since, until time.Time // zero values for open ranges
+}
+
+func (x *dayRange) String() string {
+	var date = func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format("2006-01-02")
+	}
+	return fmt.Sprintf("day:[%s..%s]", date(x.since), date(x.until))
+}
+
+func (x *dayRange) eval(p *planner) (*bitmap.Wahl, error) {
+	return p.days(x)
+}
+
+// tags returns the date tags that cover the range: the year tags of whole
+// years, the month tags of whole months, and the day tags of the remaining
+// days. An open range is bounded by first or last. Year and month tags are
+// only used for years and months that start at or after dated, i.e. the time
+// since which objects are tagged with them, and never if dated is zero.
+func (x *dayRange) tags(first, last, dated time.Time) []string {
+	var since, until = x.since, x.until
+	if since.IsZero() {
+		since = first
+	}
+	if until.IsZero() {
+		until = last
+	}
+	var tags []string
+	for day := since; !day.After(until); {
+		var rollup = !dated.IsZero() && !day.Before(dated)
+		switch _, m, d := day.Date(); {
+		case rollup && m == time.January && d == 1 && !day.AddDate(1, 0, -1).After(until):
+			tags = append(tags, systemic.YearTag(day))
+			day = day.AddDate(1, 0, 0)
+		case rollup && d == 1 && !day.AddDate(0, 1, -1).After(until):
+			tags = append(tags, systemic.MonthTag(day))
+			day = day.AddDate(0, 1, 0)
+		default:
+			tags = append(tags, systemic.DayTag(day))
+			day = day.AddDate(0, 0, 1)
+		}
+	}
+	return tags
+}
+
+// truncateDay returns the (local time) start of the day of t.
+func truncateDay(t time.Time) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+}
+
+type notExpr struct {
+	x queryExpr
+}
+
+func (x notExpr) String() string { return fmt.Sprintf("!%s", x.x) }
+func (x notExpr) eval(p *planner) (*bitmap.Wahl, error) {
+	return p.not(x.x)
+}
+
+type exprOp byte
+
+const (
+	_ exprOp = iota
+	andOp
+	orOp
+	xorOp
+)
+
+type binaryExpr struct {
+	op   exprOp
+	args []queryExpr
+}
+
+func (x binaryExpr) String() string {
+	var sep string
+	switch x.op {
+	case andOp:
+		sep = " & "
+	case orOp:
+		sep = " | "
+	case xorOp:
+		sep = " ^ "
+	}
+	var args = make([]string, len(x.args))
+	for i, arg := range x.args {
+		args[i] = arg.String()
+	}
+	return fmt.Sprintf("(%s)", strings.Join(args, sep))
+}
+
+func (x binaryExpr) eval(p *planner) (*bitmap.Wahl, error) {
+	if x.op == andOp {
+		return p.and(p.conjunction(x))
+	}
+	return p.combine(x.op, x.args)
+}
+
+/// parser /////////////////////////////////////////////////////////////////////
+
+// expression grammar:
+//
+//	expr   := xor { '|' xor }
+//	xor    := and { '^' and }
+//	and    := unary { '&' unary }
+//	unary  := '!' unary | term
+//	term   := '(' expr ')' | tag
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+const exprOpChars = "()!&^|"
+const exprQuoteChars = `"\`
+
+// exprToken is an operator, grouping, or tag token.
+type exprToken struct {
+	s   string
+	tag bool // quoted tags may be any string, e.g. "|"
+}
+
+// is returns true if the token is the (non-tag) token s.
+func (t exprToken) is(s string) bool { return !t.tag && t.s == s }
+
+func parseExpr(s string) (queryExpr, error) {
+	tokens, e := tokenizeExpr(s)
+	if e != nil {
+		return nil, e
+	}
+	var p = &exprParser{tokens: tokens}
+	if len(p.tokens) == 0 {
+		return nil, errors.ErrInvalidArg
+	}
+	x, e := p.parseOr()
+	if e != nil {
+		return nil, e
+	}
+	if tok, ok := p.peek(); ok {
+		return nil, errors.Error("unexpected %q at token %d", tok.s, p.pos)
+	}
+	return x, nil
+}
+
+// tokenizeExpr splits the expression into operator, grouping, and tag tokens.
+// A quoted tag is a single token, and a quote must be preceded and followed
+// by white-space, an operator, or a grouping character, e.g. a"b" is an error.
+//
+// Returns nil, error if a quoted tag is not terminated or is zero-len.
+func tokenizeExpr(s string) ([]exprToken, error) {
+	var tokens []exprToken
+	var tag []rune
+	var flush = func() {
+		if len(tag) > 0 {
+			tokens = append(tokens, exprToken{string(tag), true})
+			tag = tag[:0]
+		}
+	}
+	var runes = []rune(s)
+	for i := 0; i < len(runes); i++ {
+		var c = runes[i]
+		switch {
+		case unicode.IsSpace(c):
+			flush()
+		case strings.ContainsRune(exprOpChars, c):
+			flush()
+			tokens = append(tokens, exprToken{string(c), false})
+		case c == '"':
+			if len(tag) > 0 {
+				return nil, errors.Error("unexpected quote at %d", i)
+			}
+			var quoted []rune
+			for i++; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				quoted = append(quoted, runes[i])
+			}
+			if i == len(runes) {
+				return nil, errors.Error("unterminated quote")
+			}
+			if len(quoted) == 0 {
+				return nil, errors.Error("zero-len quoted tag at %d", i-1)
+			}
+			if i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) && !strings.ContainsRune(exprOpChars, runes[i+1]) {
+				return nil, errors.Error("unexpected %q after quote at %d", runes[i+1], i)
+			}
+			tokens = append(tokens, exprToken{string(quoted), true})
+		default:
+			tag = append(tag, c)
+		}
+	}
+	flush()
+	return tokens, nil
+}
+
+func (p *exprParser) peek() (exprToken, bool) {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos], true
+	}
+	return exprToken{}, false
+}
+
+func (p *exprParser) next() (exprToken, bool) {
+	tok, ok := p.peek()
+	if ok {
+		p.pos++
+	}
+	return tok, ok
+}
+
+func (p *exprParser) parseOr() (queryExpr, error) {
+	return p.parseBinary(orOp, "|", p.parseXor)
+}
+
+func (p *exprParser) parseXor() (queryExpr, error) {
+	return p.parseBinary(xorOp, "^", p.parseAnd)
+}
+
+func (p *exprParser) parseAnd() (queryExpr, error) {
+	return p.parseBinary(andOp, "&", p.parseUnary)
+}
+
+func (p *exprParser) parseBinary(op exprOp, opTok string, parseArg func() (queryExpr, error)) (queryExpr, error) {
+	x, e := parseArg()
+	if e != nil {
+		return nil, e
+	}
+	var args = []queryExpr{x}
+	for {
+		if tok, ok := p.peek(); !ok || !tok.is(opTok) {
+			break
+		}
+		p.pos++
+		x, e := parseArg()
+		if e != nil {
+			return nil, e
+		}
+		args = append(args, x)
+	}
+	if len(args) == 1 {
+		return args[0], nil
+	}
+	return binaryExpr{op, args}, nil
+}
+
+func (p *exprParser) parseUnary() (queryExpr, error) {
+	if tok, ok := p.peek(); ok && tok.is("!") {
+		p.pos++
+		x, e := p.parseUnary()
+		if e != nil {
+			return nil, e
+		}
+		return notExpr{x}, nil
+	}
+	return p.parseTerm()
+}
+
+func (p *exprParser) parseTerm() (queryExpr, error) {
+	tok, ok := p.next()
+	if !ok {
+		return nil, errors.Error("unexpected end of query")
+	}
+	switch {
+	case tok.tag:
+		return tagExpr(exprTag(tok.s)), nil
+	case tok.is("("):
+		x, e := p.parseOr()
+		if e != nil {
+			return nil, e
+		}
+		if tok, ok := p.next(); !ok || !tok.is(")") {
+			return nil, errors.Error("missing ')' at token %d", p.pos)
+		}
+		return x, nil
+	}
+	return nil, errors.Error("unexpected %q at token %d", tok.s, p.pos-1)
+}
+
+// exprTag maps the shorthand systemic tag prefixes to systemic tags.
+func exprTag(tag string) string {
+	tag = strings.ToLower(tag)
+	switch {
+	case strings.HasPrefix(tag, "ext:"):
+		return systemic.ExtTag(tag[len("ext:"):])
+	case strings.HasPrefix(tag, "type:"):
+		return systemic.TypeTag(tag[len("type:"):])
+	}
+	return tag
+}
@@ -0,0 +1,187 @@
+// Doost!
+
+package index
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alphazero/gart/system/systemic"
+)
+
+func TestParseExpr(t *testing.T) {
+	var tests = []struct {
+		expr   string
+		expect string // canonical form - see queryExpr.String
+	}{
+		// tags
+		{"jazz", "jazz"},
+		{"  Jazz  ", "jazz"},
+		{"rock-n-roll", "rock-n-roll"},
+		// precedence: ! > & > ^ > |
+		{"a & b", "(a & b)"},
+		{"a&b&c", "(a & b & c)"},
+		{"a | b & c", "(a | (b & c))"},
+		{"a & b | c", "((a & b) | c)"},
+		{"a ^ b & c", "(a ^ (b & c))"},
+		{"a | b ^ c", "(a | (b ^ c))"},
+		{"a ^ b | c ^ d", "((a ^ b) | (c ^ d))"},
+		{"!a & b", "(!a & b)"},
+		{"!a | !b", "(!a | !b)"},
+		// negation
+		{"!a", "!a"},
+		{"!!a", "!!a"},
+		{"a & !b", "(a & !b)"},
+		{"!(a | b)", "!(a | b)"},
+		// parenthesis
+		{"(a)", "a"},
+		{"((a))", "a"},
+		{"(a | b) & c", "((a | b) & c)"},
+		{"a & (b ^ c)", "(a & (b ^ c))"},
+		{"(jazz | blues) & 1960s & !bootleg", "((jazz | blues) & 1960s & !bootleg)"},
+		// shorthands
+		{"ext:PDF", systemic.ExtTag("pdf")},
+		{"ext:", systemic.ExtTag("")},
+		{"type:text", systemic.TypeTag("text")},
+		{"a & !ext:md", "(a & !" + systemic.ExtTag("md") + ")"},
+		// quoted tags
+		{`"tag 2"`, `"tag 2"`},
+		{`"Tag 2"&b`, `("tag 2" & b)`},
+		{`("a | b")`, `"a | b"`},
+		{`!"(x)" | "y"`, `(!"(x)" | y)`},
+		{`"say \"hi\""`, `"say \"hi\""`},
+		{`"a\\b"`, `"a\\b"`},
+		{`"ext:PDF"`, systemic.ExtTag("pdf")},
+	}
+	for _, test := range tests {
+		x, e := parseExpr(test.expr)
+		if e != nil {
+			t.Fatalf("parseExpr(%q) - %v", test.expr, e)
+		}
+		if have := x.String(); have != test.expect {
+			t.Fatalf("parseExpr(%q) have:%q - expect:%q", test.expr, have, test.expect)
+		}
+		// the canonical form is parsable
+		if x, e = parseExpr(test.expect); e != nil || x.String() != test.expect {
+			t.Fatalf("parseExpr(%q) have:%v e:%v", test.expect, x, e)
+		}
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	var tests = []string{
+		"",
+		"   ",
+		"a &",
+		"& a",
+		"a | | b",
+		"a b",
+		"!",
+		"a & !",
+		"(a",
+		"(a | b",
+		"a)",
+		"()",
+		"(a) (b)",
+		"a ^",
+		`"a`,
+		`"a & b`,
+		`""`,
+		`a"b"`,
+		`"a"b`,
+		`"a" "b"`,
+	}
+	for _, expr := range tests {
+		if x, e := parseExpr(expr); e == nil {
+			t.Fatalf("parseExpr(%q) - expected error - have:%s", expr, x)
+		}
+	}
+	if _, e := ParseQuery("a & (b"); e == nil {
+		t.Fatalf("ParseQuery - expected error")
+	}
+}
+
+func localDate(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+}
+
+func TestDayRangeTags(t *testing.T) {
+	var day = func(y int, m time.Month, d int) string { return systemic.DayTag(localDate(y, m, d)) }
+	var month = func(y int, m time.Month) string { return systemic.MonthTag(localDate(y, m, 1)) }
+	var year = func(y int) string { return systemic.YearTag(localDate(y, 1, 1)) }
+	var days = func(y int, m time.Month, from, to int) []string {
+		var tags []string
+		for d := from; d <= to; d++ {
+			tags = append(tags, day(y, m, d))
+		}
+		return tags
+	}
+	var months = func(y int, from, to time.Month) []string {
+		var tags []string
+		for m := from; m <= to; m++ {
+			tags = append(tags, month(y, m))
+		}
+		return tags
+	}
+	var first, last = localDate(2018, 3, 30), localDate(2019, 1, 1) // bounds of open ranges
+
+	var tests = []struct {
+		since, until time.Time
+		expect       []string
+	}{
+		// days
+		{localDate(2018, 3, 5), localDate(2018, 3, 5), []string{day(2018, 3, 5)}},
+		{localDate(2018, 3, 5), localDate(2018, 3, 7), days(2018, 3, 5, 7)},
+		{localDate(2018, 3, 6), localDate(2018, 3, 5), nil},
+		// whole months
+		{localDate(2018, 3, 1), localDate(2018, 3, 31), []string{month(2018, 3)}},
+		{localDate(2018, 3, 1), localDate(2018, 3, 30), days(2018, 3, 1, 30)},
+		{localDate(2018, 2, 27), localDate(2018, 4, 2),
+			append(append(days(2018, 2, 27, 28), month(2018, 3)), days(2018, 4, 1, 2)...)},
+		{localDate(2020, 2, 1), localDate(2020, 2, 28), days(2020, 2, 1, 28)}, // leap year
+		{localDate(2020, 2, 1), localDate(2020, 2, 29), []string{month(2020, 2)}},
+		// whole years
+		{localDate(2018, 1, 1), localDate(2018, 12, 31), []string{year(2018)}},
+		{localDate(2018, 1, 1), localDate(2018, 12, 30), append(months(2018, 1, 11), days(2018, 12, 1, 30)...)},
+		{localDate(2017, 12, 31), localDate(2020, 1, 1), []string{day(2017, 12, 31), year(2018), year(2019), day(2020, 1, 1)}},
+		{localDate(2017, 11, 1), localDate(2019, 2, 28),
+			[]string{month(2017, 11), month(2017, 12), year(2018), month(2019, 1), month(2019, 2)}},
+		// open ranges
+		{time.Time{}, localDate(2018, 4, 30), []string{day(2018, 3, 30), day(2018, 3, 31), month(2018, 4)}},
+		{localDate(2018, 12, 31), time.Time{}, []string{day(2018, 12, 31), day(2019, 1, 1)}},
+		{time.Time{}, time.Time{},
+			append(append(days(2018, 3, 30, 31), months(2018, 4, 12)...), day(2019, 1, 1))},
+	}
+	var dated = time.Unix(0, 1) // all objects have month and year tags
+	for i, test := range tests {
+		var x = &dayRange{test.since, test.until}
+		if have := x.tags(first, last, dated); fmt.Sprint(have) != fmt.Sprint(test.expect) {
+			t.Fatalf("test %d: %s tags have:%q - expect:%q", i, x, have, test.expect)
+		}
+	}
+
+	// objects added before dated only have day tags
+	var x = &dayRange{localDate(2018, 1, 1), localDate(2018, 12, 31)}
+	var dateTests = []struct {
+		dated  time.Time
+		expect []string
+	}{
+		{time.Time{}, nil},
+		{localDate(2018, 1, 1), []string{year(2018)}},
+		{localDate(2018, 1, 1).Add(time.Second), append(days(2018, 1, 1, 31), months(2018, 2, 12)...)},
+		{localDate(2018, 3, 15).Add(time.Hour), append(append(append(
+			days(2018, 1, 1, 31), days(2018, 2, 1, 28)...), days(2018, 3, 1, 31)...), months(2018, 4, 12)...)},
+		{localDate(2019, 1, 1), nil},
+	}
+	for i, test := range dateTests {
+		if test.expect == nil {
+			for m := time.January; m <= time.December; m++ {
+				test.expect = append(test.expect, days(2018, m, 1, localDate(2018, m+1, 0).Day())...)
+			}
+		}
+		if have := x.tags(first, last, test.dated); fmt.Sprint(have) != fmt.Sprint(test.expect) {
+			t.Fatalf("dated test %d: %s tags have:%q - expect:%q", i, x, have, test.expect)
+		}
+	}
+}
@@ -0,0 +1,528 @@
+// Doost!
+
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/alphazero/gart/repo"
+	"github.com/alphazero/gart/store"
+	"github.com/alphazero/gart/syslib/bitmap"
+	"github.com/alphazero/gart/syslib/debug"
+	"github.com/alphazero/gart/syslib/errors"
+	"github.com/alphazero/gart/system/systemic"
+)
+
+/// index integrity check //////////////////////////////////////////////////////
+
+// Fsck verifies the integrity of the entire index:
+//
+//   - the interrupted session journal and uncommitted objects.idx records
+//   - leftover swapfiles
+//   - objects.idx, card, tagmap, and tag dictionary checksums
+//   - that the key of each card maps back to its oid in objects.idx, and that
+//     every object key has a card
+//   - that the key bit of each (non-deleted) card is set in exactly the
+//     tagmaps of the card's tags
+//   - orphan tagmaps, i.e. tagmaps of tags neither applied to any card nor
+//     defined in the tag dictionary
+//   - tag dictionary reference counts
+//
+// If repair is true, the discrepancies that can be fixed are repaired: the
+// interrupted session is recovered; swapfiles and orphan tagmaps are removed;
+// tagmaps are rewritten from the cards; and the tag dictionary reference
+// counts are corrected. Corrupt cards and objects.idx, and card key errors
+// are not repairable.
+//
+// The repo is locked (exclusively if repair) for the duration of the call,
+// and Fsck must not be called while an IndexManager is open.
+//
+// Returns the report, and nil, error on any error other than the problems
+// found.
+func Fsck(repair bool) (*FsckReport, error) {
+	var err = errors.For("index.Fsck")
+
+	var opMode = Read
+	if repair {
+		opMode = Write
+	}
+	lock, e := lockRepo(opMode)
+	if e != nil {
+		return nil, e
+	}
+	defer lock.Unlock()
+
+	var f = &fsck{
+		repair:   repair,
+		report:   &FsckReport{},
+		cardTags: make(map[string]struct{}),
+		tagKeys:  make(map[string][]uint),
+	}
+	var steps = []struct {
+		name string
+		fn   func() error
+	}{
+		{"session", f.checkSession},
+		{"swapfiles", f.checkSwapfiles},
+		{"objects", f.checkObjectIndex},
+		{"cards", f.checkCards},
+		{"tagmaps", f.checkTagmaps},
+		{"tagdict", f.checkTagDictionary},
+	}
+	defer func() {
+		if f.oidx != nil {
+			f.oidx.closeIndex(false)
+		}
+	}()
+	for _, step := range steps {
+		if e := step.fn(); e != nil {
+			return nil, err.ErrorWithCause(e, "on check %s", step.name)
+		}
+		// the index is not checked until the interrupted session is recovered.
+		if f.interrupted {
+			break
+		}
+	}
+	return f.report, nil
+}
+
+// FsckIssue is an index integrity problem found by Fsck.
+type FsckIssue struct {
+	Path     string // repo relative path of the file with the problem
+	Problem  string
+	Repaired bool
+}
+
+func (v FsckIssue) String() string {
+	var s = fmt.Sprintf("%s: %s", v.Path, v.Problem)
+	if v.Repaired {
+		s += " (repaired)"
+	}
+	return s
+}
+
+// FsckReport is the result of Fsck.
+type FsckReport struct {
+	Objects int // objects.idx object count
+	Cards   int // number of cards checked
+	Deleted int // number of deleted cards
+	Tagmaps int // number of tagmaps checked
+	Issues  []FsckIssue
+}
+
+// Returns the number of issues that were not repaired.
+func (r *FsckReport) Unrepaired() int {
+	var n int
+	for _, issue := range r.Issues {
+		if !issue.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *FsckReport) Print(w io.Writer) {
+	for _, issue := range r.Issues {
+		fmt.Fprintf(w, "%s\n", issue)
+	}
+	fmt.Fprintf(w, "objects:%d cards:%d (deleted:%d) tagmaps:%d - issues:%d unrepaired:%d\n",
+		r.Objects, r.Cards, r.Deleted, r.Tagmaps, len(r.Issues), r.Unrepaired())
+}
+
+/// fsck checks ////////////////////////////////////////////////////////////////
+
+type fsck struct {
+	repair      bool
+	interrupted bool // true if an interrupted session was not recovered
+	report      *FsckReport
+	oidx        *oidxFile           // nil if objects.idx is corrupt
+	cardKeys    map[int64]string    // key -> card oid
+	valid       map[int64]bool      // keys of the verified cards
+	unknown     []uint              // keys of objects with unreadable or mis-keyed cards
+	cardTags    map[string]struct{} // tags of all (including deleted) cards
+	tagKeys     map[string][]uint   // tag -> keys of non-deleted cards
+}
+
+// issue adds an issue to the report. repaired indicates if the issue has been
+// repaired.
+func (f *fsck) issue(filename string, repaired bool, format string, a ...interface{}) {
+	path, e := filepath.Rel(repo.RepoPath, filename)
+	if e != nil {
+		path = filename
+	}
+	f.report.Issues = append(f.report.Issues, FsckIssue{
+		Path:     path,
+		Problem:  fmt.Sprintf(format, a...),
+		Repaired: repaired,
+	})
+}
+
+// checkSession checks for an interrupted session: an interrupted Reindex, a
+// journal to redo, or uncommitted objects.idx records to undo. (See
+// journal.go and reindex.go)
+func (f *fsck) checkSession() error {
+	if _, e := os.Stat(repo.IndexTagmapsPath); os.IsNotExist(e) {
+		if f.repair {
+			if e := recoverReindex(); e != nil {
+				return e
+			}
+		}
+		f.issue(repo.IndexTagmapsPath, f.repair, "missing tagmaps directory of interrupted reindex")
+		if f.interrupted = !f.repair; f.interrupted {
+			return nil
+		}
+	}
+	j, e := readJournal()
+	if e != nil {
+		f.issue(repo.JournalPath, false, "corrupt journal - %v", e)
+		return nil
+	}
+	var interrupted bool
+	if j != nil {
+		interrupted = true
+	} else if ok, e := repairObjectIndex(nil, true); e != nil {
+		f.issue(repo.ObjectIndexPath, false, "corrupt - %v", e)
+		return nil
+	} else {
+		interrupted = ok
+	}
+	if !interrupted {
+		return nil
+	}
+	if f.repair {
+		if e := recoverSession(); e != nil {
+			return e
+		}
+	}
+	f.interrupted = !f.repair
+	if j != nil {
+		f.issue(repo.JournalPath, f.repair, "journal of interrupted commit (files:%d)", len(j.files))
+	} else {
+		f.issue(repo.ObjectIndexPath, f.repair, "uncommitted records of interrupted session")
+	}
+	return nil
+}
+
+// checkSwapfiles checks for leftover swapfiles.
+func (f *fsck) checkSwapfiles() error {
+	return filepath.Walk(repo.RepoPath, func(path string, info os.FileInfo, e error) error {
+		if e != nil {
+			return e
+		}
+		var fname = info.Name()
+		if info.IsDir() || !strings.HasPrefix(fname, ".") || !strings.HasSuffix(fname, ".swp") {
+			return nil
+		}
+		if f.repair {
+			if e := os.Remove(path); e != nil {
+				return e
+			}
+		}
+		f.issue(path, f.repair, "leftover swapfile")
+		return nil
+	})
+}
+
+// checkObjectIndex opens objects.idx. Opening the index verifies its checksum.
+func (f *fsck) checkObjectIndex() error {
+	oidx, e := openObjectIndex(Read)
+	if e != nil {
+		f.issue(repo.ObjectIndexPath, false, "corrupt - %v", e)
+		return nil
+	}
+	f.oidx = oidx
+	f.report.Objects = int(oidx.header.ocnt)
+	return nil
+}
+
+// checkCards verifies every card and that the card keys map back to the card
+// oids. Tags and keys of the cards are collected for checkTagmaps.
+func (f *fsck) checkCards() error {
+	oids, e := cardOids()
+	if e != nil {
+		return e
+	}
+	f.cardKeys = make(map[int64]string, len(oids))
+	f.valid = make(map[int64]bool, len(oids))
+	for _, oid := range oids {
+		var filename = cardFilename(oid)
+		card, e := LoadCard(oid)
+		if e != nil {
+			f.issue(filename, false, "corrupt - %v", e)
+			continue
+		}
+		f.report.Cards++
+		for _, tag := range card.Tags() {
+			f.cardTags[tag] = struct{}{}
+		}
+		if card.IsDeleted() {
+			f.report.Deleted++
+		}
+		if card.IsStored() && !store.Exists(oid) {
+			f.issue(filename, false, "stored object %s is not in the object store", oid.Fingerprint())
+		} else if card.IsStored() && store.IsChunked(oid) {
+			if n, e := store.MissingChunks(oid); e != nil {
+				f.issue(store.Filename(oid), false, "corrupt chunk manifest - %v", e)
+			} else if n > 0 {
+				f.issue(store.Filename(oid), false, "%d chunks are not in the object store", n)
+			}
+		}
+
+		var key = card.Key()
+		if other, ok := f.cardKeys[key]; ok {
+			f.issue(filename, false, "key %d is also the key of card %s", key, other)
+			continue
+		}
+		f.cardKeys[key] = oid.String()
+		if f.oidx != nil {
+			if key < 0 || key >= f.oidx.header.ocnt {
+				f.issue(filename, false, "key %d is not in objects.idx (objects:%d)", key, f.oidx.header.ocnt)
+				continue
+			}
+			koid, e := f.oidx.getOid(int(key))
+			if e != nil {
+				return e
+			}
+			if !bytes.Equal(koid.Bytes(), oid.Bytes()) {
+				f.issue(filename, false, "key %d maps to oid %s in objects.idx", key, koid.Fingerprint())
+				continue
+			}
+		}
+		f.valid[key] = true
+
+		if card.IsDeleted() {
+			continue
+		}
+		for _, tag := range card.Tags() {
+			f.tagKeys[tag] = append(f.tagKeys[tag], uint(key))
+		}
+	}
+
+	// every object must have a card. objects with a card that has already
+	// been reported have unknown tags.
+	if f.oidx != nil {
+		for key := int64(0); key < f.oidx.header.ocnt; key++ {
+			if f.valid[key] {
+				continue
+			}
+			oid, e := f.oidx.getOid(int(key))
+			if e != nil {
+				return e
+			}
+			if cardExists(oid) {
+				f.unknown = append(f.unknown, uint(key))
+				continue
+			}
+			f.issue(repo.ObjectIndexPath, false, "object %s (key:%d) has no card", oid.Fingerprint(), key)
+		}
+	}
+	return nil
+}
+
+// checkTagmaps verifies every tagmap and that the tagmap bits are exactly the
+// keys of the (non-deleted) cards with the tag.
+func (f *fsck) checkTagmaps() error {
+	// tagmap file names are hashes. map them back using all known tags.
+	var names = make(map[string]string)
+	var addName = func(tag string) { names[TagmapFilename(tag)] = tag }
+	for tag := range f.cardTags {
+		addName(tag)
+	}
+	addName(systemic.GartTag())
+	// tags removed from all cards are retained in the tag dictionary (with a
+	// zero refcnt) until compact. their tagmaps are checked, not orphans.
+	var defined = make(map[string]bool)
+	if tagdict, e := loadTagDictionary(); e == nil {
+		for _, tag := range tagdict.Tags() {
+			addName(tag.Name())
+			defined[tag.Name()] = true
+		}
+	}
+
+	files, e := filepath.Glob(filepath.Join(repo.IndexTagmapsPath, "??", "*"))
+	if e != nil {
+		return e
+	}
+	var checked = make(map[string]bool)
+	for _, filename := range files {
+		if strings.HasPrefix(filepath.Base(filename), ".") {
+			continue // swapfile
+		}
+		tag, ok := names[filename]
+		_, used := f.cardTags[tag]
+		if !ok || (!used && !defined[tag] && tag != systemic.GartTag()) {
+			// note: the tag may be a tag of an object with unknown tags.
+			var repair = f.repair && (!ok || len(f.unknown) == 0)
+			if repair {
+				if e := os.Remove(filename); e != nil {
+					return e
+				}
+			}
+			if ok {
+				f.issue(filename, repair, "orphan tagmap (tag %q)", tag)
+			} else {
+				f.issue(filename, repair, "orphan tagmap (unknown tag)")
+			}
+			continue
+		}
+		checked[tag] = true
+		f.report.Tagmaps++
+
+		tagmap, e := loadTagmap(tag, false)
+		if e != nil {
+			expect, e0 := f.expectedBitmap(tag, nil)
+			if e0 != nil {
+				return e0
+			}
+			if e0 := f.repairTagmap(tag, nil, expect); e0 != nil {
+				return e0
+			}
+			f.issue(filename, f.repair, "corrupt tagmap (tag %q) - %v", tag, e)
+			continue
+		}
+		expect, e := f.expectedBitmap(tag, tagmap.bitmap)
+		if e != nil {
+			return e
+		}
+		missing, e := expect.AndNot(tagmap.bitmap)
+		if e != nil {
+			return e
+		}
+		extra, e := tagmap.bitmap.AndNot(expect)
+		if e != nil {
+			return e
+		}
+		if m, x := missing.Count(), extra.Count(); m+x > 0 {
+			if e := f.repairTagmap(tag, tagmap.header, expect); e != nil {
+				return e
+			}
+			f.issue(filename, f.repair, "tagmap (tag %q) keys - missing:%d extra:%d", tag, m, x)
+		}
+	}
+
+	// tags of (non-deleted) cards must have a tagmap
+	var tags = make([]string, 0, len(f.tagKeys))
+	for tag := range f.tagKeys {
+		if !checked[tag] {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		expect, e := f.expectedBitmap(tag, nil)
+		if e != nil {
+			return e
+		}
+		if e := f.repairTagmap(tag, nil, expect); e != nil {
+			return e
+		}
+		f.issue(TagmapFilename(tag), f.repair, "missing tagmap (tag %q)", tag)
+	}
+	return nil
+}
+
+// expectedBitmap returns the bitmap of the keys of the (non-deleted) cards
+// with the tag. The bits of the actual bitmap, if not nil, for objects with
+// unknown tags (see checkCards) are retained.
+func (f *fsck) expectedBitmap(tag string, actual *bitmap.Wahl) (*bitmap.Wahl, error) {
+	var wahl = bitmap.NewWahl()
+	if keys := f.tagKeys[tag]; len(keys) > 0 {
+		wahl.Set(keys...)
+		wahl.Compress()
+	}
+	if actual == nil || len(f.unknown) == 0 {
+		return wahl, nil
+	}
+	var unknown = bitmap.NewWahl()
+	unknown.Set(f.unknown...)
+	unknown.Compress()
+	retained, e := actual.And(unknown)
+	if e != nil {
+		return nil, e
+	}
+	return wahl.Or(retained)
+}
+
+// repairTagmap (re)writes the tagmap with the expected bitmap, if repairing.
+// The header of the existing tagmap, if not nil, is updated.
+func (f *fsck) repairTagmap(tag string, header *tagmapHeader, expect *bitmap.Wahl) error {
+	var debug = debug.For("fsck.repairTagmap")
+	if !f.repair {
+		return nil
+	}
+	debug.Printf("rewrite tagmap %q", tag)
+
+	return writeTagmap(TagmapFilename(tag), tag, header, expect)
+}
+
+// checkTagDictionary verifies the tag dictionary and that its reference counts
+// are the number of (non-deleted) cards with the tag. A missing or corrupt
+// dictionary is rebuilt from the cards. The tags of the intact records of a
+// corrupt dictionary retain their ids (see buildTagDictionary).
+func (f *fsck) checkTagDictionary() error {
+	if _, e := os.Stat(repo.TagDictionaryPath); os.IsNotExist(e) {
+		f.issue(repo.TagDictionaryPath, f.repair, "tag dictionary does not exist")
+		return f.rebuildTagDictionary()
+	}
+	tagdict, loadErr := loadTagDictionary()
+	if loadErr != nil {
+		f.issue(repo.TagDictionaryPath, f.repair, "corrupt tag dictionary - %v", loadErr)
+		return f.rebuildTagDictionary()
+	}
+
+	var tags = make([]string, 0, len(f.tagKeys))
+	for tag := range f.tagKeys {
+		tags = append(tags, tag)
+	}
+	for _, tag := range tagdict.Tags() {
+		if _, ok := f.tagKeys[tag.Name()]; !ok && tag.Refcnt() > 0 {
+			tags = append(tags, tag.Name())
+		}
+	}
+	sort.Strings(tags)
+
+	for _, tag := range tags {
+		var refcnt = len(f.tagKeys[tag])
+		entry, ok := tagdict.tags[tag]
+		switch {
+		case !ok:
+			f.issue(repo.TagDictionaryPath, f.repair, "tag %q is not defined", tag)
+			if _, _, e := tagdict.Add(tag); e != nil {
+				return e
+			}
+			entry = tagdict.tags[tag]
+		case entry.refcnt != refcnt:
+			f.issue(repo.TagDictionaryPath, f.repair, "tag %q refcnt:%d - expect:%d", tag, entry.refcnt, refcnt)
+		default:
+			continue
+		}
+		entry.refcnt = refcnt
+		tagdict.modified = true
+	}
+
+	if f.repair {
+		if _, e := tagdict.Sync(); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// rebuildTagDictionary rebuilds the tag dictionary from the cards, if repairing.
+func (f *fsck) rebuildTagDictionary() error {
+	if !f.repair {
+		return nil
+	}
+	tagdict, e := buildTagDictionary()
+	if e != nil {
+		return e
+	}
+	_, e = tagdict.Sync()
+	return e
+}
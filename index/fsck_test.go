@@ -0,0 +1,213 @@
+// Doost!
+
+package index
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/alphazero/gart/repo"
+	"github.com/alphazero/gart/system/systemic"
+)
+
+// fsckRepair repairs the index and returns the problems of the issues found.
+// All issues are expected to be repaired.
+func fsckRepair(t *testing.T) []string {
+	report, e := Fsck(true)
+	if e != nil {
+		t.Fatalf("Fsck - %v", e)
+	}
+	var problems []string
+	for _, issue := range report.Issues {
+		if !issue.Repaired {
+			t.Fatalf("Fsck issue not repaired: %v", issue)
+		}
+		problems = append(problems, issue.Problem)
+	}
+	return problems
+}
+
+func TestFsckTagDictionary(t *testing.T) {
+	defer testRepo(t)()
+
+	indexTexts(t,
+		testObject{"x", []string{"a", "b"}},
+		testObject{"y", []string{"c"}},
+	)
+	var ids = tagIds(t)
+
+	// corrupt checksum - the records are intact and retain their ids
+	buf, e := ioutil.ReadFile(repo.TagDictionaryPath)
+	if e != nil {
+		t.Fatalf("%v", e)
+	}
+	buf[tagdictHeaderSize-1] ^= 0xff // dated
+	if e := ioutil.WriteFile(repo.TagDictionaryPath, buf, repo.FilePerm); e != nil {
+		t.Fatalf("%v", e)
+	}
+	if _, e := loadTagDictionary(); e == nil {
+		t.Fatalf("loadTagDictionary - corrupt checksum - expected error")
+	}
+	if problems := fsckRepair(t); len(problems) != 1 {
+		t.Fatalf("Fsck issues have:%q - expect 1", problems)
+	}
+	if have := tagIds(t); fmt.Sprint(have) != fmt.Sprint(ids) {
+		t.Fatalf("tag ids have:%v - expect:%v", have, ids)
+	}
+	fsckClean(t)
+
+	// missing
+	if e := os.Remove(repo.TagDictionaryPath); e != nil {
+		t.Fatalf("%v", e)
+	}
+	if problems := fsckRepair(t); len(problems) != 1 {
+		t.Fatalf("Fsck issues have:%q - expect 1", problems)
+	}
+	if have := tagIds(t); len(have) != len(ids) {
+		t.Fatalf("tags have:%v - expect:%v", have, ids)
+	}
+	fsckClean(t)
+}
+
+func TestFsckUnusedTag(t *testing.T) {
+	defer testRepo(t)()
+
+	oids := indexTexts(t, testObject{"x", []string{"a", "b"}})
+	writeSession(t, func(idx IndexManager) error {
+		_, e := idx.RemoveTags(oids[0], "a")
+		return e
+	})
+	// the empty tagmap of the unused (dictionary) tag is not an orphan
+	if _, e := os.Stat(TagmapFilename("a")); e != nil {
+		t.Fatalf("tagmap of unused tag - %v", e)
+	}
+	fsckClean(t)
+}
+
+// fsckProblems checks the index without repair and returns the problems of
+// the issues found.
+func fsckProblems(t *testing.T) []string {
+	report, e := Fsck(false)
+	if e != nil {
+		t.Fatalf("Fsck - %v", e)
+	}
+	var problems []string
+	for _, issue := range report.Issues {
+		if issue.Repaired {
+			t.Fatalf("Fsck issue repaired: %v", issue)
+		}
+		problems = append(problems, issue.Problem)
+	}
+	return problems
+}
+
+// setTagmapKeys sets the keys in the tagmap of the tag.
+func setTagmapKeys(t *testing.T, tag string, keys ...uint) {
+	tagmap, e := loadTagmap(tag, false)
+	if e != nil {
+		t.Fatalf("loadTagmap(%q) - %v", tag, e)
+	}
+	tagmap.update(setBits, keys...)
+	if e := writeTagmap(TagmapFilename(tag), tag, tagmap.header, tagmap.bitmap); e != nil {
+		t.Fatalf("writeTagmap(%q) - %v", tag, e)
+	}
+}
+
+func TestFsckTagmaps(t *testing.T) {
+	defer testRepo(t)()
+
+	oids := indexTexts(t,
+		testObject{"x", []string{"a", "b"}},
+		testObject{"y", []string{"a"}},
+	)
+	writeSession(t, func(idx IndexManager) error {
+		_, e := idx.RemoveTags(oids[0], "b")
+		return e
+	})
+
+	// stale bits - in the tagmap of a tag of other objects and of an unused tag
+	setTagmapKeys(t, "b", 1)
+	setTagmapKeys(t, "a", 2)
+	var expect = []string{
+		`tagmap (tag "a") keys - missing:0 extra:1`,
+		`tagmap (tag "b") keys - missing:0 extra:1`,
+	}
+	if problems := fsckProblems(t); fmt.Sprint(problems) != fmt.Sprint(expect) {
+		t.Fatalf("Fsck issues have:%q - expect:%q", problems, expect)
+	}
+	if problems := fsckRepair(t); fmt.Sprint(problems) != fmt.Sprint(expect) {
+		t.Fatalf("Fsck issues have:%q - expect:%q", problems, expect)
+	}
+	expectKeys(t, map[string][]int{"a": {0, 1}, "b": nil})
+	fsckClean(t)
+
+	// missing tagmap
+	if e := os.Remove(TagmapFilename("a")); e != nil {
+		t.Fatalf("%v", e)
+	}
+	expect = []string{`missing tagmap (tag "a")`}
+	if problems := fsckRepair(t); fmt.Sprint(problems) != fmt.Sprint(expect) {
+		t.Fatalf("Fsck issues have:%q - expect:%q", problems, expect)
+	}
+	expectKeys(t, map[string][]int{"a": {0, 1}})
+	fsckClean(t)
+}
+
+func TestFsckMissingCard(t *testing.T) {
+	defer testRepo(t)()
+
+	oids := indexTexts(t,
+		testObject{"x", []string{"a"}},
+		testObject{"y", []string{"a"}},
+	)
+	if e := os.Remove(cardFilename(oids[1])); e != nil {
+		t.Fatalf("%v", e)
+	}
+	// the missing card is not repairable. the key of the object is cleared
+	// from its tagmaps, and the refcnts of its tags are corrected.
+	var expect = fmt.Sprintf("object %s (key:1) has no card", oids[1].Fingerprint())
+	for _, repair := range []bool{true, false} {
+		report, e := Fsck(repair)
+		if e != nil {
+			t.Fatalf("Fsck - %v", e)
+		}
+		if report.Unrepaired() != 1 || report.Issues[0].Problem != expect {
+			t.Fatalf("Fsck(%t) issues have:%v - expect unrepaired:%q", repair, report.Issues, expect)
+		}
+		if repair && len(report.Issues) == 1 {
+			t.Fatalf("Fsck(%t) issues have:%v - expect repaired tagmaps", repair, report.Issues)
+		}
+		if !repair && len(report.Issues) != 1 {
+			t.Fatalf("Fsck(%t) issues have:%v - expect:%q", repair, report.Issues, expect)
+		}
+	}
+	expectKeys(t, map[string][]int{"a": {0}, systemic.GartTag(): {0}})
+	expectRefcnts(t, map[string]int{"a": 1})
+}
+
+func TestFsckInterruptedReindex(t *testing.T) {
+	defer testRepo(t)()
+
+	indexTexts(t,
+		testObject{"x", []string{"a"}},
+		testObject{"y", []string{"b"}},
+	)
+	// interrupted after the tagmaps dir is moved aside
+	if e := os.Rename(repo.IndexTagmapsPath, reindexOldPath()); e != nil {
+		t.Fatalf("%v", e)
+	}
+	var expect = []string{"missing tagmaps directory of interrupted reindex"}
+	if problems := fsckProblems(t); fmt.Sprint(problems) != fmt.Sprint(expect) {
+		t.Fatalf("Fsck issues have:%q - expect:%q", problems, expect)
+	}
+	if problems := fsckRepair(t); fmt.Sprint(problems) != fmt.Sprint(expect) {
+		t.Fatalf("Fsck issues have:%q - expect:%q", problems, expect)
+	}
+	if _, e := os.Stat(reindexOldPath()); !os.IsNotExist(e) {
+		t.Fatalf("%q not removed - %v", reindexOldPath(), e)
+	}
+	expectKeys(t, map[string][]int{"a": {0}, "b": {1}})
+	fsckClean(t)
+}
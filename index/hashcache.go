@@ -0,0 +1,317 @@
+// Doost!
+
+package index
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/alphazero/gart/repo"
+	"github.com/alphazero/gart/syslib/debug"
+	"github.com/alphazero/gart/syslib/digest"
+	"github.com/alphazero/gart/syslib/errors"
+	"github.com/alphazero/gart/syslib/fs"
+)
+
+// The hash cache maps files (device and inode) to the digests of their
+// content and the stat data (size and mtime) of the hashed content, so that
+// unchanged files are not re-hashed when added again. The cache is advisory:
+// it is not part of the index, it is saved (outside the session journal) on
+// commit, and a missing or corrupt cache file is simply discarded.
+//
+// Files modified within racyInterval of hashing are not cached, as the file
+// may be modified again without a change of its mtime.
+//
+// A file has (at most) one entry, which is replaced when a modified file is
+// hashed. Entries of removed files are evicted, least recently used first,
+// once the cache exceeds maxHashcacheEntries. Entries are stamped with the
+// session of their last use, and the stamps of hits are only saved with the
+// next modification of the cache, i.e. a session of hits does not rewrite the
+// cache file.
+
+/// consts and vars ///////////////////////////////////////////////////////////
+
+const (
+	mmap_hashcache_ftype uint64 = 0x9f13c58a6e27d04c
+)
+
+const (
+	hashcacheHeaderSize = 32
+	hashcacheRecordSize = 40 + digest.HashSize // dev, ino, size, mtime, used, md
+)
+
+const racyInterval = time.Second
+
+// max number of entries of the saved cache - 9MB file
+const maxHashcacheEntries = 1 << 17
+
+/// hash cache /////////////////////////////////////////////////////////////////
+
+type hashcacheKey struct {
+	dev uint64
+	ino uint64
+}
+
+type hashcacheEntry struct {
+	size  int64
+	mtime int64 // unix nanos
+	used  int64 // session (unix nanos) of last use
+	md    [digest.HashSize]byte
+}
+
+// hash cache file header. crc64 is the checksum of the full file (buf[16:]).
+type hashcacheHeader struct {
+	ftype   uint64
+	crc64   uint64
+	updated int64
+	ecnt    int64 // entry count
+}
+
+// hashCache is safe for concurrent use.
+type hashCache struct {
+	sync.Mutex
+	entries  map[hashcacheKey]hashcacheEntry
+	session  int64 // unix nanos
+	modified bool
+}
+
+// loadHashCache loads the hash cache file. An empty cache is returned if the
+// file does not exist or is corrupt.
+func loadHashCache() *hashCache {
+	var debug = debug.For("index.loadHashCache")
+
+	var c = &hashCache{
+		entries: make(map[hashcacheKey]hashcacheEntry),
+		session: time.Now().UnixNano(),
+	}
+	buf, e := ioutil.ReadFile(repo.HashCachePath)
+	if e != nil {
+		if !os.IsNotExist(e) {
+			debug.Printf("discard - %v", e)
+		}
+		return c
+	}
+	var header hashcacheHeader
+	if e := header.decode(buf); e != nil {
+		debug.Printf("discard - %v", e)
+		return c
+	}
+	for i := int64(0); i < header.ecnt; i++ {
+		var rec = buf[hashcacheHeaderSize+i*hashcacheRecordSize:]
+		var key = hashcacheKey{
+			dev: *(*uint64)(unsafe.Pointer(&rec[0])),
+			ino: *(*uint64)(unsafe.Pointer(&rec[8])),
+		}
+		var entry = hashcacheEntry{
+			size:  *(*int64)(unsafe.Pointer(&rec[16])),
+			mtime: *(*int64)(unsafe.Pointer(&rec[24])),
+			used:  *(*int64)(unsafe.Pointer(&rec[32])),
+		}
+		copy(entry.md[:], rec[40:hashcacheRecordSize])
+		c.entries[key] = entry
+	}
+	debug.Printf("loaded %d entries", len(c.entries))
+	return c
+}
+
+// sumFile returns the digest of the named file. If rehash is false, and the
+// file is cached with unchanged stat data, the cached digest is returned.
+// Otherwise, the file is hashed and its entry is replaced.
+func (c *hashCache) sumFile(filename string, rehash bool) ([]byte, error) {
+	var t0 = time.Now()
+	fd, e := fs.GetFileDetails(filename)
+	if e != nil {
+		// note: not wrapped - see indexManager.IndexFile
+		return nil, e
+	}
+	key, stat, ok := statKey(fd)
+	if !ok {
+		return digest.SumFile(filename)
+	}
+
+	if !rehash {
+		c.Lock()
+		entry, ok := c.entries[key]
+		ok = ok && entry.size == stat.size && entry.mtime == stat.mtime
+		if ok {
+			entry.used = c.session // saved with the next modification
+			c.entries[key] = entry
+		}
+		c.Unlock()
+		if ok {
+			return entry.md[:], nil
+		}
+	}
+
+	md, e := digest.SumFile(filename)
+	if e != nil {
+		return nil, e
+	}
+	if t0.Sub(fd.Fstat.ModTime()) < racyInterval {
+		// may be modified again w/ same mtime. the entry (if any) is stale.
+		c.Lock()
+		if _, ok := c.entries[key]; ok {
+			delete(c.entries, key)
+			c.modified = true
+		}
+		c.Unlock()
+		return md, nil
+	}
+	var entry = stat
+	entry.used = c.session
+	copy(entry.md[:], md)
+	c.Lock()
+	c.entries[key] = entry
+	c.modified = true
+	c.Unlock()
+
+	return md, nil
+}
+
+// size returns the size of the content with the given digest, if a file with
+// the content is cached.
+func (c *hashCache) size(md []byte) (int64, bool) {
+	c.Lock()
+	defer c.Unlock()
+	for _, entry := range c.entries {
+		if bytes.Equal(entry.md[:], md) {
+			return entry.size, true
+		}
+	}
+	return 0, false
+}
+
+// statKey returns the cache key of the file, and an entry with the stat data
+// of the file.
+func statKey(fd *fs.FileDetails) (hashcacheKey, hashcacheEntry, bool) {
+	stat, ok := fd.Fstat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return hashcacheKey{}, hashcacheEntry{}, false
+	}
+	var key = hashcacheKey{
+		dev: uint64(stat.Dev),
+		ino: uint64(stat.Ino),
+	}
+	var entry = hashcacheEntry{
+		size:  fd.Fstat.Size(),
+		mtime: fd.Fstat.ModTime().UnixNano(),
+	}
+	return key, entry, true
+}
+
+// evict removes the least recently used entries in excess of max entries.
+// Returns the number of evicted entries. Cache must be locked.
+func (c *hashCache) evict(max int) int {
+	var n = len(c.entries) - max
+	if n <= 0 {
+		return 0
+	}
+	type lru struct {
+		key  hashcacheKey
+		used int64
+	}
+	var entries = make([]lru, 0, len(c.entries))
+	for key, entry := range c.entries {
+		entries = append(entries, lru{key, entry.used})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].used < entries[j].used })
+	for _, entry := range entries[:n] {
+		delete(c.entries, entry.key)
+	}
+	c.modified = true
+	return n
+}
+
+// save writes the hash cache file via a swapfile, if the cache is modified.
+// Entries in excess of maxHashcacheEntries are first evicted.
+func (c *hashCache) save() error {
+	var err = errors.For("hashCache.save")
+	var debug = debug.For("hashCache.save")
+
+	c.Lock()
+	defer c.Unlock()
+
+	if !c.modified {
+		return nil
+	}
+	if n := c.evict(maxHashcacheEntries); n > 0 {
+		debug.Printf("evicted %d entries", n)
+	}
+
+	var header = &hashcacheHeader{
+		ftype:   mmap_hashcache_ftype,
+		updated: time.Now().UnixNano(),
+		ecnt:    int64(len(c.entries)),
+	}
+	var buf = make([]byte, hashcacheHeaderSize+len(c.entries)*hashcacheRecordSize)
+	var xof = hashcacheHeaderSize
+	for key, entry := range c.entries {
+		var rec = buf[xof:]
+		*(*uint64)(unsafe.Pointer(&rec[0])) = key.dev
+		*(*uint64)(unsafe.Pointer(&rec[8])) = key.ino
+		*(*int64)(unsafe.Pointer(&rec[16])) = entry.size
+		*(*int64)(unsafe.Pointer(&rec[24])) = entry.mtime
+		*(*int64)(unsafe.Pointer(&rec[32])) = entry.used
+		copy(rec[40:hashcacheRecordSize], entry.md[:])
+		xof += hashcacheRecordSize
+	}
+	header.encode(buf)
+
+	var swapfile = fs.SwapfileName(repo.HashCachePath)
+	file, _, e := fs.OpenNewSwapfile(swapfile, false)
+	if e != nil {
+		return err.ErrorWithCause(e, "swapfile %q open-new", swapfile)
+	}
+	if _, e := file.Write(buf); e != nil {
+		file.Close()
+		os.Remove(swapfile)
+		return err.ErrorWithCause(e, "on swapfile write")
+	}
+	if e := file.Close(); e != nil {
+		os.Remove(swapfile)
+		return err.ErrorWithCause(e, "on swapfile close")
+	}
+	if e := os.Rename(swapfile, repo.HashCachePath); e != nil {
+		return err.ErrorWithCause(e, "os.Rename %q %q", swapfile, repo.HashCachePath)
+	}
+	c.modified = false
+
+	return nil
+}
+
+// encode writes the header to the head of the full file buffer, and computes
+// the checksum of the file.
+func (h *hashcacheHeader) encode(buf []byte) {
+	*(*uint64)(unsafe.Pointer(&buf[0])) = h.ftype
+	*(*int64)(unsafe.Pointer(&buf[16])) = h.updated
+	*(*int64)(unsafe.Pointer(&buf[24])) = h.ecnt
+
+	h.crc64 = digest.Checksum64(buf[16:])
+	*(*uint64)(unsafe.Pointer(&buf[8])) = h.crc64
+}
+
+// decode reads and verifies the header of the full file buffer.
+func (h *hashcacheHeader) decode(buf []byte) error {
+	var err = errors.For("hashcacheHeader.decode")
+	if len(buf) < hashcacheHeaderSize {
+		return err.InvalidArg("len(buf):%d < %d", len(buf), hashcacheHeaderSize)
+	}
+	*h = *(*hashcacheHeader)(unsafe.Pointer(&buf[0]))
+
+	if h.ftype != mmap_hashcache_ftype {
+		return err.Bug("ftype:%x - expect: %x", h.ftype, mmap_hashcache_ftype)
+	}
+	if crc64 := digest.Checksum64(buf[16:]); crc64 != h.crc64 {
+		return err.Bug("crc64:%x - expect: %x", crc64, h.crc64)
+	}
+	if n := int64(hashcacheHeaderSize) + h.ecnt*hashcacheRecordSize; n != int64(len(buf)) {
+		return err.Bug("len(buf):%d - expect: %d (ecnt:%d)", len(buf), n, h.ecnt)
+	}
+	return nil
+}
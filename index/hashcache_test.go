@@ -0,0 +1,106 @@
+// Doost!
+
+package index
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alphazero/gart/repo"
+	"github.com/alphazero/gart/syslib/digest"
+)
+
+// writeTestFile writes the content to the file, with an mtime outside of the
+// racyInterval of hashing if past is true. Returns the digest of the content.
+func writeTestFile(t *testing.T, filename, content string, past bool) []byte {
+	if e := ioutil.WriteFile(filename, []byte(content), repo.FilePerm); e != nil {
+		t.Fatalf("%v", e)
+	}
+	if past {
+		var mtime = time.Now().Add(-time.Hour)
+		if e := os.Chtimes(filename, mtime, mtime); e != nil {
+			t.Fatalf("%v", e)
+		}
+	}
+	var md = digest.Sum([]byte(content))
+	return md[:]
+}
+
+func sumFileExpect(t *testing.T, c *hashCache, filename string, expect []byte, entries int) {
+	md, e := c.sumFile(filename, false)
+	if e != nil {
+		t.Fatalf("sumFile - %v", e)
+	}
+	if !bytes.Equal(md, expect) {
+		t.Fatalf("sumFile have:%x - expect:%x", md, expect)
+	}
+	if n := len(c.entries); n != entries {
+		t.Fatalf("entries have:%d - expect:%d", n, entries)
+	}
+}
+
+func TestHashCache(t *testing.T) {
+	defer testRepo(t)()
+	var filename = filepath.Join(filepath.Dir(repo.RepoPath), "file")
+
+	var c = loadHashCache()
+	var md = writeTestFile(t, filename, "content", true)
+	sumFileExpect(t, c, filename, md, 1)
+	if e := c.save(); e != nil {
+		t.Fatalf("save - %v", e)
+	}
+
+	// hits do not modify the cache
+	c = loadHashCache()
+	sumFileExpect(t, c, filename, md, 1)
+	if c.modified {
+		t.Fatalf("modified on hit")
+	}
+
+	// the entry of a modified file is replaced
+	md = writeTestFile(t, filename, "modified content", true)
+	sumFileExpect(t, c, filename, md, 1)
+	if !c.modified {
+		t.Fatalf("not modified on miss")
+	}
+
+	// and removed if the file is (racily) modified
+	md = writeTestFile(t, filename, "racy content", false)
+	sumFileExpect(t, c, filename, md, 0)
+	if e := c.save(); e != nil {
+		t.Fatalf("save - %v", e)
+	}
+	if c = loadHashCache(); len(c.entries) != 0 {
+		t.Fatalf("entries have:%d - expect:0", len(c.entries))
+	}
+}
+
+func TestHashCacheEvict(t *testing.T) {
+	defer testRepo(t)()
+
+	var c = loadHashCache()
+	for i, used := range []int64{30, 10, 40, 20} {
+		c.entries[hashcacheKey{ino: uint64(i)}] = hashcacheEntry{used: used}
+	}
+	if n := c.evict(4); n != 0 || c.modified {
+		t.Fatalf("evict(4) have:%d modified:%t - expect:0", n, c.modified)
+	}
+	if n := c.evict(2); n != 2 || !c.modified {
+		t.Fatalf("evict(2) have:%d modified:%t - expect:2", n, c.modified)
+	}
+	for _, ino := range []uint64{0, 2} {
+		if _, ok := c.entries[hashcacheKey{ino: ino}]; !ok {
+			t.Fatalf("most recently used entry %d evicted", ino)
+		}
+	}
+	if e := c.save(); e != nil {
+		t.Fatalf("save - %v", e)
+	}
+	if c = loadHashCache(); len(c.entries) != 2 || c.entries[hashcacheKey{ino: 2}].used != 40 {
+		t.Fatalf("loaded entries have:%v", c.entries)
+	}
+}
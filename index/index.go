@@ -3,11 +3,16 @@
 package index
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/alphazero/gart/repo"
+	"github.com/alphazero/gart/store"
 	"github.com/alphazero/gart/syslib/bitmap"
 	"github.com/alphazero/gart/syslib/debug"
 	"github.com/alphazero/gart/syslib/digest"
@@ -27,6 +32,7 @@ var (
 	ErrObjectIndexClosed   = errors.Error("object index is closeed")
 	ErrObjectExist         = errors.Error("object exists")
 	ErrObjectNotExist      = errors.Error("object does not exist")
+	ErrObjectType          = errors.Error("object exists as another type")
 )
 
 type Error struct {
@@ -49,6 +55,13 @@ func IsObjectNotExistErr(e0 error) bool {
 	}
 	return e.Err == ErrObjectNotExist
 }
+func IsObjectTypeErr(e0 error) bool {
+	e, ok := e0.(Error)
+	if !ok {
+		return false
+	}
+	return e.Err == ErrObjectType
+}
 func (v Error) Error() string {
 	return fmt.Sprintf("%s - %s object %s ", v.Err.Error(), v.Otype, v.Oid.Fingerprint())
 }
@@ -133,6 +146,11 @@ func Initialize(reinit bool) error {
 			"index.InitializeRepo: error creating objects index")
 	}
 
+	if e := createTagDictionary(); e != nil {
+		return errors.ErrorWithCause(e,
+			"index.InitializeRepo: error creating tag dictionary")
+	}
+
 	// Create systemic tagmaps.
 	if _, e := createTagmap(systemic.GartTag()); e != nil {
 		return errors.ErrorWithCause(e,
@@ -150,11 +168,20 @@ func Initialize(reinit bool) error {
 type IndexManager interface {
 	UsingTags(tags ...string) error
 	IndexText(bool, string, ...string) (Card, bool, error)
+	IndexURL(bool, string, ...string) (Card, bool, error)
+	IndexData(bool, *system.Oid, int64, string, bool, ...string) (Card, bool, error)
 	IndexFile(bool, string, ...string) (Card, bool, error)
-	Select(spec selectSpec, tags ...string) ([]*system.Oid, error)
-	Search(Query) ([]*system.Oid, error)
+	IndexFileDigest(bool, string, []byte, ...string) (Card, bool, error)
+	SumFile(filename string) ([]byte, error)
+	SetRehash(bool)
+	UpdateFile(oid *system.Oid) ([]PathUpdate, error)
+	StoreFile(oid *system.Oid, filename string, chunked bool) (bool, error)
+	Select(spec selectSpec, tags ...string) (ResultSet, error)
+	Search(Query) (ResultSet, error)
+	Explain(Query) (*QueryPlan, error)
 	DeleteObject(oid *system.Oid) (bool, error)
 	DeleteObjectsByTag(tags ...string) (int, error)
+	AddTags(oid *system.Oid, tag ...string) ([]string, error)
 	RemoveTags(oid *system.Oid, tag ...string) ([]string, error)
 
 	Rollback() error
@@ -166,11 +193,31 @@ type indexManager struct {
 	oidx    *oidxFile
 	tagmaps map[string]*Tagmap
 	cards   map[string]Card
+	tagdict *tagDictionary // loaded in Write mode
+	lock    *fs.FileLock   // repo lock - held until Close or Rollback
+
+	hashcache     *hashCache // loaded on first SumFile
+	hashcacheOnce sync.Once
+	rehash        bool
+
+	moveCandidates map[int64][]string // by size - loaded on first findMovedFile
 }
 
+// OpenIndexManager acquires the repo lock for the op mode (see lockRepo),
+// recovers the index from any interrupted session, and opens the index. The
+// lock is released on Close or Rollback.
 func OpenIndexManager(opMode OpMode) (IndexManager, error) {
+	lock, e := lockRepo(opMode)
+	if e != nil {
+		return nil, e
+	}
+	// recover from an interrupted session, if any (see journal.go)
+	if lock, e = recoverIndex(opMode, lock); e != nil {
+		return nil, e
+	}
 	oidx, e := openObjectIndex(opMode)
 	if e != nil {
+		lock.Unlock()
 		return nil, e
 	}
 
@@ -179,12 +226,26 @@ func OpenIndexManager(opMode OpMode) (IndexManager, error) {
 		oidx:    oidx,
 		tagmaps: make(map[string]*Tagmap),
 		cards:   make(map[string]Card),
+		lock:    lock,
+	}
+	if opMode == Write {
+		if idxmgr.tagdict, e = loadTagDictionary(); e != nil {
+			oidx.closeIndex(false)
+			lock.Unlock()
+			return nil, e
+		}
+		// objects added from now have month and year tags - see dateTagged
+		if idxmgr.tagdict.header.dated == 0 {
+			idxmgr.tagdict.header.dated = time.Now().UnixNano()
+			idxmgr.tagdict.modified = true
+		}
 	}
 
 	return idxmgr, nil
 }
 
-// REVU calling rollback now will leave object.idx in an inconsistent state
+// Rollback discards the session. Nothing is swapped until a commit's journal
+// is written, so only the records appended to object.idx need to be undone.
 func (idx *indexManager) Rollback() error {
 	var err = errors.For("indexManager.Rollback")
 	var debug = debug.For("indexManager.Rollback")
@@ -199,6 +260,8 @@ func (idx *indexManager) Rollback() error {
 	for key, _ := range idx.tagmaps {
 		delete(idx.tagmaps, key)
 	}
+	idx.tagdict = nil
+	defer idx.lock.Unlock()
 
 	if e := idx.oidx.closeIndex(false); e != nil {
 		return err.ErrorWithCause(e, "on Rollback")
@@ -234,43 +297,119 @@ func (idx *indexManager) Close(commit bool) error {
 		idx.oidx = nil
 		idx.tagmaps = nil
 		idx.cards = nil
+		idx.tagdict = nil
+		idx.hashcache = nil
+		idx.moveCandidates = nil
+		idx.lock.Unlock()
 	}()
 
-	// note: close must be called for object.idx file.
-	if e := idx.oidx.closeIndex(commit); e != nil {
-		return err.Bug("on oidx.closeIndex - opMode: %s - closed with e:%v", idx.opMode, e)
+	// note: read-only sessions have nothing to commit
+	if !commit || idx.opMode == Read {
+		// note: close must be called for object.idx file.
+		if e := idx.oidx.closeIndex(false); e != nil {
+			return err.Bug("on oidx.closeIndex - opMode: %s - closed with e:%v", idx.opMode, e)
+		}
+		return nil
 	}
 
-	if !commit {
+	// note: the hash cache is advisory and is not journaled.
+	if idx.hashcache != nil {
+		if e := idx.hashcache.save(); e != nil {
+			debug.Printf("hash cache not saved - %v", e)
+		}
+	}
+
+	// commit is journaled (see journal.go): save swapfiles of all modified
+	// files, commit the journal, and only then swap the files.
+	j, e := idx.commitJournal()
+	if e != nil {
+		idx.oidx.closeIndex(false)
+		return e
+	}
+	if j == nil {
+		debug.Printf("nothing to commit")
+		if e := idx.oidx.closeIndex(false); e != nil {
+			return err.Bug("on oidx.closeIndex - opMode: %s - closed with e:%v", idx.opMode, e)
+		}
 		return nil
 	}
 
+	/// committed - a failure below is recovered by the next session //////
+
+	if e := idx.oidx.closeIndex(true); e != nil {
+		return err.Bug("on oidx.closeIndex - opMode: %s - closed with e:%v", idx.opMode, e)
+	}
+	if e := j.apply(); e != nil {
+		return err.BugWithCause(e, "on journal apply")
+	}
+	if e := removeJournal(); e != nil {
+		return err.BugWithCause(e, "on journal remove")
+	}
+
+	return nil
+}
+
+// commitJournal saves the swapfiles of all files modified in the session and
+// commits the session journal (steps 1 and 2 of a commit - see journal.go).
+// The files are not swapped.
+//
+// Returns the committed journal, or nil if there is nothing to commit.
+func (idx *indexManager) commitJournal() (*journal, error) {
+	var err = errors.For("indexManager.commitJournal")
+	var debug = debug.For("indexManager.commitJournal")
+
+	var j = newJournal()
+
 	// note: cards are in-memory objects
-	// note: save is a nop if card is not modified
 	for oid, card := range idx.cards {
 		debug.Printf("saving wip card - %s", oid)
 		if ok, e := card.saveWip(); !ok || e != nil {
-			return err.Bug("card.saveWip returned oid:%s ok:%t e:%v", oid, ok, e)
+			return nil, err.Bug("card.saveWip returned oid:%s ok:%t e:%v", oid, ok, e)
 		}
-		if ok, e := card.save(); e != nil || !ok {
-			return err.Bug("on card[%s].save - ok:%t e:%v", oid, ok, e)
+		if e := j.addFile(cardFilename(card.Oid())); e != nil {
+			return nil, e
 		}
-		debug.Printf("saved card[%s]", oid)
 	}
 
 	// note: tagmaps are in-memory objects
-	// note: save is a nop if tagmap is not modified
 	for tag, tagmap := range idx.tagmaps {
 		// tagmap compresses on save so no need to compress it
-		if ok, e := tagmap.save(); e != nil {
-			return err.BugWithCause(e, "on tagmap(%s).Save", tag)
+		if ok, e := tagmap.saveWip(); e != nil {
+			return nil, err.BugWithCause(e, "on tagmap(%s).saveWip", tag)
 		} else if !ok {
-			return err.Bug("tagmap[%s].save returned false, nil", tag)
+			return nil, err.Bug("tagmap[%s].saveWip returned false, nil", tag)
+		}
+		if e := j.addFile(tagmap.source); e != nil {
+			return nil, e
 		}
-		debug.Printf("saved tagmap[%s]", tag)
 	}
 
-	return nil
+	// note: saveWip is a nop if tag dictionary is not modified
+	if idx.tagdict != nil {
+		if ok, e := idx.tagdict.saveWip(); e != nil {
+			return nil, err.BugWithCause(e, "on tagdict.saveWip")
+		} else if ok {
+			if e := j.addFile(idx.tagdict.source); e != nil {
+				return nil, e
+			}
+		}
+	}
+
+	if idx.oidx.modified {
+		if e := idx.oidx.sync(); e != nil {
+			return nil, err.ErrorWithCause(e, "on oidx.sync")
+		}
+		j.setObjectIndex(idx.oidx.header.pcnt, idx.oidx.header.ocnt)
+	}
+
+	if len(j.files) == 0 && !idx.oidx.modified {
+		return nil, nil
+	}
+	if e := j.commit(); e != nil {
+		return nil, err.ErrorWithCause(e, "on journal commit")
+	}
+	debug.Printf("committed journal - files:%d", len(j.files))
+	return j, nil
 }
 
 // Preloads the associated Tagmaps for the tags. This doesn't necessary mean
@@ -316,18 +455,71 @@ func (idx *indexManager) IndexText(strict bool, text string, tags ...string) (Ca
 	}
 
 	var isNew bool
-	var card Card
-	if cardExists(oid) {
-		if strict {
+	var card Card = idx.cards[oid.String()]
+	switch {
+	case cardExists(oid):
+		card, e = idx.loadCard(oid)
+		if e != nil {
+			return card, false, e
+		}
+		if strict && !card.IsDeleted() {
 			return nil, false, Error{system.Text, oid, ErrObjectExist}
 		}
-		card, e = LoadCard(oid)
+		if e := checkType(card, system.Text); e != nil {
+			return nil, false, e
+		}
+	case card != nil:
+		if e := checkType(card, system.Text); e != nil {
+			return nil, false, e
+		}
+	default:
+		card, e = NewTextCard(oid, text)
+		if e != nil {
+			return nil, true, err.BugWithCause(e, "unexpected")
+		}
+		isNew = true
+	}
+	return card, isNew, idx.updateIndex(card, isNew, tags...)
+}
+
+// Indexes the url object. The oid of the object is the digest of the normalized
+// url (see system.NormalizeURL). If object is new it is added with tags
+// specified. If not updated tags (if any) are added. See indexObject.
+//
+// Returns system.ErrInvalidURL if url is not an absolute url.
+func (idx *indexManager) IndexURL(strict bool, url string, tags ...string) (Card, bool, error) {
+	var err = errors.For("indexManager.IndexURL")
+
+	url, _, e := system.NormalizeURL(url)
+	if e != nil {
+		return nil, false, e
+	}
+	md := digest.Sum([]byte(url))
+	oid, e := system.NewOid(md[:])
+	if e != nil {
+		panic(err.BugWithCause(e, "unexpected"))
+	}
+
+	var isNew bool
+	var card Card = idx.cards[oid.String()]
+	switch {
+	case cardExists(oid):
+		card, e = idx.loadCard(oid)
 		if e != nil {
 			return card, false, e
 		}
-	} else {
-		var e error
-		card, e = NewTextCard(oid, text)
+		if strict && !card.IsDeleted() {
+			return nil, false, Error{system.URL, oid, ErrObjectExist}
+		}
+		if e := checkType(card, system.URL); e != nil {
+			return nil, false, e
+		}
+	case card != nil:
+		if e := checkType(card, system.URL); e != nil {
+			return nil, false, e
+		}
+	default:
+		card, e = NewURLCard(oid, url)
 		if e != nil {
 			return nil, true, err.BugWithCause(e, "unexpected")
 		}
@@ -336,6 +528,53 @@ func (idx *indexManager) IndexText(strict bool, text string, tags ...string) (Ca
 	return card, isNew, idx.updateIndex(card, isNew, tags...)
 }
 
+// Indexes the data object with the given oid (digest) and size of its content.
+// The content is not read: it is streamed by the caller, and, if stored is
+// true, the caller has put it in the object store (see store.PutReader). If
+// object is new it is added with the (optional) label and tags specified. If
+// not updated tags (if any) are added, the label is set if the object has
+// none, and the card is marked stored if stored is true.
+func (idx *indexManager) IndexData(strict bool, oid *system.Oid, size int64, label string, stored bool, tags ...string) (Card, bool, error) {
+	var err = errors.For("indexManager.IndexData")
+
+	if oid == nil {
+		return nil, false, err.InvalidArg("oid is nil")
+	}
+
+	var isNew bool
+	var card Card = idx.cards[oid.String()]
+	var e error
+	switch {
+	case cardExists(oid):
+		card, e = idx.loadCard(oid)
+		if e != nil {
+			return card, false, e
+		}
+		if strict && !card.IsDeleted() {
+			return nil, false, Error{system.Data, oid, ErrObjectExist}
+		}
+		if e := checkType(card, system.Data); e != nil {
+			return nil, false, e
+		}
+		card.(DataCard).setLabel(label)
+	case card != nil:
+		if e := checkType(card, system.Data); e != nil {
+			return nil, false, e
+		}
+		card.(DataCard).setLabel(label) // added in this session
+	default:
+		card, e = NewDataCard(oid, size, label)
+		if e != nil {
+			return nil, true, err.BugWithCause(e, "unexpected")
+		}
+		isNew = true
+	}
+	if stored {
+		card.markStored()
+	}
+	return card, isNew, idx.updateIndex(card, isNew, tags...)
+}
+
 // Indexes the file object. If object is new it is added with tags specified. If not
 // updated tags (if any), or the filename (if new) are added. See indexObject.
 func (idx *indexManager) IndexFile(strict bool, filename string, tags ...string) (Card, bool, error) {
@@ -346,7 +585,7 @@ func (idx *indexManager) IndexFile(strict bool, filename string, tags ...string)
 	if !filepath.IsAbs(filename) {
 		return nil, false, err.InvalidArg("filename must be absolute path")
 	}
-	md, e := digest.SumFile(filename)
+	md, e := idx.SumFile(filename)
 	if e != nil {
 		// REVU don't wrap the error as it is 99% os.ErrNotExist due to funky path issues.
 		//      Problem seems to be a Golang bug with embedded \n in file name. filepath
@@ -356,9 +595,35 @@ func (idx *indexManager) IndexFile(strict bool, filename string, tags ...string)
 		//      not stop a session because of this issue.
 		return nil, false, e
 	}
-	oid, e := system.NewOid(md[:])
+	return idx.IndexFileDigest(strict, filename, md, tags...)
+}
+
+// SumFile returns the digest of the content of the named file. Unless rehash
+// is set (see SetRehash), the digest of a file with unchanged stat data is
+// looked up in the hash cache (see hashcache.go). Safe for concurrent use.
+func (idx *indexManager) SumFile(filename string) ([]byte, error) {
+	idx.hashcacheOnce.Do(func() {
+		idx.hashcache = loadHashCache()
+	})
+	return idx.hashcache.sumFile(filename, idx.rehash)
+}
+
+// SetRehash sets the rehash flag of the session. If true, SumFile always
+// hashes the file content. The hash cache is updated in either case.
+func (idx *indexManager) SetRehash(rehash bool) { idx.rehash = rehash }
+
+// Indexes the file object with the given (pre-computed) digest of the file
+// content. Digests of files can be computed concurrently, but the index must
+// only be updated by a single goroutine. See IndexFile.
+func (idx *indexManager) IndexFileDigest(strict bool, filename string, md []byte, tags ...string) (Card, bool, error) {
+	var err = errors.For("indexManager.IndexFileDigest")
+
+	if !filepath.IsAbs(filename) {
+		return nil, false, err.InvalidArg("filename must be absolute path")
+	}
+	oid, e := system.NewOid(md)
 	if e != nil {
-		panic(err.BugWithCause(e, "unexpected"))
+		return nil, false, err.InvalidArg("digest - %v", e)
 	}
 
 	var isNew bool
@@ -373,19 +638,22 @@ func (idx *indexManager) IndexFile(strict bool, filename string, tags ...string)
 		//
 		//      And if we include that as well for strict filtering, then how to add paths
 		//      explicitly?
-		if strict {
-			return nil, false, Error{system.Text, oid, ErrObjectExist}
-		}
-		card, e = LoadCard(oid)
+		card, e = idx.loadCard(oid)
 		if e != nil {
 			return card, false, e
 		}
+		if strict && !card.IsDeleted() {
+			return nil, false, Error{system.File, oid, ErrObjectExist}
+		}
 		fallthrough
 		//		fileCard := card.(*fileCard)
 		//		if _, e := fileCard.addPath(filename); e != nil {
 		//			return card, false, e
 		//		}
 	case card != nil:
+		if e := checkType(card, system.File); e != nil {
+			return nil, false, e
+		}
 		fileCard := card.(*fileCard)
 		if _, e := fileCard.addPath(filename); e != nil {
 			return card, false, e
@@ -401,6 +669,249 @@ func (idx *indexManager) IndexFile(strict bool, filename string, tags ...string)
 	return card, isNew, idx.updateIndex(card, isNew, tags...)
 }
 
+// StoreFile copies the content of the file to the repo object store (see
+// store.Put), as deduplicated chunks if chunked is true (see store.PutChunked),
+// and marks the card of the file object identified by the oid as stored. The
+// card change is recorded in the session and saved on Close.
+//
+// Returns true, nil if the card was marked stored.
+// Returns false, nil if the object was already stored.
+// Returns false, error if object does not exist; is deleted; or the content
+// of the file does not match the oid (store.ErrDigestMismatch).
+func (idx *indexManager) StoreFile(oid *system.Oid, filename string, chunked bool) (bool, error) {
+	var err = errors.For("indexManager.StoreFile")
+	var debug = debug.For("indexManager.StoreFile")
+	debug.Printf("oid:%s filename:%q chunked:%t", oid.Fingerprint(), filename, chunked)
+
+	if idx.opMode != Write {
+		return false, err.Bug("invalid op mode: %s", idx.opMode)
+	}
+	card, e := idx.loadCard(oid)
+	if e != nil {
+		return false, e
+	}
+	if card.Type() != system.File {
+		return false, err.InvalidArg("not a file object - oid:%s", oid.Fingerprint())
+	}
+	if card.IsDeleted() {
+		return false, err.Error("card is deleted")
+	}
+	// note: an uncommitted put (i.e. on rollback) only leaves an unreferenced
+	//       object in the store.
+	var put = store.Put
+	if chunked {
+		put = store.PutChunked
+	}
+	if _, e := put(oid, filename); e != nil {
+		return false, e
+	}
+	if ok := card.markStored(); !ok {
+		return false, nil
+	}
+	idx.cards[oid.String()] = card // saved on indexManager.Close
+
+	return true, nil
+}
+
+// UpdateFile re-verifies the recorded paths of the file object identified by
+// the oid. Missing paths are removed from the card. If the content is found at
+// another path (see findMovedFile), the object is considered moved and the
+// new path is recorded. If the content at a path has changed, the path is
+// removed from the card, the new content is indexed as a new object with the
+// user tags of the object, and the object is linked to the new object (a link
+// per changed path, see FileCard#Next). Once a linked object has no paths, its
+// user tags are removed, i.e. it is superseded and is only selected by its
+// systemic tags.
+//
+// Returns the path updates, which may be empty, and nil on success.
+func (idx *indexManager) UpdateFile(oid *system.Oid) ([]PathUpdate, error) {
+	var err = errors.For("indexManager.UpdateFile")
+	var debug = debug.For("indexManager.UpdateFile")
+	debug.Printf("oid:%s", oid.Fingerprint())
+
+	if idx.opMode != Write {
+		return nil, err.Bug("invalid op mode: %s", idx.opMode)
+	}
+
+	card, e := idx.loadCard(oid)
+	if e != nil {
+		return nil, e
+	}
+	if card.Type() != system.File {
+		return nil, err.InvalidArg("%s is a %s object", oid.Fingerprint(), card.Type())
+	}
+	if card.IsDeleted() {
+		return nil, err.Error("card is deleted")
+	}
+	var fcard = card.(*fileCard)
+	var tags []string // user tags
+	for _, tag := range card.Tags() {
+		if !systemic.IsSystemic(tag) {
+			tags = append(tags, tag)
+		}
+	}
+
+	var updates = []PathUpdate{}
+	for _, path := range fcard.Paths() {
+		md, e := idx.SumFile(path)
+		switch {
+		case e != nil && os.IsNotExist(e):
+			var update = PathUpdate{Status: PathMissing, Path: path}
+			if newpath, ok := idx.findMovedFile(path, oid, fcard.Paths()); ok {
+				update.Status = PathMoved
+				update.NewPath = newpath
+				fcard.addPath(newpath)
+			}
+			fcard.removePath(path)
+			updates = append(updates, update)
+		case e != nil:
+			return nil, err.ErrorWithCause(e, "on SumFile(%q)", path)
+		case bytes.Equal(md, oid.Bytes()):
+			continue // unchanged
+		default:
+			fcard.removePath(path)
+			ncard, _, e := idx.IndexFile(false, path, tags...)
+			if e != nil {
+				return nil, err.ErrorWithCause(e, "on IndexFile(%q)", path)
+			}
+			if _, e := fcard.addNext(ncard.Oid()); e != nil {
+				return nil, err.ErrorWithCause(e, "path %q", path)
+			}
+			updates = append(updates, PathUpdate{Status: PathModified, Path: path, NewOid: ncard.Oid()})
+		}
+	}
+	if fcard.isModified() {
+		idx.cards[oid.String()] = card // saved on indexManager.Close
+	}
+
+	// superseded
+	if len(fcard.Next()) > 0 && len(fcard.Paths()) == 0 && len(tags) > 0 && !card.IsLocked() {
+		debug.Printf("superseded - remove tags %q", tags)
+		if _, e := idx.RemoveTags(oid, tags...); e != nil {
+			return nil, err.ErrorWithCause(e, "on RemoveTags of superseded object")
+		}
+	}
+
+	return updates, nil
+}
+
+// MaxMoveCandidates is the maximum number of sibling files considered by
+// UpdateFile when looking for a moved file of unknown size.
+const MaxMoveCandidates = 32
+
+// findMovedFile looks for the content of the object at a path other than the
+// (missing) path and the known paths of the object. If the size of the content
+// is known (i.e. the digest is in the hash cache), all files of that size in
+// the directories of the recorded paths of all file objects are considered
+// (see loadMoveCandidates). Otherwise, only the files with the same extension
+// in the directory of the path are considered, and the search is abandoned if
+// there are more than MaxMoveCandidates such files. Candidates are hashed via
+// the hash cache (see SumFile).
+//
+// Returns the path of the file with matching content, and true, if found.
+func (idx *indexManager) findMovedFile(path string, oid *system.Oid, known []string) (string, bool) {
+	var debug = debug.For("indexManager.findMovedFile")
+
+	var isKnown = func(candidate string) bool {
+		if candidate == path {
+			return true
+		}
+		for _, s := range known {
+			if s == candidate {
+				return true
+			}
+		}
+		return false
+	}
+	var candidates []string
+	idx.hashcacheOnce.Do(func() {
+		idx.hashcache = loadHashCache()
+	})
+	if size, ok := idx.hashcache.size(oid.Bytes()); ok {
+		if e := idx.loadMoveCandidates(); e != nil {
+			debug.Printf("candidates not loaded - %v", e)
+			return "", false
+		}
+		for _, candidate := range idx.moveCandidates[size] {
+			if !isKnown(candidate) {
+				candidates = append(candidates, candidate)
+			}
+		}
+	} else {
+		var dir = filepath.Dir(path)
+		var ext = filepath.Ext(path)
+		finfos, e := ioutil.ReadDir(dir)
+		if e != nil {
+			return "", false
+		}
+		for _, finfo := range finfos {
+			var name = finfo.Name()
+			if !finfo.Mode().IsRegular() || name[0] == '.' || filepath.Ext(name) != ext {
+				continue
+			}
+			if candidate := filepath.Join(dir, name); !isKnown(candidate) {
+				candidates = append(candidates, candidate)
+			}
+		}
+		if len(candidates) > MaxMoveCandidates {
+			return "", false
+		}
+	}
+	debug.Printf("path %q - %d candidates", path, len(candidates))
+
+	for _, candidate := range candidates {
+		md, e := idx.SumFile(candidate)
+		if e == nil && bytes.Equal(md, oid.Bytes()) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// loadMoveCandidates lists the visible regular files, by size, of the
+// directories of the recorded paths of all (non-deleted) file objects, once
+// per session.
+func (idx *indexManager) loadMoveCandidates() error {
+	if idx.moveCandidates != nil {
+		return nil
+	}
+	var dirs = make(map[string]struct{})
+	if e := walkCards(func(card Card) error {
+		if card.Type() != system.File || card.IsDeleted() {
+			return nil
+		}
+		for _, path := range card.(FileCard).Paths() {
+			dirs[filepath.Dir(path)] = struct{}{}
+		}
+		return nil
+	}); e != nil {
+		return e
+	}
+	for _, card := range idx.cards { // modified in session
+		if fcard, ok := card.(FileCard); ok {
+			for _, path := range fcard.Paths() {
+				dirs[filepath.Dir(path)] = struct{}{}
+			}
+		}
+	}
+
+	idx.moveCandidates = make(map[int64][]string)
+	for dir := range dirs {
+		finfos, e := ioutil.ReadDir(dir)
+		if e != nil {
+			continue // removed
+		}
+		for _, finfo := range finfos {
+			if !finfo.Mode().IsRegular() || finfo.Name()[0] == '.' {
+				continue
+			}
+			var size = finfo.Size()
+			idx.moveCandidates[size] = append(idx.moveCandidates[size], filepath.Join(dir, finfo.Name()))
+		}
+	}
+	return nil
+}
+
 func (idx *indexManager) updateIndex(card Card, isNew bool, tags ...string) error {
 	var err = errors.For("indexManager.updateIndex")
 	var debug = debug.For("indexManager.updateIndex")
@@ -411,7 +922,6 @@ func (idx *indexManager) updateIndex(card Card, isNew bool, tags ...string) erro
 	}
 
 	if isNew {
-		// TODO day-tag: MMM-dd-yyyy range-encoding
 		systemics, e := getObjectSystemics(card)
 		if e != nil {
 			return err.ErrorWithCause(e, "for new object")
@@ -427,7 +937,14 @@ func (idx *indexManager) updateIndex(card Card, isNew bool, tags ...string) erro
 		}
 	}
 
-	tags = card.addTag(tags...)
+	// re-added deleted object - restore all tags of card, including systemics
+	var restored []string
+	if !isNew && card.IsDeleted() {
+		card.markUndeleted()
+		restored = card.Tags()
+		debug.Printf("restore deleted object %s - tags:%q", oid.Fingerprint(), restored)
+	}
+	tags = append(restored, card.addTag(tags...)...)
 	if idx.cards[oid.String()] == nil && (card.IsNew() || card.isModified()) {
 		//		var oidfp = oid.Fingerprint()
 		//		debug.Printf("saving wip card - %s", oidfp)
@@ -440,6 +957,9 @@ func (idx *indexManager) updateIndex(card Card, isNew bool, tags ...string) erro
 
 	var key = card.Key()
 	for _, tag := range tags {
+		if e := idx.tagdict.add(tag); e != nil {
+			return err.ErrorWithCause(e, "on tagdict.add(%q)", tag)
+		}
 		debug.Printf("load tagmap %q", tag)
 		tagmap, e := idx.loadTagmap(tag, true, true)
 		if e != nil {
@@ -458,8 +978,10 @@ func (idx *indexManager) loadTagmap(tag string, create, add bool) (*Tagmap, erro
 	tagmap, ok := idx.tagmaps[tag]
 	if !ok {
 		var e error
-		tagmap, e = loadTagmap(tag, true)
-		if e != nil {
+		tagmap, e = loadTagmap(tag, create)
+		if e == ErrTagNotExist {
+			return nil, e
+		} else if e != nil {
 			return nil, err.Bug("on loadTagmap(%s) - %v", tag, e)
 		}
 		if add {
@@ -469,93 +991,37 @@ func (idx *indexManager) loadTagmap(tag string, create, add bool) (*Tagmap, erro
 	return tagmap, nil
 }
 
-// REVU should return TODO ResultSet<T>
-func (idx *indexManager) Search(qx Query) ([]*system.Oid, error) {
+// Search returns the ResultSet of all objects selected by the query. The query
+// is evaluated by the query planner (see planner.go).
+func (idx *indexManager) Search(qx Query) (ResultSet, error) {
 	var err = errors.For("indexManager.Search")
-	//	var debug = debug.For("indexManager.Search")
-
-	var q = qx.asQuery()
-
-	// load the all-inclusive systemic tagmap
-	allmap, e0 := loadTagmap(systemic.GartTag(), false)
-	if e0 != nil {
-		return nil, err.Bug("loadTagmap(systemic:gart-objects) - %v", e0)
-	}
-
-	var e error
 
-	// Exclude objects that have been tagged with -any- of the exluded tags
-	var exmap = bitmap.NewWahl() // tagmap of the final exclusion bitmap
-	if len(q.exclude) > 0 {
-		var excluded []*bitmap.Wahl
-		for tag, _ := range q.exclude {
-			tagmap, ok := idx.tagmaps[tag]
-			if !ok {
-				// if specified tag to exclude doesn't exist, just ignore it.
-				if tagmap, e = loadTagmap(tag, false); e != nil && e == ErrTagNotExist {
-					continue
-				}
-			}
-			excluded = append(excluded, tagmap.bitmap)
-		}
-		// create final exclusion bitmap
-		if exmap, e = bitmap.Or(excluded...); e != nil {
-			return nil, err.ErrorWithCause(e, "on exluded set OR")
-		}
-		if exmap, e = exmap.Xor(allmap.bitmap); e != nil {
-			return nil, err.ErrorWithCause(e, "on exluded set XOR")
-		}
+	resmap, e := newPlanner(idx, false).search(qx.asQuery())
+	if e != nil {
+		return nil, err.ErrorWithCause(e, "on planner.search")
 	}
+	return newResultSet(idx.oidx, resmap), nil
+}
 
-	// Include objects that have been tags with -all- of the include tags
-	var inmap = bitmap.NewWahl() // tagmap of the initial inclusion set
-	var included []*bitmap.Wahl
-	if len(q.include) > 0 {
-		for tag, _ := range q.include {
-			tagmap, ok := idx.tagmaps[tag]
-			if !ok {
-				// if specified tag to include doesn't exist, then return empty-set.
-				if tagmap, e = loadTagmap(tag, false); e != nil && e == ErrTagNotExist {
-					return []*system.Oid{}, nil
-				} else if e != nil {
-					return nil, err.Bug("loadTagmap(%s) - %v", tag, e)
-				}
-			}
-			included = append(included, tagmap.bitmap)
-		}
-		// create tentative inclusion bitmap
-		if inmap, e = bitmap.And(included...); e != nil {
-			return nil, err.ErrorWithCause(e, "on included set AND")
-		}
-	} else {
-		// If none were specified, treat it as everything.
-		// REVU shouldn't this be a decision made at the caller site?
-		inmap = allmap.bitmap
-	}
+// Explain evaluates the query and returns the plan of the evaluation with
+// the intermediate result sizes. The selected objects are not loaded.
+func (idx *indexManager) Explain(qx Query) (*QueryPlan, error) {
+	var err = errors.For("indexManager.Explain")
 
-	// filter exclusion list, if any.
-	if exmap.Len() > 0 {
-		if inmap, e = inmap.And(exmap); e != nil {
-			return nil, err.ErrorWithCause(e, "on final AND")
-		}
+	var planner = newPlanner(idx, true)
+	if _, e := planner.search(qx.asQuery()); e != nil {
+		return nil, err.ErrorWithCause(e, "on planner.search")
 	}
-
-	// REVU need a lower level that does above but only returns a bitmap
-	// and delegates (costly) Bits() func until it is absolutely necessary.
-	//      further, that would allow nested queries, etc. for a proper query processor.
-	// TODO
-	bits := inmap.Bits()
-	return idx.oidx.getOids([]int(bits)...)
+	return planner.plan, nil
 }
 
-// Select returns the Oids of all objects that have been tagged with all of the
-// provided tags. The returned array may be empty but never nil.
-// TODO update comments
+// Select returns the ResultSet of all objects that have been tagged with all
+// (All), any (Any), or none (None) of the provided tags.
 //
-// The indexManager must have been openned in Write op mode and len(tags) must be > 0.
+// len(tags) must be > 0.
 //
 // Return nil, error in case of any errors.
-func (idx *indexManager) Select(spec selectSpec, tags ...string) ([]*system.Oid, error) {
+func (idx *indexManager) Select(spec selectSpec, tags ...string) (ResultSet, error) {
 	var err = errors.For("indexManager.Select")
 
 	if e := spec.verify(); e != nil {
@@ -572,7 +1038,7 @@ func (idx *indexManager) Select(spec selectSpec, tags ...string) ([]*system.Oid,
 			tagmap, e = loadTagmap(tag, false) // do not create if tag is missing
 			if e != nil && e == ErrTagNotExist {
 				if spec == All { // we're done here for All
-					return []*system.Oid{}, nil
+					return newResultSet(idx.oidx, bitmap.NewWahl()), nil
 				}
 				continue
 			} else if e != nil {
@@ -582,69 +1048,45 @@ func (idx *indexManager) Select(spec selectSpec, tags ...string) ([]*system.Oid,
 		bitmaps = append(bitmaps, tagmap.bitmap)
 	}
 
-	var selectFn func([]*bitmap.Wahl) ([]int, error)
-	var queryFn func(...int) ([]*system.Oid, error)
+	var resmap *bitmap.Wahl
+	var e error
 	switch spec {
 	case All:
-		selectFn = idx.bitmapsAND
-		queryFn = idx.oidx.getOids
+		resmap, e = bitmap.And(bitmaps...)
 	case Any:
-		selectFn = idx.bitmapsOR
-		queryFn = idx.oidx.getOids
+		resmap, e = bitmap.Or(bitmaps...)
 	case None:
-		selectFn = idx.bitmapsOR
-		queryFn = idx.oidx.getOidsExcluding
-	}
-	keys, e := selectFn(bitmaps)
-	if e != nil {
-		return nil, e
-	}
-	if len(keys) == 0 {
-		return []*system.Oid{}, nil
+		var allmap *Tagmap
+		if allmap, e = idx.loadTagmap(systemic.GartTag(), false, false); e != nil {
+			return nil, err.Bug("loadTagmap(%s) - %v", systemic.GartTag(), e)
+		}
+		if resmap, e = bitmap.Or(bitmaps...); e == nil {
+			resmap, e = allmap.bitmap.AndNot(resmap)
+		}
 	}
-	oids, e := queryFn(keys...)
 	if e != nil {
-		return nil, e
+		return nil, err.ErrorWithCause(e, "on select %d", spec)
 	}
+	debug.Printf("query {select %d for tags %v} - selected:%d", spec, tags, resmap.Count())
 
-	// XXX debug only
-	debug.Printf("query {select %d for tags %v}", spec, tags)
-	debug.Printf("\tkeys (cnt:%d):", len(keys))
-	for _, key := range keys {
-		debug.Printf("\tkey: %d", key)
-	}
-	debug.Printf("\toids (cnt:%d):", len(oids))
-
-	for _, oid := range oids {
-		debug.Printf("\toid: %s", oid.Fingerprint())
-	}
-	// XXX debug only - END
-
-	return oids, nil
-}
-
-// Returns the logical AND of the following bitmaps.
-func (idx *indexManager) bitmapsAND(bitmaps []*bitmap.Wahl) ([]int, error) {
-	resmap, e := bitmap.And(bitmaps...)
-	return []int(resmap.Bits()), e
-}
-
-// Returns the logical OR of the following bitmaps.
-func (idx *indexManager) bitmapsOR(bitmaps []*bitmap.Wahl) ([]int, error) {
-	resmap, e := bitmap.And(bitmaps...)
-	return []int(resmap.Bits()), e
+	return newResultSet(idx.oidx, resmap), nil
 }
 
+// DeleteObject marks the card of the object identified by the oid as deleted
+// and clears the object's key bit in the tagmaps of all tags (including the
+// systemic tags) of the card. Card and tagmap changes are recorded in the
+// session and saved on Close.
+//
+// Returns true, nil if object was deleted.
+// Returns false, nil if object was already marked deleted.
+// Returns false, error if object does not exist or is locked.
 func (idx *indexManager) DeleteObject(oid *system.Oid) (bool, error) {
 	var err = errors.For("indexManager.DeleteObject")
 
 	if idx.opMode != Write {
 		return false, err.Error("invalid op mode: %s", idx.opMode)
 	}
-	if !cardExists(oid) {
-		return false, err.Error("does not exist - oid:%s", oid.Fingerprint())
-	}
-	card, e := LoadCard(oid)
+	card, e := idx.loadCard(oid)
 	if e != nil {
 		return false, e
 	}
@@ -658,31 +1100,52 @@ func (idx *indexManager) DeleteObject(oid *system.Oid) (bool, error) {
 		}
 		return false, nil
 	}
-	if ok, e := card.save(); e != nil {
-		return false, err.ErrorWithCause(e, "card.save: oid:%s", oid.Fingerprint())
-	} else if !ok {
-		return false, err.BugWithCause(e, "card.save: oid:%s", oid.Fingerprint())
-	}
+	idx.cards[oid.String()] = card // saved on indexManager.Close
 
-	// TODO clear the tagmaps of card ..
+	/// clear the tagmaps of card ///////////////////////////////////
+
+	var key = uint(card.Key())
+	for _, tag := range card.Tags() {
+		tagmap, e := idx.loadTagmap(tag, false, true)
+		if e == ErrTagNotExist {
+			continue // nothing to clear
+		} else if e != nil {
+			return false, err.ErrorWithCause(e, "on loadTagmap(%q)", tag)
+		}
+		tagmap.update(clearBits, key)
+	}
+	for _, tag := range card.Tags() {
+		if e := idx.tagdict.remove(tag); e != nil {
+			return false, err.ErrorWithCause(e, "on tagdict.remove(%q)", tag)
+		}
+	}
 
 	return true, nil
 }
 
+// DeleteObjectsByTag deletes all objects that have been tagged with all of
+// the provided tags. See DeleteObject.
+//
+// Returns the number of deleted objects, and nil on success.
 func (idx *indexManager) DeleteObjectsByTag(tags ...string) (int, error) {
 	var err = errors.For("indexManager.DeleteObjectByTag")
 
 	if idx.opMode != Write {
 		return 0, err.Bug("invalid op mode: %s", idx.opMode)
 	}
-	oids, e := idx.Select(All, tags...)
+	rs, e := idx.Select(All, tags...)
 	if e != nil {
 		return 0, err.ErrorWithCause(e, "on select(All, ...)")
 	}
 	// none selected
-	if len(oids) == 0 {
+	if rs.Count() == 0 {
 		return 0, nil
 	}
+	// note: resolve all before deleting, as delete modifies the tagmaps.
+	oids, e := rs.Page(0, rs.Count())
+	if e != nil {
+		return 0, err.ErrorWithCause(e, "on ResultSet.Page")
+	}
 	// delete selected
 	var n int
 	for _, oid := range oids {
@@ -695,7 +1158,53 @@ func (idx *indexManager) DeleteObjectsByTag(tags ...string) (int, error) {
 	return n, nil
 }
 
+// AddTags applies the specified tags to the object identified by the oid.
+// Card and tagmap changes are recorded in the session and saved on Close.
+//
+// Returns []string, nil if successful. The array is set of newly applied tags.
+// Returns []string{}, nil if object was already tagged with all specified tags.
+// Returns nil, error if object does not exist; is locked; or is marked deleted.
+func (idx *indexManager) AddTags(oid *system.Oid, tags ...string) ([]string, error) {
+	var err = errors.For("indexManager.AddTags")
+
+	if idx.opMode != Write {
+		return nil, err.Bug("invalid op mode: %s", idx.opMode)
+	}
+
+	card, e := idx.loadCard(oid)
+	if e != nil {
+		return nil, e
+	}
+	if card.IsDeleted() {
+		return nil, err.Error("card is deleted")
+	}
+	if card.IsLocked() {
+		return nil, err.Error("card is locked")
+	}
+
+	var tagged = make(map[string]struct{})
+	for _, tag := range card.Tags() {
+		tagged[tag] = struct{}{}
+	}
+	var updates = []string{}
+	for _, tag := range tags {
+		if _, ok := tagged[tag]; !ok {
+			tagged[tag] = struct{}{}
+			updates = append(updates, tag)
+		}
+	}
+	if len(updates) == 0 {
+		return updates, nil // exit early - no tagmaps to update
+	}
+
+	if e := idx.updateIndex(card, false, updates...); e != nil {
+		return nil, e
+	}
+	return updates, nil
+}
+
 // RemoveTag removes the specified tags from the object identified by the oid.
+// Card and tagmap changes are recorded in the session and saved on Close.
 //
 // Returns []string, nil if successful. The array is set of removed tags.
 // Returns []string{}, nil if object was not tagged with any of the specified tag.
@@ -706,11 +1215,8 @@ func (idx *indexManager) RemoveTags(oid *system.Oid, tags ...string) ([]string,
 	if idx.opMode != Write {
 		return nil, err.Bug("invalid op mode: %s", idx.opMode)
 	}
-	if !cardExists(oid) {
-		return nil, err.Error("does not exist - oid:%s", oid.Fingerprint())
-	}
 
-	card, e := LoadCard(oid)
+	card, e := idx.loadCard(oid)
 	if e != nil {
 		return nil, e
 	}
@@ -727,16 +1233,11 @@ func (idx *indexManager) RemoveTags(oid *system.Oid, tags ...string) ([]string,
 	if len(updates) == 0 {
 		return updates, nil // exit early - no tagmaps to update
 	}
-
-	if ok, e := card.save(); e != nil {
-		return nil, err.ErrorWithCause(e, "card.save: oid:%s", oid.Fingerprint())
-	} else if !ok {
-		return nil, err.BugWithCause(e, "card.save: oid:%s", oid.Fingerprint())
-	}
+	idx.cards[oid.String()] = card // saved on indexManager.Close
 
 	/// update the tagmap ///////////////////////////////////////////
 
-	for _, tag := range tags {
+	for _, tag := range updates {
 		tagmap, e := idx.loadTagmap(tag, false, true)
 		if e != nil {
 			panic(err.BugWithCause(e, "idx.loadTamp (%q)", tag))
@@ -745,11 +1246,72 @@ func (idx *indexManager) RemoveTags(oid *system.Oid, tags ...string) ([]string,
 		if !ok {
 			panic(err.Bug("tagmap(%s) update returned false (key:%d)", tag, card.Key()))
 		}
+		if e := idx.tagdict.remove(tag); e != nil {
+			return nil, err.ErrorWithCause(e, "on tagdict.remove(%q)", tag)
+		}
 	}
 
 	return updates, nil
 }
 
+// checkType returns an Error (ErrObjectType) if the existing card is not of
+// the given type. Objects of distinct types may have the same oid, e.g. a
+// text object and a url object with the same (normalized) content.
+func checkType(card Card, otype system.Otype) error {
+	if card.Type() != otype {
+		return Error{otype, card.Oid(), ErrObjectType}
+	}
+	return nil
+}
+
+// loadCard returns the card for the oid. Cards modified in this session are
+// returned from the in-mem cards map. Otherwise, the card is loaded from file.
+//
+// Returns nil, error if object does not exist.
+func (idx *indexManager) loadCard(oid *system.Oid) (Card, error) {
+	var err = errors.For("indexManager.loadCard")
+
+	if card, ok := idx.cards[oid.String()]; ok {
+		return card, nil
+	}
+	if !cardExists(oid) {
+		return nil, err.Error("does not exist - oid:%s", oid.Fingerprint())
+	}
+	return LoadCard(oid)
+}
+
+/// path updates //////////////////////////////////////////////////////////////
+
+// PathStatus is the status of a recorded path of a file object after update.
+type PathStatus byte
+
+const (
+	_ PathStatus = iota
+	PathMoved
+	PathMissing
+	PathModified
+)
+
+func (v PathStatus) String() string {
+	switch v {
+	case PathMoved:
+		return "moved"
+	case PathMissing:
+		return "missing"
+	case PathModified:
+		return "modified"
+	}
+	panic(errors.Bug("PathStatus.String: invalid path status: %d", v))
+}
+
+// PathUpdate describes a change to a recorded path of a file object.
+type PathUpdate struct {
+	Status  PathStatus
+	Path    string      // the recorded path
+	NewPath string      // moved only - the new path of the object
+	NewOid  *system.Oid // modified only - the oid of the content at path
+}
+
 /// selectSpec /////////////////////////////////////////////////////////////////
 
 type selectSpec byte
@@ -788,9 +1350,9 @@ func getObjectSystemics(card Card) ([]string, error) {
 
 	var systemics = []string{
 		systemic.GartTag(), // used for all inclusive mapping
-		systemic.TodayTag(),
 		systemic.TypeTag(card.Type().String()),
 	}
+	systemics = append(systemics, systemic.DateTags(time.Now())...) // day, month, year
 
 	// File extension
 	// Note: it is possible that a user may choose to define a tag that collides
@@ -818,6 +1380,16 @@ func getObjectSystemics(card Card) ([]string, error) {
 		systemics = append(systemics, systemic.ExtTag(ext))
 	}
 
+	// URL scheme and host
+	// ex: "scheme:https", "host:golang.org"
+	if card.Type() == system.URL {
+		_, u, e := system.NormalizeURL(card.(URLCard).URL())
+		if e != nil {
+			return nil, err.ErrorWithCause(e, "using card.url")
+		}
+		systemics = append(systemics, systemic.SchemeTag(u.Scheme), systemic.HostTag(u.Hostname()))
+	}
+
 	return systemics, nil
 }
 
@@ -0,0 +1,451 @@
+// Doost!
+
+package index
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alphazero/gart/repo"
+	"github.com/alphazero/gart/system"
+	"github.com/alphazero/gart/system/systemic"
+)
+
+/// test support ///////////////////////////////////////////////////////////////
+
+// testRepo initializes a repo in a temp directory and relocates the repo paths
+// to it. The returned func restores the repo paths and removes the directory.
+func testRepo(t *testing.T) func() {
+	dir, e := ioutil.TempDir("", "gart-index")
+	if e != nil {
+		t.Fatalf("%v", e)
+	}
+	var root = filepath.Dir(repo.RepoPath)
+	system.SetRepoRoot(dir)
+	var done = func() {
+		system.SetRepoRoot(root)
+		os.RemoveAll(dir)
+	}
+	if e := repo.Initialize(false); e != nil {
+		done()
+		t.Fatalf("repo.Initialize - %v", e)
+	}
+	if e := Initialize(false); e != nil {
+		done()
+		t.Fatalf("index.Initialize - %v", e)
+	}
+	return done
+}
+
+// testObject is a text object and its tags.
+type testObject struct {
+	text string
+	tags []string
+}
+
+// indexTexts adds the text objects in a (committed) session and returns their
+// oids. Object keys are assigned in order of objects.
+func indexTexts(t *testing.T, objects ...testObject) []*system.Oid {
+	idx, e := OpenIndexManager(Write)
+	if e != nil {
+		t.Fatalf("OpenIndexManager - %v", e)
+	}
+	var oids = make([]*system.Oid, len(objects))
+	for i, obj := range objects {
+		card, _, e := idx.IndexText(false, obj.text, obj.tags...)
+		if e != nil {
+			idx.Rollback()
+			t.Fatalf("IndexText(%q) - %v", obj.text, e)
+		}
+		oids[i] = card.Oid()
+	}
+	if e := idx.Close(true); e != nil {
+		t.Fatalf("Close - %v", e)
+	}
+	return oids
+}
+
+// searchKeys returns the keys of the objects selected by the query.
+func searchKeys(t *testing.T, q Query) []int {
+	idx, e := OpenIndexManager(Read)
+	if e != nil {
+		t.Fatalf("OpenIndexManager - %v", e)
+	}
+	defer idx.Close(false)
+
+	rs, e := idx.Search(q)
+	if e != nil {
+		t.Fatalf("Search - %v", e)
+	}
+	return []int(rs.(*resultSet).bitmap.Bits())
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// writeSession applies fn in a write session, which is committed if fn returns
+// nil, and rolled back otherwise.
+func writeSession(t *testing.T, fn func(idx IndexManager) error) {
+	idx, e := OpenIndexManager(Write)
+	if e != nil {
+		t.Fatalf("OpenIndexManager - %v", e)
+	}
+	if e := fn(idx); e != nil {
+		idx.Rollback()
+		t.Fatalf("%v", e)
+	}
+	if e := idx.Close(true); e != nil {
+		t.Fatalf("Close - %v", e)
+	}
+}
+
+// expectKeys checks the keys of the objects tagged with each of the tags.
+func expectKeys(t *testing.T, expect map[string][]int) {
+	for tag, keys := range expect {
+		if have := searchKeys(t, NewQuery().IncludeTags(tag).Build()); !equalInts(have, keys) {
+			t.Fatalf("keys of %q have:%v - expect:%v", tag, have, keys)
+		}
+	}
+}
+
+/// tests //////////////////////////////////////////////////////////////////////
+
+func TestDeleteObject(t *testing.T) {
+	defer testRepo(t)()
+
+	oids := indexTexts(t,
+		testObject{"x", []string{"a", "b"}},
+		testObject{"y", []string{"a"}},
+		testObject{"z", []string{"c"}},
+	)
+	var textTag = systemic.TypeTag(system.Text.String())
+	writeSession(t, func(idx IndexManager) error {
+		for i, expect := range []bool{true, false} { // deleted objects are not deleted again
+			if ok, e := idx.DeleteObject(oids[0]); e != nil || ok != expect {
+				return fmt.Errorf("DeleteObject(%d) - have:%t %v - expect:%t", i, ok, e, expect)
+			}
+		}
+		if _, e := idx.AddTags(oids[0], "d"); e == nil {
+			return fmt.Errorf("AddTags - deleted object - expected error")
+		}
+		if n, e := idx.DeleteObjectsByTag("a"); e != nil || n != 1 {
+			return fmt.Errorf("DeleteObjectsByTag - have:%d %v - expect:1", n, e)
+		}
+		return nil
+	})
+
+	// the keys of deleted objects are cleared in all tagmaps, including systemics
+	expectKeys(t, map[string][]int{
+		"a": nil, "b": nil, "c": {2},
+		systemic.GartTag(): {2}, textTag: {2},
+	})
+	for _, oid := range oids[:2] {
+		card, e := LoadCard(oid)
+		if e != nil {
+			t.Fatalf("LoadCard - %v", e)
+		}
+		if !card.IsDeleted() {
+			t.Fatalf("card %s not deleted", oid.Fingerprint())
+		}
+	}
+	expectRefcnts(t, map[string]int{"a": 0, "b": 0, "c": 1})
+	fsckClean(t)
+
+	// re-added deleted objects are restored with all tags
+	writeSession(t, func(idx IndexManager) error {
+		card, added, e := idx.IndexText(false, "x", "d")
+		if e != nil || added {
+			return fmt.Errorf("IndexText - have:%t %v", added, e)
+		}
+		var tags []string
+		for _, tag := range card.Tags() {
+			if !systemic.IsSystemic(tag) {
+				tags = append(tags, tag)
+			}
+		}
+		if fmt.Sprint(tags) != "[a b d]" {
+			return fmt.Errorf("tags have:%q - expect:[a b d]", tags)
+		}
+		return nil
+	})
+	expectKeys(t, map[string][]int{
+		"a": {0}, "b": {0}, "c": {2}, "d": {0},
+		systemic.GartTag(): {0, 2}, textTag: {0, 2},
+	})
+	if card, e := LoadCard(oids[0]); e != nil || card.IsDeleted() {
+		t.Fatalf("LoadCard - restored card - e:%v", e)
+	}
+	expectRefcnts(t, map[string]int{"a": 1, "b": 1, "c": 1, "d": 1})
+	fsckClean(t)
+}
+
+// updateFile adds the file in a session, applies change to the file system,
+// and returns the path updates of the file object.
+func updateFile(t *testing.T, filename string, change func()) []PathUpdate {
+	idx, e := OpenIndexManager(Write)
+	if e != nil {
+		t.Fatalf("OpenIndexManager - %v", e)
+	}
+	card, _, e := idx.IndexFile(false, filename)
+	if e != nil {
+		idx.Rollback()
+		t.Fatalf("IndexFile(%q) - %v", filename, e)
+	}
+	if e := idx.Close(true); e != nil {
+		t.Fatalf("Close - %v", e)
+	}
+
+	change()
+
+	if idx, e = OpenIndexManager(Write); e != nil {
+		t.Fatalf("OpenIndexManager - %v", e)
+	}
+	updates, e := idx.UpdateFile(card.Oid())
+	if e != nil {
+		idx.Rollback()
+		t.Fatalf("UpdateFile - %v", e)
+	}
+	if e := idx.Close(true); e != nil {
+		t.Fatalf("Close - %v", e)
+	}
+	return updates
+}
+
+func TestUpdateFile(t *testing.T) {
+	defer testRepo(t)()
+	var dir = filepath.Dir(repo.RepoPath)
+	var write = func(name, content string) string {
+		var filename = filepath.Join(dir, name)
+		if e := ioutil.WriteFile(filename, []byte(content), repo.FilePerm); e != nil {
+			t.Fatalf("%v", e)
+		}
+		return filename
+	}
+	var rename = func(from, to string) func() {
+		return func() {
+			if e := os.Rename(from, to); e != nil {
+				t.Fatalf("%v", e)
+			}
+		}
+	}
+
+	// moved in directory
+	var from, to = write("a.txt", "a"), filepath.Join(dir, "b.txt")
+	updates := updateFile(t, from, rename(from, to))
+	if len(updates) != 1 || updates[0].Status != PathMoved || updates[0].NewPath != to {
+		t.Fatalf("updates have:%+v - expect moved to %q", updates, to)
+	}
+
+	// moved with a change of extension
+	from, to = write("c.txt", "c"), filepath.Join(dir, "c.md")
+	updates = updateFile(t, from, rename(from, to))
+	if len(updates) != 1 || updates[0].Status != PathMissing {
+		t.Fatalf("updates have:%+v - expect missing", updates)
+	}
+
+	// changed
+	from = write("d.txt", "d")
+	updates = updateFile(t, from, func() { write("d.txt", "changed") })
+	if len(updates) != 1 || updates[0].Status != PathModified || updates[0].NewOid == nil {
+		t.Fatalf("updates have:%+v - expect modified", updates)
+	}
+
+	// moved in a directory with too many candidates
+	for i := 0; i < MaxMoveCandidates; i++ {
+		write(fmt.Sprintf("x%02d.txt", i), fmt.Sprintf("x%d", i))
+	}
+	from, to = write("e.txt", "e"), filepath.Join(dir, "f.txt")
+	updates = updateFile(t, from, rename(from, to))
+	if len(updates) != 1 || updates[0].Status != PathMissing {
+		t.Fatalf("updates have:%+v - expect missing", updates)
+	}
+}
+
+func TestUpdateFileSuperseded(t *testing.T) {
+	defer testRepo(t)()
+	var dir = filepath.Dir(repo.RepoPath)
+	var paths = []string{filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")}
+	for _, path := range paths {
+		writeTestFile(t, path, "content", false)
+	}
+
+	// an object with 2 paths - the content at both paths is changed
+	idx, e := OpenIndexManager(Write)
+	if e != nil {
+		t.Fatalf("OpenIndexManager - %v", e)
+	}
+	var oid *system.Oid
+	for _, path := range paths {
+		card, _, e := idx.IndexFile(false, path, "t")
+		if e != nil {
+			idx.Rollback()
+			t.Fatalf("IndexFile(%q) - %v", path, e)
+		}
+		oid = card.Oid()
+	}
+	if e := idx.Close(true); e != nil {
+		t.Fatalf("Close - %v", e)
+	}
+	for i, path := range paths {
+		writeTestFile(t, path, fmt.Sprintf("changed %d", i), false)
+	}
+
+	if idx, e = OpenIndexManager(Write); e != nil {
+		t.Fatalf("OpenIndexManager - %v", e)
+	}
+	updates, e := idx.UpdateFile(oid)
+	if e != nil {
+		idx.Rollback()
+		t.Fatalf("UpdateFile - %v", e)
+	}
+	if e := idx.Close(true); e != nil {
+		t.Fatalf("Close - %v", e)
+	}
+	if len(updates) != 2 || updates[0].Status != PathModified || updates[1].Status != PathModified {
+		t.Fatalf("updates have:%+v - expect 2 modified", updates)
+	}
+
+	// linked to both new objects
+	card, e := LoadCard(oid)
+	if e != nil {
+		t.Fatalf("LoadCard - %v", e)
+	}
+	var next = card.(FileCard).Next()
+	if len(next) != 2 {
+		t.Fatalf("next have:%v - expect 2 oids", next)
+	}
+	for i, update := range updates {
+		if next[i].String() != update.NewOid.String() {
+			t.Fatalf("next[%d] have:%s - expect:%s", i, next[i], update.NewOid)
+		}
+	}
+
+	// the superseded object is only selected by its systemic tags
+	for _, tag := range card.Tags() {
+		if !systemic.IsSystemic(tag) {
+			t.Fatalf("superseded object tagged %q", tag)
+		}
+	}
+	if keys := searchKeys(t, NewQuery().IncludeTags("t").Build()); !equalInts(keys, []int{1, 2}) {
+		t.Fatalf("keys of t have:%v - expect:[1 2]", keys)
+	}
+	if keys := searchKeys(t, NewQuery().IncludeTags(systemic.GartTag()).Build()); !equalInts(keys, []int{0, 1, 2}) {
+		t.Fatalf("keys of gart-object have:%v - expect:[0 1 2]", keys)
+	}
+	fsckClean(t)
+}
+
+func TestUpdateFileMovedDir(t *testing.T) {
+	defer testRepo(t)()
+	var dir = filepath.Dir(repo.RepoPath)
+	var sub = filepath.Join(dir, "sub")
+	if e := os.Mkdir(sub, repo.DirPerm); e != nil {
+		t.Fatalf("%v", e)
+	}
+
+	// sub is the directory of a recorded path
+	var other = filepath.Join(sub, "other.txt")
+	writeTestFile(t, other, "other", false)
+	idx, e := OpenIndexManager(Write)
+	if e != nil {
+		t.Fatalf("OpenIndexManager - %v", e)
+	}
+	if _, _, e := idx.IndexFile(false, other); e != nil {
+		idx.Rollback()
+		t.Fatalf("IndexFile(%q) - %v", other, e)
+	}
+	if e := idx.Close(true); e != nil {
+		t.Fatalf("Close - %v", e)
+	}
+
+	// moved to another directory with a change of extension - the content is
+	// in the hash cache
+	var from, to = filepath.Join(dir, "a.txt"), filepath.Join(sub, "a.md")
+	writeTestFile(t, from, "a", true)
+	writeTestFile(t, filepath.Join(sub, "b.md"), "b", true) // same size
+	updates := updateFile(t, from, func() {
+		if e := os.Rename(from, to); e != nil {
+			t.Fatalf("%v", e)
+		}
+	})
+	if len(updates) != 1 || updates[0].Status != PathMoved || updates[0].NewPath != to {
+		t.Fatalf("updates have:%+v - expect moved to %q", updates, to)
+	}
+
+	// moved to a directory that is not the directory of a recorded path
+	elsewhere, e := ioutil.TempDir("", "gart-index")
+	if e != nil {
+		t.Fatalf("%v", e)
+	}
+	defer os.RemoveAll(elsewhere)
+	from, to = filepath.Join(dir, "c.txt"), filepath.Join(elsewhere, "c.txt")
+	writeTestFile(t, from, "c", true)
+	updates = updateFile(t, from, func() {
+		if e := os.Rename(from, to); e != nil {
+			t.Fatalf("%v", e)
+		}
+	})
+	if len(updates) != 1 || updates[0].Status != PathMissing {
+		t.Fatalf("updates have:%+v - expect missing", updates)
+	}
+}
+
+func TestUpdateTags(t *testing.T) {
+	defer testRepo(t)()
+
+	oids := indexTexts(t,
+		testObject{"x", []string{"a", "b"}},
+		testObject{"y", []string{"a"}},
+	)
+	type step struct {
+		add    bool
+		key    int
+		tags   []string
+		expect []string
+	}
+	writeSession(t, func(idx IndexManager) error {
+		for i, step := range []step{
+			{true, 0, []string{"c", "a"}, []string{"c"}},
+			{false, 0, []string{"b", "undefined"}, []string{"b"}},
+			{false, 1, []string{"b"}, []string{}}, // not tagged
+			{true, 0, []string{"b"}, []string{"b"}},
+			{false, 0, []string{"c"}, []string{"c"}},
+			{true, 1, []string{"d"}, []string{"d"}},
+		} {
+			var have []string
+			var e error
+			if step.add {
+				have, e = idx.AddTags(oids[step.key], step.tags...)
+			} else {
+				have, e = idx.RemoveTags(oids[step.key], step.tags...)
+			}
+			if e != nil {
+				return fmt.Errorf("step %d - %v", i, e)
+			}
+			if fmt.Sprint(have) != fmt.Sprint(step.expect) {
+				return fmt.Errorf("step %d - have:%q - expect:%q", i, have, step.expect)
+			}
+		}
+		if _, e := idx.AddTags(testOid(t, "undefined"), "a"); e == nil {
+			return fmt.Errorf("AddTags - undefined object - expected error")
+		}
+		return nil
+	})
+	expectKeys(t, map[string][]int{
+		"a": {0, 1}, "b": {0}, "c": nil, "d": {1},
+	})
+	expectRefcnts(t, map[string]int{"a": 2, "b": 1, "c": 0, "d": 1})
+	fsckClean(t)
+}
@@ -0,0 +1,422 @@
+// Doost!
+
+package index
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/alphazero/gart/repo"
+	"github.com/alphazero/gart/syslib/debug"
+	"github.com/alphazero/gart/syslib/digest"
+	"github.com/alphazero/gart/syslib/errors"
+	"github.com/alphazero/gart/syslib/fs"
+)
+
+// .gart/journal is the (write-ahead) journal of an index session commit. A
+// session modifies objects.idx (appended records and header), cards, tagmaps,
+// and the tag dictionary. On commit (indexManager.Close(true)):
+//
+//	1 - modified cards, tagmaps, and tag dictionary are saved to swapfiles,
+//	    and appended objects.idx records are synced.
+//	2 - the journal, recording the committed objects.idx header and the files
+//	    to swap, is written (via its own swapfile) and synced. This is the
+//	    commit point of the session.
+//	3 - the objects.idx header is updated, the swapfiles are swapped, and
+//	    any files to remove (e.g. on compaction) are removed.
+//	4 - the journal is removed.
+//
+// OpenIndexManager recovers from an interrupted session. If a journal exists
+// the commit is redone (step 3 is idempotent) and the journal removed. In
+// either case objects.idx is truncated to its committed header, removing the
+// records appended by the interrupted session (undo). Stale swapfiles of an
+// uncommitted session are simply replaced by the next session that saves
+// the same files.
+
+/// consts and vars ///////////////////////////////////////////////////////////
+
+const (
+	mmap_journal_ftype uint64 = 0x5e0c27a9b4d1f683
+)
+
+const (
+	journalHeaderSize = 48
+	journalRecHdrSize = 3 // op, path-len
+)
+
+/// journal file header ///////////////////////////////////////////////////////
+
+// The crc64 field is the checksum of the header and all file records.
+type journalHeader struct {
+	ftype   uint64
+	crc64   uint64 // header and records crc
+	created int64
+	pcnt    uint64 // committed objects.idx page count
+	ocnt    int64  // committed objects.idx object count - -1 if not modified
+	fcnt    int64  // number of file records
+}
+
+func (h *journalHeader) Print(w io.Writer) {
+	fmt.Fprintf(w, "file type:  %016x\n", h.ftype)
+	fmt.Fprintf(w, "crc64:      %016x\n", h.crc64)
+	fmt.Fprintf(w, "created:    %016x (%s)\n", h.created, time.Unix(0, h.created))
+	fmt.Fprintf(w, "page cnt:   %d\n", h.pcnt)
+	fmt.Fprintf(w, "object cnt: %d\n", h.ocnt)
+	fmt.Fprintf(w, "file cnt:   %d\n", h.fcnt)
+}
+
+// NOTE encode assumes that the buffer is the full file and that the file
+// records are already encoded.
+func (h *journalHeader) encode(buf []byte) error {
+	if len(buf) < journalHeaderSize {
+		return errors.Error("journalHeader.encode: insufficient buffer length: %d", len(buf))
+	}
+	*(*uint64)(unsafe.Pointer(&buf[0])) = h.ftype
+	*(*int64)(unsafe.Pointer(&buf[16])) = h.created
+	*(*uint64)(unsafe.Pointer(&buf[24])) = h.pcnt
+	*(*int64)(unsafe.Pointer(&buf[32])) = h.ocnt
+	*(*int64)(unsafe.Pointer(&buf[40])) = h.fcnt
+
+	h.crc64 = digest.Checksum64(buf[16:])
+	*(*uint64)(unsafe.Pointer(&buf[8])) = h.crc64
+
+	return nil
+}
+
+func (h *journalHeader) decode(buf []byte) error {
+	var err = errors.For("journalHeader.decode")
+
+	if len(buf) < journalHeaderSize {
+		return err.Error("insufficient buffer length: %d", len(buf))
+	}
+	*h = *(*journalHeader)(unsafe.Pointer(&buf[0]))
+
+	/// verify //////////////////////////////////////////////////////
+
+	if h.ftype != mmap_journal_ftype {
+		return err.Bug("invalid ftype: %x - expect: %x", h.ftype, mmap_journal_ftype)
+	}
+	crc64 := digest.Checksum64(buf[16:])
+	if crc64 != h.crc64 {
+		return err.Bug("invalid checksum: %x - expect: %x", h.crc64, crc64)
+	}
+	if h.created == 0 {
+		return err.Bug("invalid created: %d", h.created)
+	}
+	if h.fcnt < 0 {
+		return err.Bug("invalid fcnt: %d", h.fcnt)
+	}
+
+	return nil
+}
+
+/// journal ///////////////////////////////////////////////////////////////////
+
+// journal file ops
+const (
+	swapFile byte = iota + 1
+	removeFile
+)
+
+type journalFile struct {
+	op   byte
+	path string // repo relative path
+}
+
+type journal struct {
+	header *journalHeader
+	files  []journalFile
+}
+
+func newJournal() *journal {
+	return &journal{
+		header: &journalHeader{
+			ftype:   mmap_journal_ftype,
+			created: time.Now().UnixNano(),
+			ocnt:    -1,
+		},
+	}
+}
+
+// addFile adds the file to the swap list of the journal. The file's swapfile
+// (see fs.SwapfileName) must have been saved.
+func (j *journal) addFile(filename string) error {
+	return j.add(swapFile, filename)
+}
+
+// removeFile adds the file to the remove list of the journal.
+func (j *journal) removeFile(filename string) error {
+	return j.add(removeFile, filename)
+}
+
+func (j *journal) add(op byte, filename string) error {
+	var err = errors.For("journal.add")
+
+	path, e := filepath.Rel(repo.RepoPath, filename)
+	if e != nil || strings.HasPrefix(path, "..") {
+		return err.Bug("file %q is not in repo", filename)
+	}
+	if len(path) > 0xffff {
+		return err.Bug("file %q path is too long", filename)
+	}
+	j.files = append(j.files, journalFile{op, path})
+	j.header.fcnt++
+	return nil
+}
+
+// setObjectIndex records the committed objects.idx page and object counts.
+func (j *journal) setObjectIndex(pcnt uint64, ocnt int64) {
+	j.header.pcnt = pcnt
+	j.header.ocnt = ocnt
+}
+
+// commit syncs the swapfiles and writes the journal. Once commit returns
+// the session is committed.
+func (j *journal) commit() error {
+	var err = errors.For("journal.commit")
+
+	for _, file := range j.files {
+		if file.op != swapFile {
+			continue
+		}
+		var swapfile = fs.SwapfileName(filepath.Join(repo.RepoPath, file.path))
+		if e := syncFile(swapfile); e != nil {
+			return err.ErrorWithCause(e, "swapfile %q", swapfile)
+		}
+	}
+
+	var size = journalHeaderSize
+	for _, file := range j.files {
+		size += journalRecHdrSize + len(file.path)
+	}
+	var buf = make([]byte, size)
+	var xof = journalHeaderSize
+	for _, file := range j.files {
+		buf[xof] = file.op
+		*(*uint16)(unsafe.Pointer(&buf[xof+1])) = uint16(len(file.path))
+		xof += journalRecHdrSize
+		xof += copy(buf[xof:], file.path)
+	}
+	if e := j.header.encode(buf); e != nil {
+		return err.ErrorWithCause(e, "header.encode")
+	}
+
+	var swapfile = fs.SwapfileName(repo.JournalPath)
+	sfile, _, e := fs.OpenNewSwapfile(swapfile, false)
+	if e != nil {
+		return err.ErrorWithCause(e, "swapfile %q open-new", swapfile)
+	}
+	if _, e := sfile.Write(buf); e != nil {
+		sfile.Close()
+		return err.ErrorWithCause(e, "swapfile write")
+	}
+	if e := sfile.Sync(); e != nil {
+		sfile.Close()
+		return err.ErrorWithCause(e, "swapfile sync")
+	}
+	if e := sfile.Close(); e != nil {
+		return err.ErrorWithCause(e, "swapfile close")
+	}
+	if e := os.Rename(swapfile, repo.JournalPath); e != nil {
+		return err.ErrorWithCause(e, "os.Rename %q %q", swapfile, repo.JournalPath)
+	}
+	if e := syncFile(repo.RepoPath); e != nil {
+		return err.ErrorWithCause(e, "sync repo dir")
+	}
+	return nil
+}
+
+// apply swaps and removes the journaled files. apply is idempotent: files
+// whose swapfile does not exist are assumed to have been swapped, and files
+// that do not exist to have been removed.
+//
+// The directories of all journaled files are synced before apply returns, so
+// the journal can only be removed once the renames and removals are durable.
+func (j *journal) apply() error {
+	var err = errors.For("journal.apply")
+	var debug = debug.For("journal.apply")
+
+	var dirs = make(map[string]struct{})
+	for _, file := range j.files {
+		var path = file.path
+		var filename = filepath.Join(repo.RepoPath, path)
+		dirs[filepath.Dir(filename)] = struct{}{}
+		if file.op == removeFile {
+			if e := os.Remove(filename); e != nil && !os.IsNotExist(e) {
+				return err.ErrorWithCause(e, "os.Remove %q", filename)
+			}
+			debug.Printf("removed %q", path)
+			continue
+		}
+		var swapfile = fs.SwapfileName(filename)
+		if _, e := os.Stat(swapfile); os.IsNotExist(e) {
+			if _, e := os.Stat(filename); e != nil {
+				return err.Bug("file %q and its swapfile do not exist", filename)
+			}
+			debug.Printf("already swapped %q", path)
+			continue
+		}
+		if e := os.Rename(swapfile, filename); e != nil {
+			return err.ErrorWithCause(e, "os.Rename %q %q", swapfile, filename)
+		}
+		debug.Printf("swapped %q", path)
+	}
+	for dir := range dirs {
+		if e := syncFile(dir); e != nil && !os.IsNotExist(e) {
+			return err.ErrorWithCause(e, "sync dir %q", dir)
+		}
+	}
+	debug.Printf("synced %d dirs", len(dirs))
+	return nil
+}
+
+// readJournal reads the journal file.
+//
+// Returns nil, nil if the journal does not exist.
+func readJournal() (*journal, error) {
+	var err = errors.For("index.readJournal")
+
+	buf, e := ioutil.ReadFile(repo.JournalPath)
+	if e != nil {
+		if os.IsNotExist(e) {
+			return nil, nil
+		}
+		return nil, err.ErrorWithCause(e, "on read")
+	}
+	var header = &journalHeader{}
+	if e := header.decode(buf); e != nil {
+		return nil, err.ErrorWithCause(e, "on header.decode")
+	}
+
+	var j = &journal{header: header}
+	var xof = journalHeaderSize
+	for i := int64(0); i < header.fcnt; i++ {
+		if xof+journalRecHdrSize > len(buf) {
+			return nil, err.Bug("file record %d - truncated", i)
+		}
+		op := buf[xof]
+		n := int(*(*uint16)(unsafe.Pointer(&buf[xof+1])))
+		xof += journalRecHdrSize
+		if op != swapFile && op != removeFile {
+			return nil, err.Bug("file record %d - invalid op:%d", i, op)
+		}
+		if xof+n > len(buf) {
+			return nil, err.Bug("file record %d - truncated", i)
+		}
+		j.files = append(j.files, journalFile{op, string(buf[xof : xof+n])})
+		xof += n
+	}
+	return j, nil
+}
+
+func removeJournal() error {
+	if e := os.Remove(repo.JournalPath); e != nil && !os.IsNotExist(e) {
+		return errors.ErrorWithCause(e, "index.removeJournal")
+	}
+	return nil
+}
+
+/// recovery //////////////////////////////////////////////////////////////////
+
+// recoverIndex recovers the index from an interrupted session, if any. The
+// recovery requires the exclusive repo lock. If the opMode is Read and there
+// is an interrupted session (see interruptedSession), the (shared) lock is
+// upgraded for the recovery and then downgraded. The exclusive lock is waited
+// for up to LockTimeout (e.g. while other readers hold the lock), and if it is
+// not acquired, the recovery remains pending and an error is returned.
+//
+// Returns the repo lock held for the opMode. On error the lock is released.
+func recoverIndex(opMode OpMode, lock *fs.FileLock) (*fs.FileLock, error) {
+	var err = errors.For("index.recoverIndex")
+
+	if opMode == Read {
+		if !interruptedSession() {
+			return lock, nil
+		}
+		// note: POSIX locks are per process - release before relocking.
+		lock.Unlock()
+		xlock, e := lockRepo(Write)
+		if e != nil {
+			return nil, err.ErrorWithCause(e, "interrupted session - recovery pending (requires exclusive repo lock)")
+		}
+		if e := recoverSession(); e != nil {
+			xlock.Unlock()
+			return nil, err.ErrorWithCause(e, "on recovery")
+		}
+		xlock.Unlock()
+		return lockRepo(Read)
+	}
+
+	if e := recoverSession(); e != nil {
+		lock.Unlock()
+		return nil, err.ErrorWithCause(e, "on recovery")
+	}
+	return lock, nil
+}
+
+// recoverSession completes an interrupted Reindex, redoes the journaled
+// commit, if any, and undoes any uncommitted objects.idx records. Repo must
+// be locked exclusively.
+func recoverSession() error {
+	var debug = debug.For("index.recoverSession")
+
+	if e := recoverReindex(); e != nil {
+		return e
+	}
+	j, e := readJournal()
+	if e != nil {
+		return e
+	}
+	if j != nil {
+		debug.Printf("redo journal - created:%s files:%d", time.Unix(0, j.header.created), len(j.files))
+		if e := j.apply(); e != nil {
+			return e
+		}
+	}
+	var repair = j != nil
+	if !repair {
+		if repair, e = uncommittedObjectIndex(); e != nil {
+			return e
+		}
+	}
+	if repair {
+		if _, e := repairObjectIndex(j, false); e != nil {
+			return e
+		}
+	}
+	if j != nil {
+		return removeJournal()
+	}
+	return nil
+}
+
+// interruptedSession returns true if there is a journal to redo, an
+// interrupted Reindex to complete, or uncommitted objects.idx records to undo.
+// (The objects.idx header checksum includes the records, so readers must not
+// open the file before they are undone.)
+func interruptedSession() bool {
+	if _, e := os.Stat(repo.JournalPath); !os.IsNotExist(e) {
+		return true
+	}
+	if _, e := os.Stat(repo.IndexTagmapsPath); os.IsNotExist(e) {
+		return true
+	}
+	uncommitted, e := uncommittedObjectIndex()
+	return uncommitted || e != nil // errors are reported on recovery
+}
+
+// syncFile fsyncs the named file (or directory).
+func syncFile(filename string) error {
+	file, e := os.Open(filename)
+	if e != nil {
+		return e
+	}
+	defer file.Close()
+	return file.Sync()
+}
@@ -0,0 +1,208 @@
+// Doost!
+
+package index
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alphazero/gart/repo"
+	"github.com/alphazero/gart/syslib/fs"
+)
+
+// crashSession indexes the text object in a write session and abandons the
+// session as if the process was killed. If commit is true, the session is
+// killed after its journal is committed (see indexManager.commitJournal), and
+// otherwise after the swapfiles are saved but before the journal is written.
+func crashSession(t *testing.T, commit bool, text string, tags ...string) {
+	idx, e := OpenIndexManager(Write)
+	if e != nil {
+		t.Fatalf("OpenIndexManager - %v", e)
+	}
+	var im = idx.(*indexManager)
+	if _, _, e := im.IndexText(false, text, tags...); e != nil {
+		t.Fatalf("IndexText - %v", e)
+	}
+	if commit {
+		if j, e := im.commitJournal(); e != nil || j == nil {
+			t.Fatalf("commitJournal - j:%v e:%v", j, e)
+		}
+	} else {
+		for tag, tagmap := range im.tagmaps {
+			if _, e := tagmap.saveWip(); e != nil {
+				t.Fatalf("tagmap(%s).saveWip - %v", tag, e)
+			}
+		}
+		if e := im.oidx.sync(); e != nil {
+			t.Fatalf("oidx.sync - %v", e)
+		}
+	}
+	// killed: the appended objects.idx records are in the file, but the
+	// header is not updated
+	im.oidx.modified = false
+	if e := im.oidx.closeIndex(false); e != nil {
+		t.Fatalf("closeIndex - %v", e)
+	}
+	im.lock.Unlock()
+}
+
+func fsckClean(t *testing.T) {
+	report, e := Fsck(false)
+	if e != nil {
+		t.Fatalf("Fsck - %v", e)
+	}
+	if len(report.Issues) > 0 {
+		t.Fatalf("Fsck issues: %v", report.Issues)
+	}
+}
+
+func TestJournalReplay(t *testing.T) {
+	defer testRepo(t)()
+
+	indexTexts(t, testObject{"committed", []string{"a"}})
+	crashSession(t, true, "interrupted", "a", "b")
+
+	if _, e := os.Stat(repo.JournalPath); e != nil {
+		t.Fatalf("journal - %v", e)
+	}
+	if ok, e := uncommittedObjectIndex(); e != nil || !ok {
+		t.Fatalf("uncommittedObjectIndex - have:%t, %v - expect:true", ok, e)
+	}
+
+	// recovered on open of the next session - the commit is redone
+	if keys := searchKeys(t, NewQuery().IncludeTags("a").Build()); !equalInts(keys, []int{0, 1}) {
+		t.Fatalf("keys of a have:%v - expect:[0 1]", keys)
+	}
+	if keys := searchKeys(t, NewQuery().IncludeTags("b").Build()); !equalInts(keys, []int{1}) {
+		t.Fatalf("keys of b have:%v - expect:[1]", keys)
+	}
+	if _, e := os.Stat(repo.JournalPath); !os.IsNotExist(e) {
+		t.Fatalf("journal not removed - %v", e)
+	}
+	if ok, e := uncommittedObjectIndex(); e != nil || ok {
+		t.Fatalf("uncommittedObjectIndex - have:%t, %v - expect:false", ok, e)
+	}
+	fsckClean(t)
+
+	// and the next object has the next key
+	indexTexts(t, testObject{"next", []string{"a"}})
+	if keys := searchKeys(t, NewQuery().IncludeTags("a").Build()); !equalInts(keys, []int{0, 1, 2}) {
+		t.Fatalf("keys of a have:%v - expect:[0 1 2]", keys)
+	}
+	fsckClean(t)
+}
+
+func TestJournalDiscard(t *testing.T) {
+	defer testRepo(t)()
+
+	indexTexts(t, testObject{"committed", []string{"a"}})
+	crashSession(t, false, "interrupted", "a", "b")
+
+	if _, e := os.Stat(repo.JournalPath); !os.IsNotExist(e) {
+		t.Fatalf("unexpected journal - %v", e)
+	}
+	if ok, e := uncommittedObjectIndex(); e != nil || !ok {
+		t.Fatalf("uncommittedObjectIndex - have:%t, %v - expect:true", ok, e)
+	}
+
+	// recovered on open of the next session - the session is undone
+	if keys := searchKeys(t, NewQuery().IncludeTags("a").Build()); !equalInts(keys, []int{0}) {
+		t.Fatalf("keys of a have:%v - expect:[0]", keys)
+	}
+	if keys := searchKeys(t, NewQuery().IncludeTags("b").Build()); len(keys) != 0 {
+		t.Fatalf("keys of b have:%v - expect none", keys)
+	}
+	if ok, e := uncommittedObjectIndex(); e != nil || ok {
+		t.Fatalf("uncommittedObjectIndex - have:%t, %v - expect:false", ok, e)
+	}
+	// stale swapfiles and tagmaps created by the session are left for fsck
+	report, e := Fsck(true)
+	if e != nil {
+		t.Fatalf("Fsck - %v", e)
+	}
+	if n := report.Unrepaired(); n > 0 {
+		t.Fatalf("Fsck unrepaired:%d issues:%v", n, report.Issues)
+	}
+	fsckClean(t)
+
+	// the key of the discarded object is reused
+	oids := indexTexts(t, testObject{"next", []string{"a", "b"}})
+	if keys := searchKeys(t, NewQuery().IncludeTags("b").Build()); !equalInts(keys, []int{1}) {
+		t.Fatalf("keys of b have:%v - expect:[1]", keys)
+	}
+	if cards, e := FindCard(oids[0].String()); e != nil || len(cards) != 1 || cards[0].Key() != 1 {
+		t.Fatalf("FindCard - cards:%v e:%v", cards, e)
+	}
+	fsckClean(t)
+}
+
+func TestJournalApplyIdempotent(t *testing.T) {
+	defer testRepo(t)()
+
+	var swapped = filepath.Join(repo.RepoPath, "swapped")
+	var removed = filepath.Join(repo.RepoPath, "removed")
+	for _, filename := range []string{swapped, removed} {
+		if e := ioutil.WriteFile(filename, []byte("old"), repo.FilePerm); e != nil {
+			t.Fatalf("%v", e)
+		}
+	}
+	if e := ioutil.WriteFile(fs.SwapfileName(swapped), []byte("new"), repo.FilePerm); e != nil {
+		t.Fatalf("%v", e)
+	}
+
+	var j = newJournal()
+	if e := j.addFile(swapped); e != nil {
+		t.Fatalf("addFile - %v", e)
+	}
+	if e := j.removeFile(removed); e != nil {
+		t.Fatalf("removeFile - %v", e)
+	}
+	if e := j.addFile("/not/in/repo"); e == nil {
+		t.Fatalf("addFile - expected error for file not in repo")
+	}
+	if e := j.commit(); e != nil {
+		t.Fatalf("commit - %v", e)
+	}
+
+	j0, e := readJournal()
+	if e != nil || j0 == nil {
+		t.Fatalf("readJournal - j:%v e:%v", j0, e)
+	}
+	if len(j0.files) != 2 || j0.files[0] != j.files[0] || j0.files[1] != j.files[1] {
+		t.Fatalf("readJournal files have:%v - expect:%v", j0.files, j.files)
+	}
+	// redo is idempotent, e.g. if interrupted during apply
+	for i := 0; i < 2; i++ {
+		if e := j0.apply(); e != nil {
+			t.Fatalf("apply[%d] - %v", i, e)
+		}
+		if buf, e := ioutil.ReadFile(swapped); e != nil || string(buf) != "new" {
+			t.Fatalf("apply[%d] - swapped file have:%q, %v", i, buf, e)
+		}
+		if _, e := os.Stat(removed); !os.IsNotExist(e) {
+			t.Fatalf("apply[%d] - file not removed - %v", i, e)
+		}
+	}
+}
+
+func TestJournalCorrupt(t *testing.T) {
+	defer testRepo(t)()
+
+	crashSession(t, true, "interrupted", "a")
+	buf, e := ioutil.ReadFile(repo.JournalPath)
+	if e != nil {
+		t.Fatalf("%v", e)
+	}
+	buf[len(buf)-1] ^= 0xff
+	if e := ioutil.WriteFile(repo.JournalPath, buf, repo.FilePerm); e != nil {
+		t.Fatalf("%v", e)
+	}
+	if _, e := readJournal(); e == nil {
+		t.Fatalf("readJournal - expected checksum error")
+	}
+	if _, e := OpenIndexManager(Read); e == nil {
+		t.Fatalf("OpenIndexManager - expected recovery error")
+	}
+}
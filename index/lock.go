@@ -0,0 +1,48 @@
+// Doost!
+
+package index
+
+import (
+	"time"
+
+	"github.com/alphazero/gart/repo"
+	"github.com/alphazero/gart/syslib/debug"
+	"github.com/alphazero/gart/syslib/errors"
+	"github.com/alphazero/gart/syslib/fs"
+)
+
+/// repo lock //////////////////////////////////////////////////////////////////
+
+// LockTimeout is the maximum duration to wait for the repo lock. The default
+// zero value does not wait.
+var LockTimeout time.Duration
+
+// lockRepo acquires the advisory repo lock file. Read mode acquires a shared
+// lock, allowing concurrent readers. Write and Compact modes acquire an
+// exclusive lock.
+//
+// Returns nil, error (with the holder's pid) if the lock could not be
+// acquired within LockTimeout.
+func lockRepo(opMode OpMode) (*fs.FileLock, error) {
+	var err = errors.For("index.lockRepo")
+	var debug = debug.For("index.lockRepo")
+
+	var exclusive bool
+	switch opMode {
+	case Read:
+	case Write, Compact:
+		exclusive = true
+	default:
+		return nil, err.InvalidArg("opMode: %s", opMode)
+	}
+
+	debug.Printf("lock %q exclusive:%t timeout:%s", repo.LockPath, exclusive, LockTimeout)
+	lock, e := fs.LockFile(repo.LockPath, exclusive, LockTimeout)
+	if e != nil {
+		if le, ok := e.(*fs.LockedError); ok {
+			return nil, err.Error("repo is in use - %v (waited %s)", le, LockTimeout)
+		}
+		return nil, err.ErrorWithCause(e, "on fs.LockFile")
+	}
+	return lock, nil
+}
@@ -0,0 +1,121 @@
+// Doost!
+
+package index
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alphazero/gart/repo"
+	"github.com/alphazero/gart/system"
+)
+
+// helperEnv is set in the env of a helper process - see TestHelperProcess.
+const helperEnv = "GART_TEST_LOCK_REPO"
+
+// lockRepoInProcess acquires the (shared) repo lock in a separate process, as
+// repo locks are per process. The lock is held until the returned func is
+// called.
+//
+// Returns the pid of the lock holder and the release func.
+func lockRepoInProcess(t *testing.T) (int, func()) {
+	var cmd = exec.Command(os.Args[0], "-test.run=^TestHelperProcess$")
+	cmd.Env = append(os.Environ(), helperEnv+"="+filepath.Dir(repo.RepoPath))
+	stdin, e := cmd.StdinPipe()
+	if e != nil {
+		t.Fatalf("%v", e)
+	}
+	stdout, e := cmd.StdoutPipe()
+	if e != nil {
+		t.Fatalf("%v", e)
+	}
+	if e := cmd.Start(); e != nil {
+		t.Fatalf("%v", e)
+	}
+	var release = func() {
+		stdin.Close()
+		cmd.Wait()
+	}
+	if line, e := bufio.NewReader(stdout).ReadString('\n'); e != nil || line != "locked\n" {
+		release()
+		t.Fatalf("helper process - have:%q %v - expect:locked", line, e)
+	}
+	return cmd.Process.Pid, release
+}
+
+// TestHelperProcess is not a test. It is run by lockRepoInProcess, and holds
+// the repo lock until its stdin is closed.
+func TestHelperProcess(t *testing.T) {
+	var root = os.Getenv(helperEnv)
+	if root == "" {
+		return
+	}
+	system.SetRepoRoot(root)
+	lock, e := lockRepo(Read)
+	if e != nil {
+		fmt.Fprintf(os.Stderr, "lockRepo - %v\n", e)
+		os.Exit(1)
+	}
+	fmt.Println("locked")
+	ioutil.ReadAll(os.Stdin)
+	lock.Unlock()
+	os.Exit(0)
+}
+
+func TestLockRepo(t *testing.T) {
+	defer testRepo(t)()
+	defer func(timeout time.Duration) { LockTimeout = timeout }(LockTimeout)
+
+	if _, e := lockRepo(OpMode(0xff)); e == nil {
+		t.Fatalf("lockRepo(invalid) - expected error")
+	}
+
+	pid, release := lockRepoInProcess(t)
+	defer release()
+
+	// readers do not exclude each other
+	idx, e := OpenIndexManager(Read)
+	if e != nil {
+		t.Fatalf("OpenIndexManager(Read) - %v", e)
+	}
+	if e := idx.Close(false); e != nil {
+		t.Fatalf("Close - %v", e)
+	}
+
+	// writers wait at most LockTimeout, and the holder is reported
+	for _, timeout := range []time.Duration{0, 200 * time.Millisecond} {
+		LockTimeout = timeout
+		var start = time.Now()
+		if _, e := OpenIndexManager(Write); e == nil {
+			t.Fatalf("OpenIndexManager(Write) - timeout:%s - expected error", timeout)
+		} else if !strings.Contains(e.Error(), fmt.Sprintf("pid %d", pid)) {
+			t.Fatalf("OpenIndexManager(Write) - timeout:%s - error does not report pid %d: %v", timeout, pid, e)
+		}
+		if elapsed := time.Since(start); elapsed < timeout/2 {
+			t.Fatalf("OpenIndexManager(Write) returned after %s - timeout:%s", elapsed, timeout)
+		}
+		if _, e := CompactIndex(); e == nil {
+			t.Fatalf("CompactIndex - timeout:%s - expected error", timeout)
+		}
+	}
+
+	// and acquire the lock once the readers release it
+	LockTimeout = 5 * time.Second
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		release()
+	}()
+	if idx, e = OpenIndexManager(Write); e != nil {
+		t.Fatalf("OpenIndexManager(Write) - %v", e)
+	}
+	if e := idx.Close(false); e != nil {
+		t.Fatalf("Close - %v", e)
+	}
+}
@@ -5,6 +5,7 @@ package index
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"sort"
 	"syscall"
@@ -37,8 +38,6 @@ const (
 	mmap_idx_file_code uint64 = 0x8fe452c6d1f55c66 // sha256("mmaped-index-file")[:8]
 )
 
-var oidxFilename string = repo.ObjectIndexPath
-
 // objectsHeader related consts
 const (
 	objectsHeaderSize = 0x1000
@@ -199,7 +198,7 @@ func createObjectIndex() error {
 	var err = errors.For("index.createObjectIndex")
 
 	// create object index file
-	file, e := fs.OpenNewFile(oidxFilename, os.O_WRONLY|os.O_APPEND)
+	file, e := fs.OpenNewFile(repo.ObjectIndexPath, os.O_WRONLY|os.O_APPEND)
 	if e != nil {
 		return e
 	}
@@ -216,7 +215,7 @@ func createObjectIndex() error {
 	}
 	var buf [objectsHeaderSize]byte
 	if e := header.encode(buf[:]); e != nil {
-		if ec := os.Remove(oidxFilename); ec != nil {
+		if ec := os.Remove(repo.ObjectIndexPath); ec != nil {
 			panic(err.Fault("os.Remove - %s - recovering from: %s", ec, e))
 		}
 		return e
@@ -230,6 +229,42 @@ func createObjectIndex() error {
 	return nil
 }
 
+// saveObjectIndexWip writes a new objects.idx, with the oids in key order,
+// to the objects.idx swapfile. The created time of the header is retained.
+func saveObjectIndexWip(created int64, oids []*system.Oid) error {
+	var err = errors.For("index.saveObjectIndexWip")
+
+	var ocnt = int64(len(oids))
+	var pcnt = uint64((ocnt + objectsPerPage - 1) / objectsPerPage)
+	var buf = make([]byte, objectsHeaderSize+(pcnt*objectsPageSize))
+	for key, oid := range oids {
+		var offset = (key << 5) + objectsHeaderSize
+		copy(buf[offset:offset+objectsRecordSize], oid.Bytes())
+	}
+	var header = &objectsHeader{
+		ftype:   mmap_idx_file_code,
+		created: created,
+		updated: time.Now().UnixNano(),
+		pcnt:    pcnt,
+		ocnt:    ocnt,
+	}
+	// note: header crc is the checksum of the entire file
+	if e := header.encode(buf); e != nil {
+		return err.ErrorWithCause(e, "on header.encode")
+	}
+
+	var swapfile = fs.SwapfileName(repo.ObjectIndexPath)
+	sfile, _, e := fs.OpenNewSwapfile(swapfile, false)
+	if e != nil {
+		return err.ErrorWithCause(e, "swapfile %q open-new", swapfile)
+	}
+	defer sfile.Close()
+	if _, e := sfile.Write(buf); e != nil {
+		return err.ErrorWithCause(e, "swapfile write")
+	}
+	return nil
+}
+
 // OpenObjectIndex opens the objects.idx in the given OpMode and returns
 // the handle to the index.
 //
@@ -265,7 +300,7 @@ func openObjectIndex(opMode OpMode) (*oidxFile, error) {
 
 	/// open file and map objectFile /////////////////////////////////
 
-	file, e := os.OpenFile(oidxFilename, oflags, repo.FilePerm)
+	file, e := os.OpenFile(repo.ObjectIndexPath, oflags, repo.FilePerm)
 	if e != nil {
 		return nil, e
 	}
@@ -278,7 +313,7 @@ func openObjectIndex(opMode OpMode) (*oidxFile, error) {
 
 	var oidx = &oidxFile{
 		header: &objectsHeader{},
-		source: oidxFilename,
+		source: repo.ObjectIndexPath,
 		opMode: opMode,
 		file:   file,
 		finfo:  finfo,
@@ -334,8 +369,9 @@ func (oidx *oidxFile) addObject(oid *system.Oid) (int64, error) {
 	return key, nil
 }
 
-// validateQueryArgs asserts requirements for a Read op of object index,
-// including the validity of the input keys.
+// validateQueryArgs asserts requirements for a query of the object index,
+// including the validity of the input keys. Queries are valid in both Read
+// and Write op modes.
 //
 // Returns the sorted keys and nil error if query is valid.
 //
@@ -344,7 +380,7 @@ func (oidx *oidxFile) addObject(oid *system.Oid) (int64, error) {
 // Returns nil, index.ErrObjectIndexClosed or any other encountered error.
 func (oidx *oidxFile) validateQueryArgs(keys ...int) ([]int, error) {
 	var err = errors.For("oidxFile.validateQueryArgs")
-	if oidx.opMode != Read {
+	if oidx.opMode != Read && oidx.opMode != Write {
 		return nil, err.Bug("invalid op-mode:%s", oidx.opMode)
 	}
 	if len(keys) == 0 { // a bug given that oidx (self) is the (only) caller.
@@ -362,6 +398,21 @@ func (oidx *oidxFile) validateQueryArgs(keys ...int) ([]int, error) {
 	return keys, nil
 }
 
+// getOid returns the oid for the provided key.
+//
+// Returns nil, Bug for key values < 0 or > oidx.object count.
+// Returns nil, index.ErrObjectIndexClosed or any other encountered error.
+func (oidx *oidxFile) getOid(key int) (*system.Oid, error) {
+	if oidx.file == nil {
+		return nil, ErrObjectIndexClosed
+	}
+	if _, e := oidx.validateQueryArgs(key); e != nil {
+		return nil, e
+	}
+	offset := (key << 5) + objectsHeaderSize
+	return system.NewOid(oidx.buf[offset : offset+objectsRecordSize])
+}
+
 // getOids returns the oids for the provided keys.
 //
 // Returns the oids corresponding to the sorted key set.
@@ -559,3 +610,143 @@ func (oidx *oidxFile) closeIndex(commit bool) error {
 	oidx.opMode = 0 // invalid
 	return nil
 }
+
+// sync flushes the appended records to the file, if modified. (The records
+// are written to the shared mapping of the file and fsync also writes back
+// the dirty mapped pages.) The header is not updated (cf. closeIndex).
+func (oidx *oidxFile) sync() error {
+	if !oidx.modified {
+		return nil
+	}
+	if e := oidx.file.Sync(); e != nil {
+		return errors.ErrorWithCause(e, "oidxFile.sync")
+	}
+	return nil
+}
+
+// uncommittedObjectIndex returns true if objects.idx has records appended by
+// an uncommitted session, i.e. the file extends beyond the page count of its
+// header, or there are (non-zero) records beyond its object count. Only the
+// header and the tail of the last page are read (cf. repairObjectIndex).
+func uncommittedObjectIndex() (bool, error) {
+	var err = errors.For("index.uncommittedObjectIndex")
+
+	file, e := os.Open(repo.ObjectIndexPath)
+	if e != nil {
+		return false, err.ErrorWithCause(e, "on open")
+	}
+	defer file.Close()
+
+	finfo, e := file.Stat()
+	if e != nil {
+		return false, err.ErrorWithCause(e, "on stat")
+	}
+	var buf [objectsHeaderSize]byte
+	if _, e := io.ReadFull(file, buf[:]); e != nil {
+		return false, err.ErrorWithCause(e, "on read header")
+	}
+	var header = *(*objectsHeader)(unsafe.Pointer(&buf[0]))
+	var size = int64(objectsHeaderSize + header.pcnt*objectsPageSize)
+	if finfo.Size() != size {
+		return true, nil
+	}
+	var offset = header.ocnt<<5 + objectsHeaderSize
+	if offset >= size {
+		return false, nil
+	}
+	var tail = make([]byte, size-offset)
+	if _, e := file.ReadAt(tail, offset); e != nil {
+		return false, err.ErrorWithCause(e, "on read last page")
+	}
+	for _, b := range tail {
+		if b != 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// repairObjectIndex restores objects.idx to its committed state after an
+// interrupted session. The entire file is read, so callers should first
+// check for an interrupted session (see uncommittedObjectIndex). If the session journal is not nil, its objects.idx
+// header is (re)applied. The file is then truncated to the page count of the
+// header and residual records, beyond the object count, are cleared. (Note
+// that the header crc is the checksum of the entire file.) If dryrun is true,
+// the file is verified but not modified.
+//
+// Returns true if the file was (or, in dryrun, would be) repaired.
+func repairObjectIndex(j *journal, dryrun bool) (bool, error) {
+	var err = errors.For("index.repairObjectIndex")
+	var debug = debug.For("index.repairObjectIndex")
+
+	file, e := os.OpenFile(repo.ObjectIndexPath, os.O_RDWR, repo.FilePerm)
+	if e != nil {
+		return false, err.ErrorWithCause(e, "on open")
+	}
+	defer file.Close()
+
+	buf, e := ioutil.ReadAll(file)
+	if e != nil {
+		return false, err.ErrorWithCause(e, "on read")
+	}
+	if len(buf) < objectsHeaderSize {
+		return false, err.Bug("file size:%d", len(buf))
+	}
+	// note: header is verified once the residual records are removed
+	var header = *(*objectsHeader)(unsafe.Pointer(&buf[0]))
+	var redo = j != nil && j.header.ocnt >= 0
+	if redo {
+		debug.Printf("redo header - pcnt:%d ocnt:%d", j.header.pcnt, j.header.ocnt)
+		header.pcnt = j.header.pcnt
+		header.ocnt = j.header.ocnt
+		header.updated = j.header.created
+	}
+
+	var size = objectsHeaderSize + int(header.pcnt*objectsPageSize)
+	if len(buf) < size {
+		return false, err.Bug("file size:%d - header pcnt:%d", len(buf), header.pcnt)
+	}
+	var truncate = len(buf) > size
+	buf = buf[:size]
+
+	var offset = int(header.ocnt<<5) + objectsHeaderSize
+	var residual bool
+	for xof := offset; xof < size; xof++ {
+		if buf[xof] != 0 {
+			residual = true
+			buf[xof] = 0
+		}
+	}
+
+	if redo {
+		if e := header.encode(buf); e != nil {
+			return false, err.ErrorWithCause(e, "on header.encode")
+		}
+	} else if e := header.decode(buf); e != nil {
+		return false, err.ErrorWithCause(e, "on header.decode")
+	}
+
+	if !(redo || truncate || residual) || dryrun {
+		return redo || truncate || residual, nil
+	}
+	debug.Printf("repair - redo:%t truncate:%t residual:%t", redo, truncate, residual)
+	if truncate {
+		if e := file.Truncate(int64(size)); e != nil {
+			return false, err.ErrorWithCause(e, "on truncate")
+		}
+	}
+	if residual && offset < size {
+		if _, e := file.WriteAt(buf[offset:], int64(offset)); e != nil {
+			return false, err.ErrorWithCause(e, "on clear residual records")
+		}
+	}
+	if redo {
+		if _, e := file.WriteAt(buf[:objectsHeaderSize], 0); e != nil {
+			return false, err.ErrorWithCause(e, "on write header")
+		}
+	}
+	if e := file.Sync(); e != nil {
+		return false, err.ErrorWithCause(e, "on sync")
+	}
+	return true, nil
+}
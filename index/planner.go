@@ -0,0 +1,419 @@
+// Doost!
+
+package index
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/alphazero/gart/syslib/bitmap"
+	"github.com/alphazero/gart/syslib/errors"
+	"github.com/alphazero/gart/system/systemic"
+)
+
+/// Query planner //////////////////////////////////////////////////////////////
+
+// A query is planned and evaluated as a conjunction of terms: the include tags
+// and the query expression (if any) are positive terms, and the exclude tags are
+// negated terms. Positive terms are intersected in order of their estimated
+// cardinality, smallest first, and negated terms are then removed from the
+// result with and-not. Evaluation stops as soon as the intermediate result is
+// empty. The all-inclusive systemic:gart-object map is only loaded if there are
+// no positive terms, or for a standalone not in an or/xor expression.
+//
+// The estimated cardinality of a tag is the (compressed) size of its tagmap.
+// Tagmap max bitnum is used to break ties.
+
+// QueryPlan records the steps of the evaluation of a query. It is returned by
+// IndexManager.Explain.
+type QueryPlan struct {
+	Steps []PlanStep
+}
+
+// PlanStep is a single bitmap operation of a QueryPlan.
+type PlanStep struct {
+	Op    string // all, load, and, and-not, or, xor, not, days, stop, empty
+	Arg   string // tag or sub-expression operand
+	Size  uint64 // operand bitmap size in bytes (estimate for sub-expressions)
+	Max   uint64 // operand bitmap max bitnum
+	Count int    // cardinality of the intermediate result
+	Bytes int    // size in bytes of the intermediate result
+}
+
+func (p *QueryPlan) Print(w io.Writer) {
+	fmt.Fprintf(w, "%-3s %-8s %10s %10s %10s %10s  %s\n",
+		"#", "op", "arg-size", "arg-max", "res-size", "res-count", "arg")
+	for i, step := range p.Steps {
+		fmt.Fprintf(w, "%-3d %-8s %10d %10d %10d %10d  %s\n",
+			i, step.Op, step.Size, step.Max, step.Bytes, step.Count, step.Arg)
+	}
+}
+
+// planTerm is a term of a conjunction.
+type planTerm struct {
+	x       queryExpr
+	negated bool
+	size    uint64 // estimated cardinality (see planner.estimate)
+	max     uint64
+}
+
+// planner evaluates queries. If plan is not nil, all evaluation steps are
+// recorded.
+type planner struct {
+	idx     *indexManager
+	all     *bitmap.Wahl // loaded on demand
+	tagmaps map[string]*Tagmap
+	plan    *QueryPlan
+	dated   *time.Time // loaded on demand - see dateTags
+}
+
+func newPlanner(idx *indexManager, explain bool) *planner {
+	var p = &planner{
+		idx:     idx,
+		tagmaps: make(map[string]*Tagmap),
+	}
+	if explain {
+		p.plan = &QueryPlan{}
+	}
+	return p
+}
+
+// search evaluates the query and returns the bitmap of selected objects.
+func (p *planner) search(q *query) (*bitmap.Wahl, error) {
+	var terms []*planTerm
+	for tag := range q.include {
+		terms = append(terms, &planTerm{x: tagExpr(tag)})
+	}
+	for tag := range q.exclude {
+		terms = append(terms, &planTerm{x: tagExpr(tag), negated: true})
+	}
+	if q.expr != nil {
+		terms = append(terms, p.conjunction(q.expr)...)
+	}
+	if q.days != nil {
+		terms = append(terms, &planTerm{x: q.days})
+	}
+	return p.and(terms)
+}
+
+// conjunction flattens an and expression into its terms.
+func (p *planner) conjunction(x queryExpr) []*planTerm {
+	switch x := x.(type) {
+	case binaryExpr:
+		if x.op == andOp {
+			var terms []*planTerm
+			for _, arg := range x.args {
+				terms = append(terms, p.conjunction(arg)...)
+			}
+			return terms
+		}
+	case notExpr:
+		return []*planTerm{{x: x.x, negated: true}}
+	}
+	return []*planTerm{{x: x}}
+}
+
+// and evaluates the conjunction of terms.
+func (p *planner) and(terms []*planTerm) (*bitmap.Wahl, error) {
+	var positive, negated []*planTerm
+	for _, term := range terms {
+		var e error
+		if term.size, term.max, e = p.estimate(term.x); e != nil {
+			return nil, e
+		}
+		if term.negated {
+			negated = append(negated, term)
+		} else {
+			positive = append(positive, term)
+		}
+	}
+	sort.SliceStable(positive, func(i, j int) bool {
+		if positive[i].size == positive[j].size {
+			return positive[i].max < positive[j].max
+		}
+		return positive[i].size < positive[j].size
+	})
+
+	var result *bitmap.Wahl
+	var e error
+	if len(positive) == 0 {
+		if result, e = p.allObjects(); e != nil {
+			return nil, e
+		}
+		p.trace("all", systemic.GartTag(), 0, 0, result)
+	} else {
+		var term = positive[0]
+		if result, e = term.x.eval(p); e != nil {
+			return nil, e
+		}
+		if _, ok := term.x.(tagExpr); ok { // sub-expressions trace their own steps
+			p.trace("load", term.x.String(), term.size, term.max, result)
+		}
+		positive = positive[1:]
+	}
+
+	for _, term := range positive {
+		if result.Count() == 0 {
+			p.trace("stop", "", 0, 0, result)
+			return result, nil
+		}
+		if term.size == 0 { // undefined tag or empty bitmap
+			result = bitmap.NewWahl()
+			p.trace("empty", term.x.String(), term.size, term.max, result)
+			return result, nil
+		}
+		wahl, e := term.x.eval(p)
+		if e != nil {
+			return nil, e
+		}
+		if result, e = result.And(wahl); e != nil {
+			return nil, errors.ErrorWithCause(e, "planner.and: on AND %s", term.x)
+		}
+		p.trace("and", term.x.String(), term.size, term.max, result)
+	}
+	for _, term := range negated {
+		if result.Count() == 0 {
+			p.trace("stop", "", 0, 0, result)
+			return result, nil
+		}
+		if term.size == 0 {
+			continue // nothing to remove
+		}
+		wahl, e := term.x.eval(p)
+		if e != nil {
+			return nil, e
+		}
+		if result, e = result.AndNot(wahl); e != nil {
+			return nil, errors.ErrorWithCause(e, "planner.and: on AND-NOT %s", term.x)
+		}
+		p.trace("and-not", term.x.String(), term.size, term.max, result)
+	}
+	return result, nil
+}
+
+// combine evaluates the or/xor of the expressions.
+func (p *planner) combine(op exprOp, args []queryExpr) (*bitmap.Wahl, error) {
+	var bitmaps = make([]*bitmap.Wahl, len(args))
+	for i, arg := range args {
+		wahl, e := arg.eval(p)
+		if e != nil {
+			return nil, e
+		}
+		bitmaps[i] = wahl
+	}
+	var result *bitmap.Wahl
+	var e error
+	var opname string
+	switch op {
+	case orOp:
+		opname = "or"
+		result, e = bitmap.Or(bitmaps...)
+	case xorOp:
+		opname = "xor"
+		result, e = bitmap.Xor(bitmaps...)
+	default:
+		return nil, errors.Bug("planner.combine: invalid op: %d", op)
+	}
+	if e != nil {
+		return nil, errors.ErrorWithCause(e, "planner.combine: on %s", opname)
+	}
+	p.trace(opname, binaryExpr{op, args}.String(), 0, 0, result)
+	return result, nil
+}
+
+// not evaluates the complement of the expression relative to all objects.
+func (p *planner) not(x queryExpr) (*bitmap.Wahl, error) {
+	all, e := p.allObjects()
+	if e != nil {
+		return nil, e
+	}
+	wahl, e := x.eval(p)
+	if e != nil {
+		return nil, e
+	}
+	result, e := all.AndNot(wahl)
+	if e != nil {
+		return nil, errors.ErrorWithCause(e, "planner.not: on AND-NOT %s", x)
+	}
+	p.trace("not", x.String(), 0, 0, result)
+	return result, nil
+}
+
+// days evaluates the day range as the OR of the date tagmaps that cover the
+// range (see dayRange.tags).
+func (p *planner) days(x *dayRange) (*bitmap.Wahl, error) {
+	tags, e := p.dateTags(x)
+	if e != nil {
+		return nil, e
+	}
+	var bitmaps = make([]*bitmap.Wahl, 0, len(tags))
+	for _, tag := range tags {
+		wahl, e := p.tagBitmap(tag)
+		if e != nil {
+			return nil, e
+		}
+		bitmaps = append(bitmaps, wahl)
+	}
+	result, e := bitmap.Or(bitmaps...)
+	if e != nil {
+		return nil, errors.ErrorWithCause(e, "planner.days: on OR")
+	}
+	p.trace("days", fmt.Sprintf("%s (%d date tags)", x, len(tags)), 0, 0, result)
+	return result, nil
+}
+
+// dateTags returns the defined date tags that cover the range. An open range
+// is bounded by the day the index was created, and today. Month and year tags
+// are only used for the periods of objects tagged with them (see
+// tagdictHeader.dateTagged).
+func (p *planner) dateTags(x *dayRange) ([]string, error) {
+	if p.dated == nil {
+		var dated time.Time
+		if p.idx.tagdict != nil {
+			dated = p.idx.tagdict.header.dateTagged()
+		} else {
+			dated = loadDateTagged()
+		}
+		p.dated = &dated
+	}
+	var first = truncateDay(time.Unix(0, p.idx.oidx.header.created))
+	var tags []string
+	for _, tag := range x.tags(first, truncateDay(time.Now()), *p.dated) {
+		tagmap, e := p.tagmap(tag)
+		if e != nil {
+			return nil, e
+		}
+		if tagmap != nil {
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+// estimate returns the estimated cardinality and max bitnum of the expression.
+func (p *planner) estimate(x queryExpr) (uint64, uint64, error) {
+	switch x := x.(type) {
+	case *dayRange:
+		tags, e := p.dateTags(x)
+		if e != nil {
+			return 0, 0, e
+		}
+		var size, max uint64
+		for _, tag := range tags {
+			asize, amax, e := p.estimate(tagExpr(tag))
+			if e != nil {
+				return 0, 0, e
+			}
+			size, max = size+asize, maxUint64(max, amax)
+		}
+		return size, max, nil
+	case tagExpr:
+		tagmap, e := p.tagmap(string(x))
+		if e != nil || tagmap == nil {
+			return 0, 0, e
+		}
+		size, max := tagmap.stats()
+		return size, max, nil
+	case binaryExpr:
+		var size, max uint64
+		if x.op == andOp {
+			size, max = math.MaxUint64, math.MaxUint64
+		}
+		for _, arg := range x.args {
+			asize, amax, e := p.estimate(arg)
+			if e != nil {
+				return 0, 0, e
+			}
+			switch x.op {
+			case andOp:
+				size, max = minUint64(size, asize), minUint64(max, amax)
+			default:
+				size, max = size+asize, maxUint64(max, amax)
+			}
+		}
+		return size, max, nil
+	}
+	// not: the complement is estimated as all objects
+	all, e := p.allObjects()
+	if e != nil {
+		return 0, 0, e
+	}
+	return uint64(all.Size()), uint64(all.Max()), nil
+}
+
+// tagmap returns the (cached) tagmap of the tag, or nil if tag is not defined.
+func (p *planner) tagmap(tag string) (*Tagmap, error) {
+	if tagmap, ok := p.tagmaps[tag]; ok {
+		return tagmap, nil
+	}
+	tagmap, ok := p.idx.tagmaps[tag]
+	if !ok {
+		var e error
+		tagmap, e = loadTagmap(tag, false)
+		if e == ErrTagNotExist {
+			tagmap = nil
+		} else if e != nil {
+			return nil, errors.Bug("planner.tagmap: loadTagmap(%s) - %v", tag, e)
+		}
+	}
+	p.tagmaps[tag] = tagmap
+	return tagmap, nil
+}
+
+// tagBitmap returns the bitmap of the tag. Undefined tags map to the empty
+// bitmap.
+func (p *planner) tagBitmap(tag string) (*bitmap.Wahl, error) {
+	tagmap, e := p.tagmap(tag)
+	if e != nil {
+		return nil, e
+	}
+	if tagmap == nil {
+		return bitmap.NewWahl(), nil
+	}
+	return tagmap.bitmap, nil
+}
+
+func (p *planner) allObjects() (*bitmap.Wahl, error) {
+	if p.all == nil {
+		tagmap, e := p.tagmap(systemic.GartTag())
+		if e != nil {
+			return nil, e
+		}
+		if tagmap == nil {
+			return nil, errors.Bug("planner.allObjects: tagmap(%s) does not exist", systemic.GartTag())
+		}
+		p.all = tagmap.bitmap
+	}
+	return p.all, nil
+}
+
+func (p *planner) trace(op, arg string, size, max uint64, result *bitmap.Wahl) {
+	if p.plan == nil {
+		return
+	}
+	p.plan.Steps = append(p.plan.Steps, PlanStep{
+		Op:    op,
+		Arg:   arg,
+		Size:  size,
+		Max:   max,
+		Count: result.Count(),
+		Bytes: result.Size(),
+	})
+}
+
+func minUint64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxUint64(a, b uint64) uint64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,323 @@
+// Doost!
+
+package index
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alphazero/gart/system/systemic"
+)
+
+// planner test objects have keys 0..63, tagged:
+//
+//	all  - all objects
+//	even - objects with even keys
+//	low  - keys 0..3
+//	k5   - key 5
+//	k60  - key 60
+func plannerTestRepo(t *testing.T) func() {
+	var done = testRepo(t)
+	var objects = make([]testObject, 64)
+	for key := range objects {
+		var tags = []string{"all"}
+		if key%2 == 0 {
+			tags = append(tags, "even")
+		}
+		if key < 4 {
+			tags = append(tags, "low")
+		}
+		switch key {
+		case 5:
+			tags = append(tags, "k5")
+		case 60:
+			tags = append(tags, "k60")
+		}
+		objects[key] = testObject{fmt.Sprintf("object-%d", key), tags}
+	}
+	indexTexts(t, objects...)
+	return done
+}
+
+// explain returns the query plan and selected keys of the query.
+func explain(t *testing.T, q Query) (*QueryPlan, []int) {
+	idx, e := OpenIndexManager(Read)
+	if e != nil {
+		t.Fatalf("OpenIndexManager - %v", e)
+	}
+	defer idx.Close(false)
+
+	var p = newPlanner(idx.(*indexManager), true)
+	wahl, e := p.search(q.asQuery())
+	if e != nil {
+		t.Fatalf("search - %v", e)
+	}
+	return p.plan, []int(wahl.Bits())
+}
+
+func planOps(plan *QueryPlan) []string {
+	var ops []string
+	for _, step := range plan.Steps {
+		ops = append(ops, fmt.Sprintf("%s %s", step.Op, step.Arg))
+	}
+	return ops
+}
+
+func keyRange(from, to, step int) []int {
+	var keys []int
+	for key := from; key < to; key += step {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func TestPlannerTermOrder(t *testing.T) {
+	defer plannerTestRepo(t)()
+
+	// expected order is by tagmap size, then max bitnum
+	var tags = []string{"all", "even", "low", "k60"}
+	type stat struct {
+		tag       string
+		size, max uint64
+	}
+	var stats []stat
+	for _, tag := range tags {
+		tagmap, e := loadTagmap(tag, false)
+		if e != nil {
+			t.Fatalf("loadTagmap(%q) - %v", tag, e)
+		}
+		size, max := tagmap.stats()
+		stats = append(stats, stat{tag, size, max})
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].size == stats[j].size {
+			return stats[i].max < stats[j].max
+		}
+		return stats[i].size < stats[j].size
+	})
+
+	plan, keys := explain(t, NewQuery().IncludeTags("all", "even", "low").Build())
+	var expect []string
+	for _, s := range stats {
+		if s.tag == "k60" {
+			continue
+		}
+		var op = "and"
+		if len(expect) == 0 {
+			op = "load"
+		}
+		expect = append(expect, fmt.Sprintf("%s %s", op, s.tag))
+	}
+	if have := planOps(plan); fmt.Sprint(have) != fmt.Sprint(expect) {
+		t.Fatalf("plan have:%q - expect:%q", have, expect)
+	}
+	if !equalInts(keys, []int{0, 2}) {
+		t.Fatalf("keys have:%v - expect:[0 2]", keys)
+	}
+	// a smaller term is evaluated first regardless of query order
+	if plan.Steps[0].Size > plan.Steps[len(plan.Steps)-1].Size {
+		t.Fatalf("first term size:%d > last term size:%d", plan.Steps[0].Size, plan.Steps[len(plan.Steps)-1].Size)
+	}
+}
+
+func TestPlannerStopOnEmpty(t *testing.T) {
+	defer plannerTestRepo(t)()
+
+	// undefined tag - size 0 is first and nothing else is evaluated
+	plan, keys := explain(t, NewQuery().IncludeTags("all", "even", "no-such-tag").Build())
+	if len(keys) != 0 {
+		t.Fatalf("keys have:%v - expect none", keys)
+	}
+	var expect = []string{"load no-such-tag", "stop "}
+	if have := planOps(plan); fmt.Sprint(have) != fmt.Sprint(expect) {
+		t.Fatalf("plan have:%q - expect:%q", have, expect)
+	}
+
+	// disjoint tags - evaluation stops once the intermediate result is empty
+	plan, keys = explain(t, NewQuery().IncludeTags("all", "even", "k5", "k60").ExcludeTags("low").Build())
+	if len(keys) != 0 {
+		t.Fatalf("keys have:%v - expect none", keys)
+	}
+	var last = plan.Steps[len(plan.Steps)-1]
+	if last.Op != "stop" {
+		t.Fatalf("last step have:%q - expect:stop", last.Op)
+	}
+	for _, step := range plan.Steps {
+		if step.Op == "and-not" {
+			t.Fatalf("negated term evaluated after empty result - plan:%q", planOps(plan))
+		}
+	}
+	if n := len(plan.Steps) - 1; n >= 5 {
+		t.Fatalf("all %d terms evaluated - plan:%q", n, planOps(plan))
+	}
+}
+
+func TestPlannerNegatedOnly(t *testing.T) {
+	defer plannerTestRepo(t)()
+
+	var odd = keyRange(1, 64, 2)
+	for _, q := range []Query{
+		NewQuery().ExcludeTags("even").Build(),
+		mustParseQuery(t, "!even"),
+	} {
+		plan, keys := explain(t, q)
+		var expect = []string{"all " + systemic.GartTag(), "and-not even"}
+		if have := planOps(plan); fmt.Sprint(have) != fmt.Sprint(expect) {
+			t.Fatalf("plan have:%q - expect:%q", have, expect)
+		}
+		if !equalInts(keys, odd) {
+			t.Fatalf("keys have:%v - expect:%v", keys, odd)
+		}
+	}
+
+	// all objects less an empty set
+	_, keys := explain(t, NewQuery().ExcludeTags("no-such-tag").Build())
+	if !equalInts(keys, keyRange(0, 64, 1)) {
+		t.Fatalf("keys have:%v - expect all", keys)
+	}
+}
+
+// the result of a single tag query is the (cached) tagmap bitmap itself. The
+// planner must never modify the bitmaps of tagmaps.
+func TestPlannerTagmapAliasing(t *testing.T) {
+	defer plannerTestRepo(t)()
+
+	var tags = []string{"all", "even", "low", "k5", "k60", systemic.GartTag()}
+	var queries = []Query{
+		NewQuery().IncludeTags("even").Build(),
+		NewQuery().IncludeTags("even").ExcludeTags("low").Build(),
+		NewQuery().ExcludeTags("even").Build(),
+		mustParseQuery(t, "even & !low | k5"),
+		mustParseQuery(t, "!(low ^ even) & all"),
+		mustParseQuery(t, "low | k60 | k5"),
+	}
+
+	// read session - planner tagmaps are loaded from file
+	idx, e := OpenIndexManager(Read)
+	if e != nil {
+		t.Fatalf("OpenIndexManager - %v", e)
+	}
+	var p = newPlanner(idx.(*indexManager), false)
+	var before = make(map[string][]int)
+	for _, tag := range tags {
+		wahl, e := p.tagBitmap(tag)
+		if e != nil {
+			t.Fatalf("tagBitmap(%q) - %v", tag, e)
+		}
+		before[tag] = []int(wahl.Bits())
+	}
+	for _, q := range queries {
+		if _, e := p.search(q.asQuery()); e != nil {
+			t.Fatalf("search - %v", e)
+		}
+	}
+	for _, tag := range tags {
+		if have := []int(p.tagmaps[tag].bitmap.Bits()); !equalInts(have, before[tag]) {
+			t.Fatalf("tagmap %q modified by query - have:%v - expect:%v", tag, have, before[tag])
+		}
+	}
+	idx.Close(false)
+
+	// write session - planner uses the (modified) in-mem tagmaps of session
+	idx, e = OpenIndexManager(Write)
+	if e != nil {
+		t.Fatalf("OpenIndexManager - %v", e)
+	}
+	defer idx.Rollback()
+	if _, _, e := idx.IndexText(false, "object-64", "all", "even"); e != nil {
+		t.Fatalf("IndexText - %v", e)
+	}
+	var tagmaps = idx.(*indexManager).tagmaps
+	before = make(map[string][]int)
+	for tag, tagmap := range tagmaps {
+		before[tag] = []int(tagmap.bitmap.Bits())
+	}
+	for _, q := range queries {
+		if _, e := idx.Search(q); e != nil {
+			t.Fatalf("Search - %v", e)
+		}
+	}
+	for tag, tagmap := range tagmaps {
+		if have := []int(tagmap.bitmap.Bits()); !equalInts(have, before[tag]) {
+			t.Fatalf("session tagmap %q modified by query - have:%v - expect:%v", tag, have, before[tag])
+		}
+	}
+}
+
+func mustParseQuery(t *testing.T, s string) Query {
+	q, e := ParseQuery(s)
+	if e != nil {
+		t.Fatalf("ParseQuery(%q) - %v", s, e)
+	}
+	return q
+}
+
+func TestPlannerDays(t *testing.T) {
+	defer testRepo(t)()
+
+	// objects are also tagged with the date tags of today
+	indexTexts(t,
+		testObject{"o0", systemic.DateTags(localDate(2018, 3, 15))},
+		testObject{"o1", systemic.DateTags(localDate(2018, 4, 1))},
+		testObject{"o2", systemic.DateTags(localDate(2019, 6, 30))},
+	)
+	var today = truncateDay(time.Now())
+	var tests = []struct {
+		since, until time.Time
+		keys         []int
+		tags         int // defined date tags of range
+	}{
+		{localDate(2018, 3, 15), localDate(2018, 3, 15), []int{0}, 1},
+		{localDate(2018, 3, 1), localDate(2018, 3, 31), []int{0}, 1},
+		{localDate(2018, 3, 16), localDate(2018, 4, 1), []int{1}, 1},
+		{localDate(2018, 3, 14), localDate(2018, 3, 31), []int{0}, 1},
+		{localDate(2018, 4, 2), localDate(2019, 6, 29), nil, 0},
+		{localDate(2018, 1, 1), localDate(2019, 12, 31), []int{0, 1, 2}, 2},
+		{localDate(2018, 3, 15), localDate(2019, 6, 30), []int{0, 1, 2}, 3},
+		{today, today, []int{0, 1, 2}, 1},
+		{today, time.Time{}, []int{0, 1, 2}, 1},
+		{time.Time{}, today.AddDate(0, 0, -1), nil, 0}, // from the day of index creation
+	}
+	for i, test := range tests {
+		plan, keys := explain(t, NewQuery().InDateRange(test.since, test.until).Build())
+		if !equalInts(keys, test.keys) {
+			t.Fatalf("test %d: keys have:%v - expect:%v", i, keys, test.keys)
+		}
+		var arg = fmt.Sprintf("(%d date tags)", test.tags)
+		if step := plan.Steps[0]; step.Op != "days" || !strings.HasSuffix(step.Arg, arg) {
+			t.Fatalf("test %d: plan have:%q - expect: days ... %s", i, planOps(plan), arg)
+		}
+	}
+}
+
+func TestPlannerDateTagged(t *testing.T) {
+	defer testRepo(t)()
+
+	// new repos are dated
+	if dated := loadDateTagged(); dated != time.Unix(0, 1) {
+		t.Fatalf("dated have:%s - expect all objects", dated)
+	}
+
+	// and repos created before the month and year tags are dated by the next
+	// session
+	tagdict, e := loadTagDictionary()
+	if e != nil {
+		t.Fatalf("loadTagDictionary - %v", e)
+	}
+	tagdict.header.dated = 0
+	tagdict.modified = true
+	if _, e := tagdict.Sync(); e != nil {
+		t.Fatalf("Sync - %v", e)
+	}
+	if dated := loadDateTagged(); !dated.IsZero() {
+		t.Fatalf("dated have:%s - expect zero", dated)
+	}
+	var t0 = time.Now()
+	indexTexts(t)
+	if dated := loadDateTagged(); dated.Before(t0) || dated.After(time.Now()) {
+		t.Fatalf("dated have:%s - expect session time", dated)
+	}
+}
@@ -3,6 +3,8 @@
 package index
 
 import (
+	"time"
+
 	"github.com/alphazero/gart/syslib/debug"
 	"github.com/alphazero/gart/system"
 	"github.com/alphazero/gart/system/systemic"
@@ -17,6 +19,8 @@ type QueryBuilder interface {
 	ExcludeType(otype system.Otype) *query
 	WithExtension(ext string) *query
 	ExcludeExtension(ext string) *query
+	Where(qx Query) *query
+	InDateRange(since, until time.Time) *query
 	Build() Query
 }
 
@@ -27,6 +31,8 @@ type Query interface {
 type query struct {
 	include map[string]struct{}
 	exclude map[string]struct{}
+	expr    queryExpr // optional - see ParseQuery
+	days    *dayRange // optional - see InDateRange
 }
 
 func NewQuery() *query {
@@ -47,6 +53,14 @@ func (q *query) Build() Query {
 	for k := range q.exclude {
 		debug.Printf("\t%s", k)
 	}
+	if q.expr != nil {
+		debug.Printf("-- expr --")
+		debug.Printf("\t%s", q.expr)
+	}
+	if q.days != nil {
+		debug.Printf("-- days --")
+		debug.Printf("\t%s", q.days)
+	}
 	return q
 }
 
@@ -68,6 +82,42 @@ func (q *query) ExcludeTags(tags ...string) *query {
 	return q
 }
 
+// Where adds the conditions of the (e.g. parsed) query qx to the query. The
+// query selects objects that match both.
+func (q *query) Where(qx Query) *query {
+	var q0 = qx.asQuery()
+	q.IncludeTags(keys(q0.include)...)
+	q.ExcludeTags(keys(q0.exclude)...)
+	switch {
+	case q0.expr == nil:
+	case q.expr == nil:
+		q.expr = q0.expr
+	default:
+		q.expr = binaryExpr{andOp, []queryExpr{q.expr, q0.expr}}
+	}
+	return q
+}
+
+// InDateRange selects objects that were added to gart on a day in the range
+// since..until, inclusive. Only the date of the given times is significant.
+// A zero since or until time leaves the range open on that end.
+//
+// Whole months and years of the range are selected by the month and year date
+// tags. Objects added by earlier versions of gart only have day tags, and are
+// tagged with month and year tags by Reindex.
+func (q *query) InDateRange(since, until time.Time) *query {
+	q.days = &dayRange{since: truncateDay(since), until: truncateDay(until)}
+	return q
+}
+
+func keys(m map[string]struct{}) []string {
+	var a = make([]string, 0, len(m))
+	for k := range m {
+		a = append(a, k)
+	}
+	return a
+}
+
 // REVU the following are not used -- find uses above directly.
 //
 // 2 concerns:
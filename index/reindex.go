@@ -0,0 +1,292 @@
+// Doost!
+
+package index
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/alphazero/gart/repo"
+	"github.com/alphazero/gart/syslib/bitmap"
+	"github.com/alphazero/gart/syslib/debug"
+	"github.com/alphazero/gart/syslib/errors"
+	"github.com/alphazero/gart/system"
+	"github.com/alphazero/gart/system/systemic"
+)
+
+/// reindex ////////////////////////////////////////////////////////////////////
+
+// Cards are the authoritative record of the tags of objects. Reindex rebuilds
+// all tagmaps from the cards into a fresh tagmaps directory, and the tag
+// dictionary to its swapfile, which are then swapped with the index tagmaps
+// directory and the tag dictionary:
+//
+//	1 - tagmaps are written to the new directory and synced.
+//	2 - the tag dictionary, and migrated cards, are saved to swapfiles.
+//	3 - the journal (of the swapfiles) is written - commit point.
+//	4 - the tagmaps directory is renamed to the old directory.
+//	5 - the new directory is renamed to the tagmaps directory.
+//	6 - the journal is applied and removed.
+//	7 - the old directory is removed.
+//
+// The rebuilt tag dictionary retains the ids of its tags (see
+// buildTagDictionary).
+//
+// Cards of objects added before the month and year date tags were introduced
+// only have day tags. Reindex migrates these cards: the missing month and year
+// tags are added to the card (see missingDateTags), and the tag dictionary is
+// marked dated, i.e. date ranges are queried with month and year tags (see
+// tagdictHeader.dateTagged).
+//
+// If interrupted, recoverReindex (on the next session) completes the swap of
+// directories if the journal exists (i.e. after step 3), and otherwise restores
+// the tagmaps directory if it does not exist. The new and old directories are
+// then removed, and the journal is redone by recoverSession.
+//
+// The paths are functions of repo.IndexPath, as the repo may be relocated
+// after init (see system.SetRepoRoot).
+func reindexNewPath() string { return filepath.Join(repo.IndexPath, ".tagmaps.new") }
+func reindexOldPath() string { return filepath.Join(repo.IndexPath, ".tagmaps.old") }
+
+// ReindexStats are the stats of a Reindex.
+type ReindexStats struct {
+	Cards    int // number of cards
+	Deleted  int // number of deleted cards
+	Tagmaps  int // number of rebuilt tagmaps
+	Migrated int // number of cards tagged with missing date tags
+}
+
+// Reindex rebuilds all tagmaps, including the systemic tagmaps, and the tag
+// dictionary from the cards, and migrates cards with missing date tags. Any
+// interrupted session is first recovered.
+//
+// The repo is exclusively locked for the duration of the call, and Reindex
+// must not be called while an IndexManager is open.
+//
+// Returns nil, error if any card could not be loaded (see Fsck), or on any
+// other error. The existing tagmaps are retained on error.
+func Reindex() (*ReindexStats, error) {
+	var err = errors.For("index.Reindex")
+	var debug = debug.For("index.Reindex")
+
+	lock, e := lockRepo(Write)
+	if e != nil {
+		return nil, e
+	}
+	defer lock.Unlock()
+
+	if e := recoverSession(); e != nil {
+		return nil, err.ErrorWithCause(e, "on recovery")
+	}
+
+	/// prepare /////////////////////////////////////////////////////
+
+	stats, j, e := prepareReindex()
+	if e != nil {
+		return nil, err.ErrorWithCause(e, "on prepare")
+	}
+
+	/// committed - swap ////////////////////////////////////////////
+
+	if e := swapTagmapsDir(); e != nil {
+		return nil, err.ErrorWithCause(e, "on swap") // redone on recovery
+	}
+	if e := j.apply(); e != nil {
+		return nil, err.ErrorWithCause(e, "on journal apply") // redone on recovery
+	}
+	if e := removeJournal(); e != nil {
+		return nil, err.ErrorWithCause(e, "on removeJournal")
+	}
+	var oldPath = reindexOldPath()
+	if e := os.RemoveAll(oldPath); e != nil {
+		return nil, err.ErrorWithCause(e, "on remove %q", oldPath)
+	}
+	debug.Printf("reindexed - cards:%d tagmaps:%d", stats.Cards, stats.Tagmaps)
+
+	return stats, nil
+}
+
+// prepareReindex writes the tagmaps of all cards to the new tagmaps directory,
+// and the tag dictionary and migrated cards to their swapfiles, and commits
+// the journal of the swapfiles (steps 1 to 3 of Reindex). The new directory is
+// removed on error.
+func prepareReindex() (*ReindexStats, *journal, error) {
+	var err = errors.For("index.prepareReindex")
+	var debug = debug.For("index.prepareReindex")
+
+	/// collect the keys of each tag ////////////////////////////////
+
+	var stats = &ReindexStats{}
+	var tagKeys = map[string][]uint{
+		systemic.GartTag(): nil, // always has a tagmap
+	}
+	var migrated = make(map[*system.Oid][]string)
+	if e := walkCards(func(card Card) error {
+		stats.Cards++
+		if card.IsDeleted() {
+			stats.Deleted++
+			return nil
+		}
+		if tags := missingDateTags(card.Tags()); len(tags) > 0 {
+			card.addTag(tags...)
+			if _, e := card.saveWip(); e != nil {
+				return errors.ErrorWithCause(e, "card %s saveWip", card.Oid().Fingerprint())
+			}
+			migrated[card.Oid()] = tags
+		}
+		var key = uint(card.Key())
+		for _, tag := range card.Tags() {
+			tagKeys[tag] = append(tagKeys[tag], key)
+		}
+		return nil
+	}); e != nil {
+		return nil, nil, err.ErrorWithCause(e, "on walkCards")
+	}
+
+	/// write new tagmaps ///////////////////////////////////////////
+
+	var newPath = reindexNewPath()
+	if e := os.RemoveAll(newPath); e != nil {
+		return nil, nil, err.ErrorWithCause(e, "on remove %q", newPath)
+	}
+	for tag, keys := range tagKeys {
+		var wahl = bitmap.NewWahl()
+		if len(keys) > 0 {
+			wahl.Set(keys...) // batched - keys are sorted once
+			wahl.Compress()
+		}
+		rel, e := filepath.Rel(repo.IndexTagmapsPath, TagmapFilename(tag))
+		if e != nil {
+			return nil, nil, err.Bug("tagmap filename - %v", e)
+		}
+		var filename = filepath.Join(newPath, rel)
+		if e := writeTagmap(filename, tag, nil, wahl); e != nil {
+			os.RemoveAll(newPath)
+			return nil, nil, err.ErrorWithCause(e, "tag %q", tag)
+		}
+		if e := syncFile(filename); e != nil {
+			os.RemoveAll(newPath)
+			return nil, nil, err.ErrorWithCause(e, "sync tagmap %q", tag)
+		}
+		stats.Tagmaps++
+	}
+	debug.Printf("built %d tagmaps in %q", stats.Tagmaps, newPath)
+
+	/// tag dictionary swapfile and journal /////////////////////////
+
+	tagdict, e := buildTagDictionary()
+	if e != nil {
+		os.RemoveAll(newPath)
+		return nil, nil, err.ErrorWithCause(e, "on buildTagDictionary")
+	}
+	tagdict.header.dated = 1 // all cards are migrated
+	var j = newJournal()
+	for oid, tags := range migrated {
+		for _, tag := range tags {
+			if e := tagdict.add(tag); e != nil {
+				os.RemoveAll(newPath)
+				return nil, nil, err.ErrorWithCause(e, "on tagdict.add(%q)", tag)
+			}
+		}
+		if e := j.addFile(cardFilename(oid)); e != nil {
+			os.RemoveAll(newPath)
+			return nil, nil, err.ErrorWithCause(e, "on journal.addFile")
+		}
+		stats.Migrated++
+	}
+	if _, e := tagdict.saveWip(); e != nil {
+		os.RemoveAll(newPath)
+		return nil, nil, err.ErrorWithCause(e, "on tagdict.saveWip")
+	}
+	if e := j.addFile(tagdict.source); e != nil {
+		os.RemoveAll(newPath)
+		return nil, nil, err.ErrorWithCause(e, "on journal.addFile")
+	}
+	if e := j.commit(); e != nil {
+		os.RemoveAll(newPath)
+		return nil, nil, err.ErrorWithCause(e, "on journal.commit")
+	}
+	return stats, j, nil
+}
+
+// missingDateTags returns the month and year tags of the day tags that are not
+// in tags.
+func missingDateTags(tags []string) []string {
+	var defined = make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		defined[tag] = true
+	}
+	var missing []string
+	for _, tag := range tags {
+		day, ok := systemic.ParseDayTag(tag)
+		if !ok {
+			continue
+		}
+		for _, tag := range systemic.DateTags(day)[1:] {
+			if !defined[tag] {
+				defined[tag] = true
+				missing = append(missing, tag)
+			}
+		}
+	}
+	return missing
+}
+
+// swapTagmapsDir swaps the new tagmaps directory with the tagmaps directory
+// (steps 4 and 5 of Reindex). The tagmaps directory is renamed to the old
+// directory if it exists, so swapTagmapsDir can complete an interrupted swap.
+func swapTagmapsDir() error {
+	var err = errors.For("index.swapTagmapsDir")
+
+	var newPath, oldPath = reindexNewPath(), reindexOldPath()
+	if _, e := os.Stat(repo.IndexTagmapsPath); e == nil {
+		if e := os.RemoveAll(oldPath); e != nil {
+			return err.ErrorWithCause(e, "on remove %q", oldPath)
+		}
+		if e := os.Rename(repo.IndexTagmapsPath, oldPath); e != nil {
+			return err.ErrorWithCause(e, "on rename tagmaps dir")
+		}
+	}
+	if e := os.Rename(newPath, repo.IndexTagmapsPath); e != nil {
+		return err.ErrorWithCause(e, "on rename new tagmaps dir")
+	}
+	if e := syncFile(repo.IndexPath); e != nil {
+		return err.ErrorWithCause(e, "sync index dir")
+	}
+	return nil
+}
+
+// recoverReindex completes or discards an interrupted Reindex. The journal of a
+// committed Reindex is redone by the caller (see recoverSession). Repo must be
+// locked exclusively.
+func recoverReindex() error {
+	var err = errors.For("index.recoverReindex")
+	var debug = debug.For("index.recoverReindex")
+
+	var _, noNew = os.Stat(reindexNewPath())
+	var _, noJournal = os.Stat(repo.JournalPath)
+	switch {
+	case noNew == nil && noJournal == nil: // committed
+		debug.Printf("complete swap of tagmaps dir")
+		if e := swapTagmapsDir(); e != nil {
+			return err.ErrorWithCause(e, "on swap")
+		}
+	default:
+		if _, e := os.Stat(repo.IndexTagmapsPath); os.IsNotExist(e) {
+			var from = reindexNewPath()
+			if noNew != nil {
+				from = reindexOldPath()
+			}
+			debug.Printf("restore tagmaps dir from %q", from)
+			if e := os.Rename(from, repo.IndexTagmapsPath); e != nil {
+				return err.ErrorWithCause(e, "on rename %q", from)
+			}
+		}
+	}
+	for _, dir := range []string{reindexNewPath(), reindexOldPath()} {
+		if e := os.RemoveAll(dir); e != nil {
+			return err.ErrorWithCause(e, "on remove %q", dir)
+		}
+	}
+	return nil
+}
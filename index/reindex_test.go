@@ -0,0 +1,218 @@
+// Doost!
+
+package index
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alphazero/gart/repo"
+	"github.com/alphazero/gart/syslib/bitmap"
+	"github.com/alphazero/gart/syslib/fs"
+	"github.com/alphazero/gart/system/systemic"
+)
+
+// reindexTestRepo indexes objects with keys 0..2 tagged a, and the tagmap of a
+// is then overwritten with key 3, i.e. the tagmap is out of sync with cards.
+func reindexTestRepo(t *testing.T) func() {
+	var done = testRepo(t)
+	indexTexts(t,
+		testObject{"x", []string{"a"}},
+		testObject{"y", []string{"a", "b"}},
+		testObject{"z", []string{"a"}},
+	)
+	var wahl = bitmap.NewWahl()
+	wahl.Set(3)
+	if e := writeTagmap(TagmapFilename("a"), "a", nil, wahl); e != nil {
+		done()
+		t.Fatalf("writeTagmap - %v", e)
+	}
+	return done
+}
+
+func expectKeysOfA(t *testing.T, expect []int) {
+	if keys := searchKeys(t, NewQuery().IncludeTags("a").Build()); !equalInts(keys, expect) {
+		t.Fatalf("keys of a have:%v - expect:%v", keys, expect)
+	}
+}
+
+func expectNoReindexFiles(t *testing.T) {
+	for _, filename := range []string{
+		reindexNewPath(),
+		reindexOldPath(),
+		repo.JournalPath,
+		fs.SwapfileName(repo.TagDictionaryPath),
+	} {
+		if _, e := os.Stat(filename); !os.IsNotExist(e) {
+			t.Fatalf("%q not removed - %v", filename, e)
+		}
+	}
+}
+
+func TestReindex(t *testing.T) {
+	defer reindexTestRepo(t)()
+
+	expectKeysOfA(t, []int{3})
+	var ids = tagIds(t)
+	stats, e := Reindex()
+	if e != nil {
+		t.Fatalf("Reindex - %v", e)
+	}
+	if stats.Cards != 3 || stats.Deleted != 0 {
+		t.Fatalf("stats have:%+v", stats)
+	}
+	expectKeysOfA(t, []int{0, 1, 2})
+	if have := tagIds(t); fmt.Sprint(have) != fmt.Sprint(ids) {
+		t.Fatalf("tag ids have:%v - expect:%v", have, ids)
+	}
+	expectNoReindexFiles(t)
+	fsckClean(t)
+}
+
+// interrupted after the commit point, between the renames of the directories.
+func TestReindexInterruptedSwap(t *testing.T) {
+	defer reindexTestRepo(t)()
+
+	if _, _, e := prepareReindex(); e != nil {
+		t.Fatalf("prepareReindex - %v", e)
+	}
+	if e := os.Rename(repo.IndexTagmapsPath, reindexOldPath()); e != nil {
+		t.Fatalf("%v", e)
+	}
+	if !interruptedSession() {
+		t.Fatalf("interruptedSession - expected true")
+	}
+
+	// recovered on open of the next session - the swap is completed
+	expectKeysOfA(t, []int{0, 1, 2})
+	expectNoReindexFiles(t)
+	tagdict, e := loadTagDictionary()
+	if e != nil {
+		t.Fatalf("loadTagDictionary - %v", e)
+	}
+	for _, tag := range []string{"a", "b"} {
+		if _, ok := tagdict.tags[tag]; !ok {
+			t.Fatalf("tag dictionary - tag %q not defined", tag)
+		}
+	}
+	fsckClean(t)
+}
+
+// interrupted after the commit point, before the renames of the directories.
+func TestReindexInterruptedCommitted(t *testing.T) {
+	defer reindexTestRepo(t)()
+
+	if _, _, e := prepareReindex(); e != nil {
+		t.Fatalf("prepareReindex - %v", e)
+	}
+	expectKeysOfA(t, []int{0, 1, 2})
+	expectNoReindexFiles(t)
+	fsckClean(t)
+}
+
+// interrupted before the commit point.
+func TestReindexInterruptedUncommitted(t *testing.T) {
+	defer reindexTestRepo(t)()
+
+	if _, _, e := prepareReindex(); e != nil {
+		t.Fatalf("prepareReindex - %v", e)
+	}
+	if e := removeJournal(); e != nil {
+		t.Fatalf("removeJournal - %v", e)
+	}
+
+	// the reindex is discarded
+	if e := recoverSession(); e != nil {
+		t.Fatalf("recoverSession - %v", e)
+	}
+	if _, e := os.Stat(reindexNewPath()); !os.IsNotExist(e) {
+		t.Fatalf("new tagmaps dir not removed - %v", e)
+	}
+	expectKeysOfA(t, []int{3})
+}
+
+// objects added before the month and year date tags were introduced.
+func TestReindexMigrateDateTags(t *testing.T) {
+	defer testRepo(t)()
+
+	oids := indexTexts(t, testObject{"old", []string{"a"}})
+	var today = time.Now()
+	var rollups = systemic.DateTags(today)[1:]
+	idx, e := OpenIndexManager(Write)
+	if e != nil {
+		t.Fatalf("OpenIndexManager - %v", e)
+	}
+	if removed, e := idx.RemoveTags(oids[0], rollups...); e != nil || len(removed) != 2 {
+		idx.Rollback()
+		t.Fatalf("RemoveTags - removed:%q e:%v", removed, e)
+	}
+	if e := idx.Close(true); e != nil {
+		t.Fatalf("Close - %v", e)
+	}
+
+	// the repo is not dated, as if created before the month and year tags
+	tagdict, e := loadTagDictionary()
+	if e != nil {
+		t.Fatalf("loadTagDictionary - %v", e)
+	}
+	tagdict.header.dated = 0
+	tagdict.modified = true
+	if _, e := tagdict.Sync(); e != nil {
+		t.Fatalf("Sync - %v", e)
+	}
+
+	// this month is queried with day tags
+	var y, m, _ = today.Date()
+	var q = NewQuery().InDateRange(localDate(y, m, 1), localDate(y, m+1, 0)).Build()
+	var dateTags = func() []string {
+		idx, e := OpenIndexManager(Read)
+		if e != nil {
+			t.Fatalf("OpenIndexManager - %v", e)
+		}
+		defer idx.Close(false)
+		tags, e := newPlanner(idx.(*indexManager), false).dateTags(q.asQuery().days)
+		if e != nil {
+			t.Fatalf("dateTags - %v", e)
+		}
+		return tags
+	}
+	if keys := searchKeys(t, q); !equalInts(keys, []int{0}) {
+		t.Fatalf("keys before migration have:%v - expect:[0]", keys)
+	}
+	if tags := dateTags(); len(tags) != 1 || tags[0] != systemic.DayTag(today) {
+		t.Fatalf("date tags have:%q - expect:%q", tags, systemic.DayTag(today))
+	}
+
+	stats, e := Reindex()
+	if e != nil {
+		t.Fatalf("Reindex - %v", e)
+	}
+	if stats.Migrated != 1 {
+		t.Fatalf("stats have:%+v - expect migrated:1", stats)
+	}
+	if dated := loadDateTagged(); dated != time.Unix(0, 1) {
+		t.Fatalf("dated have:%s - expect all objects", dated)
+	}
+	// and with the month tag
+	if keys := searchKeys(t, q); !equalInts(keys, []int{0}) {
+		t.Fatalf("keys after migration have:%v - expect:[0]", keys)
+	}
+	if tags := dateTags(); len(tags) != 1 || tags[0] != systemic.MonthTag(today) {
+		t.Fatalf("date tags have:%q - expect:%q", tags, systemic.MonthTag(today))
+	}
+	card, e := LoadCard(oids[0])
+	if e != nil {
+		t.Fatalf("LoadCard - %v", e)
+	}
+	if missing := missingDateTags(card.Tags()); len(missing) != 0 {
+		t.Fatalf("card tags have:%q - missing:%q", card.Tags(), missing)
+	}
+	expectNoReindexFiles(t)
+	fsckClean(t)
+
+	if stats, e = Reindex(); e != nil || stats.Migrated != 0 {
+		t.Fatalf("Reindex - stats:%+v e:%v", stats, e)
+	}
+}
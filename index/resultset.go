@@ -0,0 +1,128 @@
+// Doost!
+
+package index
+
+import (
+	"github.com/alphazero/gart/syslib/bitmap"
+	"github.com/alphazero/gart/syslib/errors"
+	"github.com/alphazero/gart/system"
+)
+
+/// ResultSet //////////////////////////////////////////////////////////////////
+
+// ResultSet is the set of objects selected by a query. It wraps the final
+// query bitmap of object keys, and Oids are only resolved (from the objects
+// index) as they are paged or iterated. A ResultSet is only valid for the
+// lifetime of the IndexManager (session) that returned it.
+type ResultSet interface {
+	// Returns the number of selected objects.
+	Count() int
+	// Returns the oids of at most n objects, starting from offset, in
+	// object key order. Returns an empty slice if offset >= Count().
+	Page(offset, n int) ([]*system.Oid, error)
+	// Returns an iterator over the oids of the selected objects.
+	Iterator() *ResultIterator
+
+	// Set operations return a new ResultSet. The result sets must be from
+	// the same IndexManager.
+	And(other ResultSet) (ResultSet, error)
+	Or(other ResultSet) (ResultSet, error)
+	AndNot(other ResultSet) (ResultSet, error)
+}
+
+type resultSet struct {
+	oidx   *oidxFile
+	bitmap *bitmap.Wahl
+	count  int // -1 until computed
+}
+
+func newResultSet(oidx *oidxFile, wahl *bitmap.Wahl) *resultSet {
+	return &resultSet{oidx, wahl, -1}
+}
+
+func (rs *resultSet) Count() int {
+	if rs.count < 0 {
+		rs.count = rs.bitmap.Count()
+	}
+	return rs.count
+}
+
+func (rs *resultSet) Page(offset, n int) ([]*system.Oid, error) {
+	var err = errors.For("resultSet.Page")
+	if offset < 0 || n < 0 {
+		return nil, err.InvalidArg("offset:%d n:%d", offset, n)
+	}
+	var it = rs.Iterator()
+	it.keys.Skip(offset)
+
+	var oids = []*system.Oid{}
+	for len(oids) < n {
+		oid, ok, e := it.Next()
+		if e != nil {
+			return nil, e
+		}
+		if !ok {
+			break
+		}
+		oids = append(oids, oid)
+	}
+	return oids, nil
+}
+
+func (rs *resultSet) Iterator() *ResultIterator {
+	return &ResultIterator{rs.oidx, rs.bitmap.Iterator()}
+}
+
+func (rs *resultSet) And(other ResultSet) (ResultSet, error) {
+	return rs.bitwise("And", other, (*bitmap.Wahl).And)
+}
+
+func (rs *resultSet) Or(other ResultSet) (ResultSet, error) {
+	return rs.bitwise("Or", other, (*bitmap.Wahl).Or)
+}
+
+func (rs *resultSet) AndNot(other ResultSet) (ResultSet, error) {
+	return rs.bitwise("AndNot", other, (*bitmap.Wahl).AndNot)
+}
+
+func (rs *resultSet) bitwise(opname string, other ResultSet,
+	op func(*bitmap.Wahl, *bitmap.Wahl) (*bitmap.Wahl, error)) (ResultSet, error) {
+
+	var err = errors.For("resultSet." + opname)
+
+	x, ok := other.(*resultSet)
+	if !ok || x == nil {
+		return nil, err.InvalidArg("other is not a valid ResultSet")
+	}
+	if x.oidx != rs.oidx {
+		return nil, err.InvalidArg("other is not from the same index manager")
+	}
+	wahl, e := op(rs.bitmap, x.bitmap)
+	if e != nil {
+		return nil, err.ErrorWithCause(e, "on bitmap op")
+	}
+	return newResultSet(rs.oidx, wahl), nil
+}
+
+/// ResultIterator /////////////////////////////////////////////////////////////
+
+// ResultIterator iterates over the oids of a ResultSet in object key order.
+type ResultIterator struct {
+	oidx *oidxFile
+	keys *bitmap.BitIterator
+}
+
+// Next returns the next oid, and false if there are no more objects.
+//
+// Returns nil, false, error if the oid could not be resolved.
+func (it *ResultIterator) Next() (*system.Oid, bool, error) {
+	key, ok := it.keys.Next()
+	if !ok {
+		return nil, false, nil
+	}
+	oid, e := it.oidx.getOid(key)
+	if e != nil {
+		return nil, false, e
+	}
+	return oid, true, nil
+}
@@ -0,0 +1,142 @@
+// Doost!
+
+package index
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/alphazero/gart/syslib/bitmap"
+	"github.com/alphazero/gart/system"
+)
+
+func TestResultSet(t *testing.T) {
+	defer testRepo(t)()
+
+	// keys 0..7 - even keys are tagged 'even' and keys 0..3 are tagged 'low'
+	var objects = make([]testObject, 8)
+	for key := range objects {
+		var tags []string
+		if key%2 == 0 {
+			tags = append(tags, "even")
+		}
+		if key < 4 {
+			tags = append(tags, "low")
+		}
+		objects[key] = testObject{fmt.Sprintf("o%d", key), tags}
+	}
+	oids := indexTexts(t, objects...)
+	// keysOf returns the keys of the oids.
+	var keysOf = func(selected []*system.Oid) []int {
+		var keys = []int{}
+		for _, oid := range selected {
+			for key := range oids {
+				if oid.String() == oids[key].String() {
+					keys = append(keys, key)
+				}
+			}
+		}
+		return keys
+	}
+
+	idx, e := OpenIndexManager(Read)
+	if e != nil {
+		t.Fatalf("OpenIndexManager - %v", e)
+	}
+	defer idx.Close(false)
+
+	var search = func(tag string) ResultSet {
+		rs, e := idx.Search(NewQuery().IncludeTags(tag).Build())
+		if e != nil {
+			t.Fatalf("Search(%q) - %v", tag, e)
+		}
+		return rs
+	}
+	even, low := search("even"), search("low")
+
+	// count is computed once
+	if count := even.(*resultSet).count; count != -1 {
+		t.Fatalf("count before Count have:%d - expect:-1", count)
+	}
+	if count := even.Count(); count != 4 {
+		t.Fatalf("Count have:%d - expect:4", count)
+	}
+	if count := even.(*resultSet).count; count != 4 {
+		t.Fatalf("count after Count have:%d - expect:4", count)
+	}
+
+	for _, test := range []struct {
+		offset, n int
+		expect    []int
+	}{
+		{0, 3, []int{0, 2, 4}},
+		{3, 3, []int{6}}, // last partial page
+		{0, 4, []int{0, 2, 4, 6}},
+		{1, 2, []int{2, 4}},
+		{0, 0, []int{}},
+		{4, 3, []int{}}, // offset at end
+		{100, 1, []int{}},
+	} {
+		page, e := even.Page(test.offset, test.n)
+		if e != nil {
+			t.Fatalf("Page(%d, %d) - %v", test.offset, test.n, e)
+		}
+		if have := keysOf(page); !equalInts(have, test.expect) {
+			t.Fatalf("Page(%d, %d) have:%v - expect:%v", test.offset, test.n, have, test.expect)
+		}
+	}
+	for _, args := range [][2]int{{-1, 1}, {0, -1}} {
+		if _, e := even.Page(args[0], args[1]); e == nil {
+			t.Fatalf("Page(%d, %d) - expected error", args[0], args[1])
+		}
+	}
+
+	var it = even.Iterator()
+	var iterated []*system.Oid
+	for {
+		oid, ok, e := it.Next()
+		if e != nil {
+			t.Fatalf("Next - %v", e)
+		}
+		if !ok {
+			break
+		}
+		iterated = append(iterated, oid)
+	}
+	if have := keysOf(iterated); !equalInts(have, []int{0, 2, 4, 6}) {
+		t.Fatalf("Iterator have:%v - expect:[0 2 4 6]", have)
+	}
+
+	type setOp func(ResultSet) (ResultSet, error)
+	for name, test := range map[string]struct {
+		op     setOp
+		expect []int
+	}{
+		"And":    {even.And, []int{0, 2}},
+		"Or":     {even.Or, []int{0, 1, 2, 3, 4, 6}},
+		"AndNot": {even.AndNot, []int{4, 6}},
+	} {
+		rs, e := test.op(low)
+		if e != nil {
+			t.Fatalf("%s - %v", name, e)
+		}
+		page, e := rs.Page(0, rs.Count())
+		if e != nil {
+			t.Fatalf("%s - Page - %v", name, e)
+		}
+		if have := keysOf(page); !equalInts(have, test.expect) || rs.Count() != len(test.expect) {
+			t.Fatalf("%s have:%v count:%d - expect:%v", name, have, rs.Count(), test.expect)
+		}
+		// the operands are not modified
+		if even.Count() != 4 || low.Count() != 4 {
+			t.Fatalf("%s - operand counts have:%d %d - expect:4 4", name, even.Count(), low.Count())
+		}
+		// result sets must be from the same index manager
+		if _, e := test.op(nil); e == nil {
+			t.Fatalf("%s(nil) - expected error", name)
+		}
+		if _, e := test.op(newResultSet(&oidxFile{}, bitmap.NewWahl())); e == nil {
+			t.Fatalf("%s(other index manager) - expected error", name)
+		}
+	}
+}
@@ -0,0 +1,607 @@
+// Doost!
+
+package index
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/alphazero/gart/repo"
+	"github.com/alphazero/gart/syslib/debug"
+	"github.com/alphazero/gart/syslib/digest"
+	"github.com/alphazero/gart/syslib/errors"
+	"github.com/alphazero/gart/syslib/fs"
+	"github.com/alphazero/gart/system"
+)
+
+// tagdict.dat file is the persistent dictionary of all tags (including the
+// systemic tags) applied to objects. The dictionary assigns stable, non-zero,
+// integer ids to tags and records the number of objects tagged with each tag.
+// Tagmap files are named using the hash of the tag name, so the dictionary is
+// also the only means of mapping a tagmap file back to its tag.
+//
+// The dictionary is small relative to the index and is loaded in full. On
+// commit of a session that modified it, it is rewritten via a swapfile.
+
+/// consts and vars ///////////////////////////////////////////////////////////
+
+const (
+	mmap_tagdict_ftype uint64 = 0x3c9a71d5e2b80f46
+)
+
+const (
+	tagdictHeaderSize = 64
+	tagRecordHdrSize  = 25 // id, refcnt, hash, name-len
+)
+
+/// tagdict.dat file header ///////////////////////////////////////////////////
+
+// tagdict.dat header is the minimal content of a valid tag dictionary file.
+// The crc64 field is the checksum of the header and all tag records.
+type tagdictHeader struct {
+	ftype   uint64
+	crc64   uint64 // header and records crc
+	created int64
+	updated int64
+	tcnt    int64 // tag count
+	nextId  int64 // id of the next added tag - 1..n
+	dlen    int64 // length of tag records in bytes
+	dated   int64 // see dateTagged
+}
+
+func (h *tagdictHeader) Print(w io.Writer) {
+	fmt.Fprintf(w, "file type:  %016x\n", h.ftype)
+	fmt.Fprintf(w, "crc64:      %016x\n", h.crc64)
+	fmt.Fprintf(w, "created:    %016x (%s)\n", h.created, time.Unix(0, h.created))
+	fmt.Fprintf(w, "updated:    %016x (%s)\n", h.updated, time.Unix(0, h.updated))
+	fmt.Fprintf(w, "tag cnt:    %d\n", h.tcnt)
+	fmt.Fprintf(w, "next id:    %d\n", h.nextId)
+	fmt.Fprintf(w, "data-len:   %d\n", h.dlen)
+	fmt.Fprintf(w, "dated:      %016x (%s)\n", h.dated, h.dateTagged())
+}
+
+// dateTagged returns the time since which all added objects are tagged with
+// the month and year date tags, or the zero time if not known. Objects added
+// before month and year tags were introduced only have day tags, and a zero
+// dated field is set on the next session (see OpenIndexManager). All objects
+// have the month and year tags once migrated by Reindex (dated is 1).
+func (h *tagdictHeader) dateTagged() time.Time {
+	if h.dated == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, h.dated)
+}
+
+// NOTE encode is written with mmap in mind. It is assumed that the buffer
+// is the full file and that the tag records are already encoded.
+func (h *tagdictHeader) encode(buf []byte) error {
+	if len(buf) < tagdictHeaderSize {
+		return errors.Error("tagdictHeader.encode: insufficient buffer length: %d", len(buf))
+	}
+	*(*uint64)(unsafe.Pointer(&buf[0])) = h.ftype
+	*(*int64)(unsafe.Pointer(&buf[16])) = h.created
+	*(*int64)(unsafe.Pointer(&buf[24])) = h.updated
+	*(*int64)(unsafe.Pointer(&buf[32])) = h.tcnt
+	*(*int64)(unsafe.Pointer(&buf[40])) = h.nextId
+	*(*int64)(unsafe.Pointer(&buf[48])) = h.dlen
+	*(*int64)(unsafe.Pointer(&buf[56])) = h.dated
+
+	h.crc64 = digest.Checksum64(buf[16:])
+	*(*uint64)(unsafe.Pointer(&buf[8])) = h.crc64
+	return nil
+}
+
+// NOTE decode is written with mmap in mind. It is assumed that the buffer
+// is the full file.
+func (h *tagdictHeader) decode(buf []byte) error {
+	var err = errors.For("tagdictHeader.decode")
+	if len(buf) < tagdictHeaderSize {
+		return err.InvalidArg("len(buf):%d < %d", len(buf), tagdictHeaderSize)
+	}
+	*h = *(*tagdictHeader)(unsafe.Pointer(&buf[0]))
+
+	/// verify //////////////////////////////////////////////////////
+
+	if h.ftype != mmap_tagdict_ftype {
+		return err.Bug("ftype:%x - expect: %x", h.ftype, mmap_tagdict_ftype)
+	}
+	crc64 := digest.Checksum64(buf[16:])
+	if crc64 != h.crc64 {
+		return err.Bug("checksum:%x - expect: %x", h.crc64, crc64)
+	}
+	if h.created == 0 {
+		return err.Bug("created:%d", h.created)
+	}
+	if h.updated < h.created {
+		return err.Bug("updated: %d < created:%d", h.updated, h.created)
+	}
+	if int64(len(buf)) != tagdictHeaderSize+h.dlen {
+		return err.Bug("data-len:%d - file-len:%d", h.dlen, len(buf))
+	}
+	return nil
+}
+
+/// tag ////////////////////////////////////////////////////////////////////////
+
+// tagEntry is the dictionary entry of a tag and supports system.Tag.
+type tagEntry struct {
+	name   string
+	id     int
+	refcnt int
+	hash   uint64 // tagmap file hash
+}
+
+func (t *tagEntry) Name() string { return t.name }
+func (t *tagEntry) Id() int      { return t.id }
+func (t *tagEntry) Refcnt() int  { return t.refcnt }
+
+// tag record:
+// len: 8    8        8      1          n
+// fld: id / refcnt / hash / name-len / name
+func (t *tagEntry) encode(buf []byte) int {
+	*(*int64)(unsafe.Pointer(&buf[0])) = int64(t.id)
+	*(*int64)(unsafe.Pointer(&buf[8])) = int64(t.refcnt)
+	*(*uint64)(unsafe.Pointer(&buf[16])) = t.hash
+	buf[24] = byte(len(t.name))
+	copy(buf[tagRecordHdrSize:], []byte(t.name))
+	return tagRecordHdrSize + len(t.name)
+}
+
+// REVU copies the bytes so it is safe with mmap.
+func (t *tagEntry) decode(buf []byte) (int, error) {
+	if len(buf) < tagRecordHdrSize {
+		return 0, errors.Bug("tagEntry.decode: len(buf):%d", len(buf))
+	}
+	t.id = int(*(*int64)(unsafe.Pointer(&buf[0])))
+	t.refcnt = int(*(*int64)(unsafe.Pointer(&buf[8])))
+	t.hash = *(*uint64)(unsafe.Pointer(&buf[16]))
+	var n = tagRecordHdrSize + int(buf[24])
+	if len(buf) < n {
+		return 0, errors.Bug("tagEntry.decode: len(buf):%d < record-len:%d", len(buf), n)
+	}
+	t.name = string(buf[tagRecordHdrSize:n])
+	return n, nil
+}
+
+func (t *tagEntry) recordSize() int { return tagRecordHdrSize + len(t.name) }
+
+/// tag dictionary /////////////////////////////////////////////////////////////
+
+// tagDictionary is the in-mem model of the tagdict.dat file and supports
+// system.TagManager. Tag names are case-insensitive and are always converted
+// to lower-case form.
+type tagDictionary struct {
+	header   *tagdictHeader
+	source   string
+	tags     map[string]*tagEntry // by tag name
+	hashes   map[uint64]*tagEntry // by tagmap file hash
+	modified bool
+}
+
+func newTagDictionary(header *tagdictHeader) *tagDictionary {
+	return &tagDictionary{
+		header: header,
+		source: repo.TagDictionaryPath,
+		tags:   make(map[string]*tagEntry),
+		hashes: make(map[uint64]*tagEntry),
+	}
+}
+
+func (d *tagDictionary) Print(w io.Writer) {
+	d.header.Print(w)
+	fmt.Fprintf(w, "---------------------\n")
+	fmt.Fprintf(w, "source:     %q\n", d.source)
+	fmt.Fprintf(w, "modified:   %t\n", d.modified)
+	for _, tag := range d.Tags() {
+		fmt.Fprintf(w, "\ttag[%d] refcnt:%d %q\n", tag.Id(), tag.Refcnt(), tag.Name())
+	}
+}
+
+// createTagDictionary creates the initial (header only/empty) tagdict.dat
+// file. The file is closed on return.
+func createTagDictionary() error {
+	var err = errors.For("index.createTagDictionary")
+
+	file, e := fs.OpenNewFile(repo.TagDictionaryPath, os.O_WRONLY|os.O_APPEND)
+	if e != nil {
+		return e
+	}
+	defer file.Close()
+
+	var now = time.Now().UnixNano()
+	var header = &tagdictHeader{
+		ftype:   mmap_tagdict_ftype,
+		created: now,
+		updated: now,
+		nextId:  1,
+		dated:   1, // no objects
+	}
+	var buf [tagdictHeaderSize]byte
+	if e := header.encode(buf[:]); e != nil {
+		return err.ErrorWithCause(e, "header.encode")
+	}
+	if _, e := file.Write(buf[:]); e != nil {
+		return err.ErrorWithCause(e, "on file.Write")
+	}
+	return nil
+}
+
+// loadTagDictionary loads the tag dictionary from file and closes the file.
+// If the file does not exist (e.g. repos created before the tag dictionary
+// was introduced), the dictionary is built from the cards. A built dictionary
+// is marked modified and is saved on next Sync.
+func loadTagDictionary() (*tagDictionary, error) {
+	var err = errors.For("index.loadTagDictionary")
+	var debug = debug.For("index.loadTagDictionary")
+
+	file, e := os.OpenFile(repo.TagDictionaryPath, os.O_RDONLY, repo.FilePerm)
+	if e != nil {
+		if os.IsNotExist(e) {
+			debug.Printf("%q does not exist - building from cards", repo.TagDictionaryPath)
+			return buildTagDictionary()
+		}
+		return nil, e
+	}
+	defer file.Close()
+
+	finfo, e := file.Stat()
+	if e != nil {
+		return nil, e
+	}
+
+	var fd = int(file.Fd())
+	buf, e := syscall.Mmap(fd, 0, int(finfo.Size()), syscall.PROT_READ, syscall.MAP_PRIVATE)
+	if e != nil {
+		return nil, err.ErrorWithCause(e, "on mmap")
+	}
+	defer syscall.Munmap(buf)
+
+	var header tagdictHeader
+	if e := header.decode(buf); e != nil {
+		return nil, err.ErrorWithCause(e, "header.decode")
+	}
+
+	var d = newTagDictionary(&header)
+	var xof = tagdictHeaderSize
+	for i := int64(0); i < header.tcnt; i++ {
+		var tag = &tagEntry{}
+		n, e := tag.decode(buf[xof:])
+		if e != nil {
+			return nil, err.ErrorWithCause(e, "tag record %d", i)
+		}
+		d.tags[tag.name] = tag
+		d.hashes[tag.hash] = tag
+		xof += n
+	}
+	if xof != len(buf) {
+		return nil, err.Bug("decoded len:%d - file-len:%d", xof, len(buf))
+	}
+
+	return d, nil
+}
+
+// buildTagDictionary builds the tag dictionary from the tags of all non-deleted
+// cards. Tag ids are stable: the tags of the existing dictionary file (see
+// salvageTags) retain their ids, and their refcnts are recomputed. Tags no
+// longer applied to any card are retained with a zero refcnt. Only tags not
+// defined in the file are assigned new ids. The dated field of the file, if
+// any, is retained.
+func buildTagDictionary() (*tagDictionary, error) {
+	var err = errors.For("index.buildTagDictionary")
+	var debug = debug.For("index.buildTagDictionary")
+
+	var now = time.Now().UnixNano()
+	var d = newTagDictionary(&tagdictHeader{
+		ftype:   mmap_tagdict_ftype,
+		created: now,
+		updated: now,
+		nextId:  1,
+	})
+	tags, nextId, dated := salvageTags(d.source)
+	d.header.dated = dated
+	for _, tag := range tags {
+		d.tags[tag.name] = tag
+		d.hashes[tag.hash] = tag
+		d.header.tcnt++
+		d.header.dlen += int64(tag.recordSize())
+	}
+	if nextId > d.header.nextId {
+		d.header.nextId = nextId
+	}
+	debug.Printf("retained %d tag ids - next id:%d", len(tags), d.header.nextId)
+
+	if e := walkCards(func(card Card) error {
+		if card.IsDeleted() {
+			return nil
+		}
+		for _, tag := range card.Tags() {
+			if e := d.add(tag); e != nil {
+				return e
+			}
+		}
+		return nil
+	}); e != nil {
+		return nil, err.ErrorWithCause(e, "on walkCards")
+	}
+	d.modified = true
+
+	return d, nil
+}
+
+// salvageTags returns the tags, with zero refcnts, of the intact records of the
+// tag dictionary file, and the next id of its header, regardless of the file
+// checksum, and the dated field of its header, if the file is not corrupt. Decoding stops at the first record that is
+// not intact, i.e. its hash is not the hash of its name, or its id or name is
+// not unique.
+//
+// Returns nil, 0, 0 if the file does not exist or can not be read.
+func salvageTags(filename string) ([]*tagEntry, int64, int64) {
+	buf, e := ioutil.ReadFile(filename)
+	if e != nil || len(buf) < tagdictHeaderSize {
+		return nil, 0, 0
+	}
+	if *(*uint64)(unsafe.Pointer(&buf[0])) != mmap_tagdict_ftype {
+		return nil, 0, 0
+	}
+	var nextId = *(*int64)(unsafe.Pointer(&buf[40]))
+	var dated int64 // unless verified
+	var header tagdictHeader
+	if header.decode(buf) == nil {
+		dated = header.dated
+	}
+
+	var tags []*tagEntry
+	var ids = make(map[int]bool)
+	var names = make(map[string]bool)
+	for xof := tagdictHeaderSize; xof < len(buf); {
+		var tag = &tagEntry{}
+		n, e := tag.decode(buf[xof:])
+		if e != nil || tag.id < 1 || ids[tag.id] || names[tag.name] ||
+			len(tag.name) == 0 || tag.hash != tagmapHash(tag.name) {
+			break
+		}
+		ids[tag.id], names[tag.name] = true, true
+		if int64(tag.id) >= nextId {
+			nextId = int64(tag.id) + 1
+		}
+		tag.refcnt = 0
+		tags = append(tags, tag)
+		xof += n
+	}
+	return tags, nextId, dated
+}
+
+// loadDateTagged returns the dateTagged time of the tag dictionary file (see
+// tagdictHeader.dateTagged), or the zero time if the file does not exist or
+// is corrupt.
+func loadDateTagged() time.Time {
+	var header tagdictHeader
+	buf, e := ioutil.ReadFile(repo.TagDictionaryPath)
+	if e != nil || header.decode(buf) != nil {
+		return time.Time{}
+	}
+	return header.dateTagged()
+}
+
+/// system.TagManager support //////////////////////////////////////////////////
+
+var _ system.TagManager = (*tagDictionary)(nil)
+
+func (d *tagDictionary) Size() int { return len(d.tags) }
+
+func (d *tagDictionary) Add(name string) (bool, int, error) {
+	name = strings.ToLower(name)
+	if len(name) == 0 || len(name) > system.MaxTagNameSize {
+		return false, 0, errors.ErrInvalidArg
+	}
+	if tag, ok := d.tags[name]; ok {
+		return false, tag.id, nil
+	}
+	var tag = &tagEntry{
+		name: name,
+		id:   int(d.header.nextId),
+		hash: tagmapHash(name),
+	}
+	d.header.nextId++
+	d.header.tcnt++
+	d.header.dlen += int64(tag.recordSize())
+	d.tags[name] = tag
+	d.hashes[tag.hash] = tag
+	d.modified = true
+
+	return true, tag.id, nil
+}
+
+func (d *tagDictionary) IncrRefcnt(name string) (int, int, error) {
+	tag, ok := d.tags[strings.ToLower(name)]
+	if !ok {
+		return 0, 0, system.ErrTagNotFound
+	}
+	tag.refcnt++
+	d.modified = true
+	return tag.refcnt, tag.id, nil
+}
+
+func (d *tagDictionary) DecrRefcnt(name string) (int, int, error) {
+	tag, ok := d.tags[strings.ToLower(name)]
+	if !ok {
+		return 0, 0, system.ErrTagNotFound
+	}
+	if tag.refcnt == 0 {
+		return 0, tag.id, errors.Bug("tagDictionary.DecrRefcnt: tag %q refcnt is 0", name)
+	}
+	tag.refcnt--
+	d.modified = true
+	return tag.refcnt, tag.id, nil
+}
+
+func (d *tagDictionary) SelectTags(names []string) ([]int, []string) {
+	var ids []int
+	var notDefined = []string{}
+	for _, name := range names {
+		tag, ok := d.tags[strings.ToLower(name)]
+		if !ok {
+			notDefined = append(notDefined, name)
+			continue
+		}
+		ids = append(ids, tag.id)
+	}
+	return ids, notDefined
+}
+
+// Returns all tags, sorted by name.
+func (d *tagDictionary) Tags() []system.Tag {
+	var tags = make([]system.Tag, 0, len(d.tags))
+	for _, tag := range d.tags {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name() < tags[j].Name() })
+	return tags
+}
+
+// Sync saves the tag dictionary, if modified, to a swapfile and then swaps
+// it with the source file.
+//
+// Function returns a bool indicating if IO was performed, and, errors if any.
+func (d *tagDictionary) Sync() (bool, error) {
+	var err = errors.For("tagDictionary.Sync")
+
+	if ok, e := d.saveWip(); e != nil || !ok {
+		return false, e
+	}
+	var swapfile = fs.SwapfileName(d.source)
+	if e := os.Rename(swapfile, d.source); e != nil {
+		return false, err.ErrorWithCause(e, "os.Rename %q %q", swapfile, d.source)
+	}
+	d.modified = false
+
+	return true, nil
+}
+
+// saveWip saves the tag dictionary, if modified, to its swapfile. (Index
+// sessions swap it on commit of the session journal.)
+//
+// Function returns a bool indicating if IO was performed, and, errors if any.
+func (d *tagDictionary) saveWip() (bool, error) {
+	var err = errors.For("tagDictionary.saveWip")
+
+	if !d.modified {
+		return false, nil
+	}
+	d.header.updated = time.Now().UnixNano()
+
+	// if dir structure does not exist, create it.
+	if e := os.MkdirAll(filepath.Dir(d.source), repo.DirPerm); e != nil {
+		return false, err.ErrorWithCause(e, "dir:%q", filepath.Dir(d.source))
+	}
+
+	var size = int64(tagdictHeaderSize) + d.header.dlen
+	var swapfile = fs.SwapfileName(d.source)
+	sfile, _, e := fs.OpenNewSwapfile(swapfile, false)
+	if e != nil {
+		return false, err.ErrorWithCause(e, "swapfile %q open-new", swapfile)
+	}
+	defer sfile.Close()
+	if e := sfile.Truncate(size); e != nil {
+		return false, err.ErrorWithCause(e, "swapfile truncate")
+	}
+
+	var fd = int(sfile.Fd())
+	buf, e := syscall.Mmap(fd, 0, int(size), syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if e != nil {
+		return false, err.ErrorWithCause(e, "swapfile mmap")
+	}
+
+	// encode records first (in id order) and then the header (cf. header crc64)
+	var tags = make([]*tagEntry, 0, len(d.tags))
+	for _, tag := range d.tags {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].id < tags[j].id })
+	var xof = tagdictHeaderSize
+	for _, tag := range tags {
+		xof += tag.encode(buf[xof:])
+	}
+	if e := d.header.encode(buf); e != nil {
+		syscall.Munmap(buf)
+		return false, err.ErrorWithCause(e, "header.encode")
+	}
+	if e := syscall.Munmap(buf); e != nil {
+		return false, err.ErrorWithCause(e, "unmap")
+	}
+
+	return true, nil
+}
+
+/// index support ////////////////////////////////////////////////////////////
+
+// add adds the tag, if not defined, and increments its refcnt.
+func (d *tagDictionary) add(tag string) error {
+	if d == nil {
+		return errors.Bug("tagDictionary.add: tag dictionary is nil")
+	}
+	if _, _, e := d.Add(tag); e != nil {
+		return e
+	}
+	_, _, e := d.IncrRefcnt(tag)
+	return e
+}
+
+// removeUnused removes the tags with a zero refcnt. The ids of removed tags are
+// not reused. Returns the removed tags.
+func (d *tagDictionary) removeUnused() []string {
+	var removed []string
+	for name, tag := range d.tags {
+		if tag.refcnt > 0 {
+			continue
+		}
+		delete(d.tags, name)
+		delete(d.hashes, tag.hash)
+		d.header.tcnt--
+		d.header.dlen -= int64(tag.recordSize())
+		d.modified = true
+		removed = append(removed, name)
+	}
+	return removed
+}
+
+// remove decrements the refcnt of the tag. Tags not defined in the dictionary
+// are ignored.
+func (d *tagDictionary) remove(tag string) error {
+	if d == nil {
+		return errors.Bug("tagDictionary.remove: tag dictionary is nil")
+	}
+	if _, _, e := d.DecrRefcnt(tag); e != nil && e != system.ErrTagNotFound {
+		return e
+	}
+	return nil
+}
+
+/// tagmap file mapping ////////////////////////////////////////////////////////
+
+// TagmapTag returns the tag name of the given tagmap file (see
+// TagmapFilename).
+//
+// Returns tag, true if the tag is defined, or "", false otherwise.
+func (d *tagDictionary) TagmapTag(filename string) (string, bool) {
+	var dir = filepath.Base(filepath.Dir(filename))
+	var base = strings.TrimSuffix(filepath.Base(filename), ".bitmap")
+	hash, e := strconv.ParseUint(dir+base, 16, 64)
+	if e != nil {
+		return "", false
+	}
+	tag, ok := d.hashes[hash]
+	if !ok {
+		return "", false
+	}
+	return tag.name, true
+}
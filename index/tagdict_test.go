@@ -0,0 +1,214 @@
+// Doost!
+
+package index
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/alphazero/gart/repo"
+	"github.com/alphazero/gart/system"
+	"github.com/alphazero/gart/system/systemic"
+)
+
+// tagRefcnts returns the refcnts of the user tags of the tag dictionary by name.
+func tagRefcnts(t *testing.T) map[string]int {
+	tagdict, e := loadTagDictionary()
+	if e != nil {
+		t.Fatalf("loadTagDictionary - %v", e)
+	}
+	var refcnts = make(map[string]int)
+	for _, tag := range tagdict.Tags() {
+		if !systemic.IsSystemic(tag.Name()) {
+			refcnts[tag.Name()] = tag.Refcnt()
+		}
+	}
+	return refcnts
+}
+
+func expectRefcnts(t *testing.T, expect map[string]int) {
+	if have := tagRefcnts(t); fmt.Sprint(have) != fmt.Sprint(expect) {
+		t.Fatalf("refcnts have:%v - expect:%v", have, expect)
+	}
+}
+
+func TestTagDictionaryEncodeDecode(t *testing.T) {
+	defer testRepo(t)()
+
+	var d, e = loadTagDictionary()
+	if e != nil {
+		t.Fatalf("loadTagDictionary - %v", e)
+	}
+	if d.Size() != 0 {
+		t.Fatalf("size have:%d - expect:0", d.Size())
+	}
+	for i, name := range []string{"Jazz", "blues", "jazz", "tag 2"} {
+		added, id, e := d.Add(name)
+		if e != nil {
+			t.Fatalf("Add(%q) - %v", name, e)
+		}
+		if expect := i != 2; added != expect {
+			t.Fatalf("Add(%q) added have:%t - expect:%t", name, added, expect)
+		}
+		if expect := map[int]int{0: 1, 1: 2, 2: 1, 3: 3}[i]; id != expect {
+			t.Fatalf("Add(%q) id have:%d - expect:%d", name, id, expect)
+		}
+		for j := 0; j < i+1; j++ {
+			if _, _, e := d.IncrRefcnt(name); e != nil {
+				t.Fatalf("IncrRefcnt(%q) - %v", name, e)
+			}
+		}
+	}
+	for _, name := range []string{"", string(make([]byte, system.MaxTagNameSize+1))} {
+		if _, _, e := d.Add(name); e == nil {
+			t.Fatalf("Add(%q) - expected error", name)
+		}
+	}
+	if _, _, e := d.IncrRefcnt("undefined"); e != system.ErrTagNotFound {
+		t.Fatalf("IncrRefcnt(undefined) have:%v - expect:%v", e, system.ErrTagNotFound)
+	}
+	if ok, e := d.Sync(); !ok || e != nil {
+		t.Fatalf("Sync - ok:%t e:%v", ok, e)
+	}
+	if ok, e := d.Sync(); ok || e != nil {
+		t.Fatalf("Sync - not modified - ok:%t e:%v", ok, e)
+	}
+
+	loaded, e := loadTagDictionary()
+	if e != nil {
+		t.Fatalf("loadTagDictionary - %v", e)
+	}
+	if *loaded.header != *d.header {
+		t.Fatalf("header have:%+v - expect:%+v", *loaded.header, *d.header)
+	}
+	if !reflect.DeepEqual(loaded.tags, d.tags) || !reflect.DeepEqual(loaded.hashes, d.hashes) {
+		t.Fatalf("tags have:%v - expect:%v", loaded.tags, d.tags)
+	}
+	ids, notDefined := loaded.SelectTags([]string{"JAZZ", "blues", "tag 2", "undefined"})
+	if fmt.Sprint(ids) != "[1 2 3]" || fmt.Sprint(notDefined) != "[undefined]" {
+		t.Fatalf("SelectTags have:%v %q", ids, notDefined)
+	}
+	expectRefcnts(t, map[string]int{"jazz": 4, "blues": 2, "tag 2": 4})
+	for name, filename := range map[string]string{"jazz": TagmapFilename("jazz"), "": TagmapFilename("undefined")} {
+		if tag, ok := loaded.TagmapTag(filename); tag != name || ok != (name != "") {
+			t.Fatalf("TagmapTag(%q) have:%q %t - expect:%q", filename, tag, ok, name)
+		}
+	}
+}
+
+func TestTagDictionaryRefcnts(t *testing.T) {
+	defer testRepo(t)()
+
+	// updateIndex
+	oids := indexTexts(t,
+		testObject{"x", []string{"a", "b"}},
+		testObject{"y", []string{"a"}},
+		testObject{"z", []string{"a", "c"}},
+	)
+	expectRefcnts(t, map[string]int{"a": 3, "b": 1, "c": 1})
+
+	idx, e := OpenIndexManager(Write)
+	if e != nil {
+		t.Fatalf("OpenIndexManager - %v", e)
+	}
+	// an existing object is not counted again
+	if _, added, e := idx.IndexText(false, "x", "a", "d"); e != nil || added {
+		idx.Rollback()
+		t.Fatalf("IndexText - added:%t e:%v", added, e)
+	}
+	// RemoveTags
+	if _, e := idx.RemoveTags(oids[1], "a", "undefined"); e != nil {
+		idx.Rollback()
+		t.Fatalf("RemoveTags - %v", e)
+	}
+	// DeleteObject
+	if ok, e := idx.DeleteObject(oids[2]); e != nil || !ok {
+		idx.Rollback()
+		t.Fatalf("DeleteObject - ok:%t e:%v", ok, e)
+	}
+	if e := idx.Close(true); e != nil {
+		t.Fatalf("Close - %v", e)
+	}
+	// tags with a zero refcnt are retained
+	expectRefcnts(t, map[string]int{"a": 1, "b": 1, "c": 0, "d": 1})
+	fsckClean(t)
+
+	// a rolled back session is not counted
+	if idx, e = OpenIndexManager(Write); e != nil {
+		t.Fatalf("OpenIndexManager - %v", e)
+	}
+	if _, _, e := idx.IndexText(false, "w", "a"); e != nil {
+		idx.Rollback()
+		t.Fatalf("IndexText - %v", e)
+	}
+	if e := idx.Rollback(); e != nil {
+		t.Fatalf("Rollback - %v", e)
+	}
+	expectRefcnts(t, map[string]int{"a": 1, "b": 1, "c": 0, "d": 1})
+}
+
+// repos created before the tag dictionary was introduced.
+func TestTagDictionaryBuild(t *testing.T) {
+	defer testRepo(t)()
+
+	indexTexts(t,
+		testObject{"x", []string{"a", "b"}},
+		testObject{"y", []string{"a"}},
+	)
+	var expect = tagRefcnts(t)
+	if e := os.Remove(repo.TagDictionaryPath); e != nil {
+		t.Fatalf("%v", e)
+	}
+
+	// built from the cards and saved by the next session
+	tagdict, e := loadTagDictionary()
+	if e != nil {
+		t.Fatalf("loadTagDictionary - %v", e)
+	}
+	if !tagdict.modified {
+		t.Fatalf("built tag dictionary not modified")
+	}
+	if _, e := os.Stat(repo.TagDictionaryPath); !os.IsNotExist(e) {
+		t.Fatalf("tag dictionary saved on load - %v", e)
+	}
+	indexTexts(t, testObject{"z", []string{"b"}})
+	if _, e := os.Stat(repo.TagDictionaryPath); e != nil {
+		t.Fatalf("tag dictionary not saved - %v", e)
+	}
+	expect["b"]++
+	expectRefcnts(t, expect)
+	fsckClean(t)
+}
+
+func TestTagDictionaryCorrupt(t *testing.T) {
+	defer testRepo(t)()
+
+	indexTexts(t, testObject{"x", []string{"a"}})
+	buf, e := ioutil.ReadFile(repo.TagDictionaryPath)
+	if e != nil {
+		t.Fatalf("%v", e)
+	}
+	for _, xof := range []int{8, 16, tagdictHeaderSize + 8, len(buf) - 1} { // crc, header, refcnt, name
+		var corrupt = append([]byte{}, buf...)
+		corrupt[xof] ^= 0xff
+		if e := ioutil.WriteFile(repo.TagDictionaryPath, corrupt, repo.FilePerm); e != nil {
+			t.Fatalf("%v", e)
+		}
+		if _, e := loadTagDictionary(); e == nil {
+			t.Fatalf("loadTagDictionary - corrupt byte %d - expected error", xof)
+		}
+		if _, e := OpenIndexManager(Write); e == nil {
+			t.Fatalf("OpenIndexManager - corrupt byte %d - expected error", xof)
+		}
+	}
+	// truncated
+	if e := ioutil.WriteFile(repo.TagDictionaryPath, buf[:len(buf)-1], repo.FilePerm); e != nil {
+		t.Fatalf("%v", e)
+	}
+	if _, e := loadTagDictionary(); e == nil {
+		t.Fatalf("loadTagDictionary - truncated - expected error")
+	}
+}
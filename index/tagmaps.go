@@ -46,6 +46,16 @@ func (h *tagmapHeader) Print(w io.Writer) {
 	fmt.Fprintf(w, "bitmap-max:  %d\n", h.mapMax)
 }
 
+// newTagmapHeader returns the header of a new (empty) tagmap.
+func newTagmapHeader() *tagmapHeader {
+	var now = time.Now().UnixNano()
+	return &tagmapHeader{
+		ftype:   mmap_tagmap_ftype,
+		created: now,
+		updated: now,
+	}
+}
+
 // encode writes the header data to the given buffer.
 // Returns error if buf length < tagmap.tagmapHeaderSize.
 func (h *tagmapHeader) encode(buf []byte) error {
@@ -116,6 +126,15 @@ func (t *Tagmap) Print(w io.Writer) {
 	t.bitmap.Print(w)
 }
 
+// stats returns the bitmap size and max bitnum of the tagmap. The header values
+// are used unless the tagmap has been modified in-mem.
+func (t *Tagmap) stats() (uint64, uint64) {
+	if t.modified {
+		return uint64(t.bitmap.Size()), uint64(t.bitmap.Max())
+	}
+	return t.header.mapSize, t.header.mapMax
+}
+
 // Returns the absolute path filename for the given tag. The full path is a
 // variation on git's approach to blob file paths: tag name is converted to
 // lower-case form; (b) the blake2b uint64 hash of that is used to construct
@@ -123,12 +142,17 @@ func (t *Tagmap) Print(w io.Writer) {
 // than sufficient given that the total number of tags in gart will be far less
 // than 2^32.
 func TagmapFilename(tag string) string {
-	tag = strings.ToLower(tag)
-	hash := fmt.Sprintf("%x.bitmap", digest.SumUint64([]byte(tag)))
+	hash := fmt.Sprintf("%x.bitmap", tagmapHash(tag))
 	path := filepath.Join(repo.IndexTagmapsPath, hash[:2])
 	return filepath.Join(path, hash[2:])
 }
 
+// tagmapHash returns the hash of the (lower-case) tag name used to name its
+// tagmap file.
+func tagmapHash(tag string) uint64 {
+	return digest.SumUint64([]byte(strings.ToLower(tag)))
+}
+
 // Creates the initial tagmap file for the given tag in the canonical
 // repo location. Tag names in gart are case-insensitive and the tag
 // (name) will always be converted to lower-case form.
@@ -150,14 +174,7 @@ func createTagmap(tag string) (*Tagmap, error) {
 	}
 	defer file.Close()
 
-	var now = time.Now().UnixNano()
-	var h = &tagmapHeader{
-		ftype:   mmap_tagmap_ftype,
-		created: now,
-		updated: now,
-		mapSize: 0,
-		mapMax:  0,
-	}
+	var h = newTagmapHeader()
 
 	var buf [tagmapHeaderSize]byte
 	if e := h.encode(buf[:]); e != nil {
@@ -179,6 +196,34 @@ func createTagmap(tag string) (*Tagmap, error) {
 	return tagmap, nil
 }
 
+// writeTagmap (over)writes the tagmap file with the given bitmap. If header
+// is nil, a new header is created. The file is written via its swapfile.
+func writeTagmap(filename, tag string, header *tagmapHeader, wahl *bitmap.Wahl) error {
+	var err = errors.For(fmt.Sprintf("index.writeTagmap(%q)", tag))
+
+	if header == nil {
+		header = newTagmapHeader()
+	}
+	var tagmap = &Tagmap{
+		header:   header,
+		tag:      tag,
+		bitmap:   wahl,
+		source:   filename,
+		modified: true,
+	}
+	if e := os.MkdirAll(filepath.Dir(filename), repo.DirPerm); e != nil {
+		return err.ErrorWithCause(e, "dir:%q", filepath.Dir(filename))
+	}
+	if _, e := tagmap.saveWip(); e != nil {
+		return err.ErrorWithCause(e, "on saveWip")
+	}
+	var swapfile = fs.SwapfileName(filename)
+	if e := os.Rename(swapfile, filename); e != nil {
+		return err.ErrorWithCause(e, "os.Rename %q %q", swapfile, filename)
+	}
+	return nil
+}
+
 // Loads the tagmap (in form of bitmap.Wahl) from file and closes the file.
 // File is openned in private, read-only mode.
 func loadTagmap(tag string, create bool) (*Tagmap, error) {
@@ -260,6 +305,55 @@ func loadTagmap(tag string, create bool) (*Tagmap, error) {
 	return tagmap, nil
 }
 
+/// tag catalog ///////////////////////////////////////////////////////////////
+
+// TagInfo describes a tag and the number of objects tagged with it.
+type TagInfo struct {
+	Name  string
+	Count int
+}
+
+// ListTags returns the TagInfo for all tags applied to (non-deleted) objects,
+// sorted by tag name. Tag names are read from the tag dictionary, as tagmap
+// file names are hashes of the tag. Object counts are read from the tagmaps.
+//
+// The repo is (read) locked for the duration of the call, and ListTags must
+// not be called while an IndexManager is open.
+//
+// Returns nil, error on any error.
+func ListTags() ([]TagInfo, error) {
+	var err = errors.For("index.ListTags")
+
+	lock, e := lockRepo(Read)
+	if e != nil {
+		return nil, e
+	}
+	defer lock.Unlock()
+
+	tagdict, e := loadTagDictionary()
+	if e != nil {
+		return nil, err.ErrorWithCause(e, "on loadTagDictionary")
+	}
+
+	var list = make([]TagInfo, 0, tagdict.Size())
+	for _, tag := range tagdict.Tags() {
+		if tag.Refcnt() == 0 {
+			continue
+		}
+		tagmap, e := loadTagmap(tag.Name(), false)
+		if e == ErrTagNotExist {
+			continue
+		} else if e != nil {
+			return nil, err.ErrorWithCause(e, "on loadTagmap(%q)", tag.Name())
+		}
+		if n := tagmap.bitmap.Count(); n > 0 {
+			list = append(list, TagInfo{tag.Name(), n})
+		}
+	}
+
+	return list, nil
+}
+
 type bitmapOp byte
 
 const (
@@ -295,15 +389,16 @@ func (t *Tagmap) update(op bitmapOp, keys ...uint) bool {
 	return false // REVU don't return t.modified - it could have been set before
 }
 
-// Tagmap#save saves the tagmap if modified. If modified, the Wahl bitmap
-// is compressed; the tagmap is saved to a swap file; and finally the swapfile
-// is swapped with the original source file.
+// Tagmap#saveWip saves the tagmap to its swapfile if modified. If modified,
+// the Wahl bitmap is compressed and the tagmap is saved to a swap file. The
+// swapfile is swapped with the original source file on commit of the index
+// session journal (see journal.go).
 //
 // Function returns a bool indicating if IO was performed, and, errors if
 // any. If error is not nil, the bool result should be ignored as a swap file
 // is used.
-func (t *Tagmap) save() (bool, error) {
-	var err = errors.For("Tagmap.save")
+func (t *Tagmap) saveWip() (bool, error) {
+	var err = errors.For("Tagmap.saveWip")
 
 	if !t.modified {
 		return false, nil
@@ -321,8 +416,8 @@ func (t *Tagmap) save() (bool, error) {
 
 	var size = int64(tagmapHeaderSize + t.header.mapSize)
 	var swapfile = fs.SwapfileName(t.source)
-	var ops = os.O_RDWR //| os.O_APPEND
-	sfile, e := fs.OpenNewFile(swapfile, ops)
+	// note: a stale swapfile (of an uncommitted session) is replaced.
+	sfile, _, e := fs.OpenNewSwapfile(swapfile, false)
 	if e != nil {
 		return false, err.ErrorWithCause(e, "swapfile %q open-new", swapfile)
 	}
@@ -357,10 +452,5 @@ func (t *Tagmap) save() (bool, error) {
 		return false, err.ErrorWithCause(e, "swapfile %q close", swapfile)
 	}
 
-	// swap it
-	if e := os.Rename(swapfile, t.source); e != nil {
-		return false, err.ErrorWithCause(e, "os.Replace %q %q", swapfile, t.source)
-	}
-
 	return true, nil // : π U
 }
@@ -3,6 +3,7 @@
 package repo
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -18,20 +19,33 @@ const (
 	RepoDir               = ".gart" // REVU rename to repo.Dir
 	TagsDir               = "tags"
 	IndexDir              = "index"
+	ObjectsDir            = "objects"
 	ObjectIndexFilename   = "objects.idx"
 	TagDictionaryFilename = "tagdict.dat"
+	LockFilename          = "lock"
+	JournalFilename       = "journal"
+	HashCacheFilename     = "hashcache"
+	IgnoreFilename        = "ignore"
 )
 
 // To support os portability these immutable system facts are vars.
 // Initialized in (runtime.go) init().
 var (
 	RepoPath          string // REVU rename to Path
+	LockPath          string
+	JournalPath       string
+	HashCachePath     string
+	IgnorePath        string
 	TagsPath          string
 	IndexPath         string
 	ObjectIndexPath   string
 	TagDictionaryPath string
 	IndexCardsPath    string
 	IndexTagmapsPath  string
+	ObjectsPath       string
+	ObjectChunksPath  string
+
+	GlobalIgnorePath string // user's ignore file - see InitHomePaths
 )
 
 // permissions of gart file-system artifacts
@@ -40,9 +54,14 @@ const (
 	FilePerm = 0644 // all files are -rw-r--r--
 )
 
-// panics on error
+// InitPaths initializes the repo paths for the repo in the root dir. May be
+// called again to relocate the repo. panics on error
 func InitPaths(rootDir string) {
 	RepoPath = filepath.Join(rootDir, RepoDir)
+	LockPath = filepath.Join(RepoPath, LockFilename)
+	JournalPath = filepath.Join(RepoPath, JournalFilename)
+	HashCachePath = filepath.Join(RepoPath, HashCacheFilename)
+	IgnorePath = filepath.Join(RepoPath, IgnoreFilename)
 
 	TagsPath = filepath.Join(RepoPath, TagsDir)
 	TagDictionaryPath = filepath.Join(TagsPath, TagDictionaryFilename)
@@ -52,10 +71,14 @@ func InitPaths(rootDir string) {
 	IndexCardsPath = filepath.Join(IndexPath, "cards")
 	IndexTagmapsPath = filepath.Join(IndexPath, "tagmaps")
 
+	ObjectsPath = filepath.Join(RepoPath, ObjectsDir)
+	ObjectChunksPath = filepath.Join(ObjectsPath, "chunks")
+
 	// sanity & fat-finger checking. various gart components remove directories
 	// and nested content. A prior bug had joined various paths (above) to user's
 	// home. The only assumption here below is that gart repo dir is called .gart
-	// and that it is directly nested in user-home (obtained from OS). So even if
+	// and that it is directly nested in the repo root dir (user-home, by default,
+	// or as located by system.LocateRepo). So even if
 	// we got that assumption wrong (i.e. gart's repo dir is called something else
 	// or moved somewhere else) no component of gart will ever delete non-gart
 	// fires or directories. (No, unit-testing is not sufficient. system package
@@ -63,12 +86,18 @@ func InitPaths(rootDir string) {
 	// this one-time runtime check will prevent un-necessary grief.)
 	var safePrefix = filepath.Join(rootDir, ".gart")
 	var paths = []string{
+		LockPath,
+		JournalPath,
+		HashCachePath,
+		IgnorePath,
 		TagsPath,
 		TagDictionaryPath,
 		IndexPath,
 		ObjectIndexPath,
 		IndexCardsPath,
 		IndexTagmapsPath,
+		ObjectsPath,
+		ObjectChunksPath,
 	}
 	for i, path := range paths {
 		if !strings.HasPrefix(path, safePrefix) {
@@ -77,6 +106,28 @@ func InitPaths(rootDir string) {
 	}
 }
 
+// InitHomePaths initializes the paths of the user's (global) gart files in
+// the user's home directory, which are independent of the repo location.
+func InitHomePaths(homeDir string) {
+	GlobalIgnorePath = filepath.Join(homeDir, RepoDir, IgnoreFilename)
+}
+
+// FindRoot returns the root directory of the repo nearest to dir, i.e. dir or
+// its closest ancestor that contains a repo dir. dir must be absolute.
+// Returns false if no repo is found.
+func FindRoot(dir string) (string, bool) {
+	for {
+		if finfo, e := os.Stat(filepath.Join(dir, RepoDir)); e == nil && finfo.IsDir() {
+			return dir, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
 func Initialize(force bool) error {
 	errors := errors.For("repo.Initialize")
 	debug := debug.For("repo.Initialize")
@@ -105,5 +156,29 @@ func Initialize(force bool) error {
 	if e := os.Mkdir(IndexPath, DirPerm); e != nil {
 		return errors.FaultWithCause(e, "os.Mkdir(%q)", IndexPath)
 	}
+	if e := os.Mkdir(TagsPath, DirPerm); e != nil {
+		return errors.FaultWithCause(e, "os.Mkdir(%q)", TagsPath)
+	}
+	if e := os.Mkdir(ObjectsPath, DirPerm); e != nil {
+		return errors.FaultWithCause(e, "os.Mkdir(%q)", ObjectsPath)
+	}
+	if e := ioutil.WriteFile(IgnorePath, []byte(defaultIgnore), FilePerm); e != nil {
+		return errors.FaultWithCause(e, "ioutil.WriteFile(%q)", IgnorePath)
+	}
 	return nil
 }
+
+// initial content of the repo ignore file
+const defaultIgnore = `# gart repo ignore rules - gitignore pattern syntax.
+#
+# Paths matching these rules are not added. These rules take precedence over
+# the rules of the user's global ignore file (~/.gart/ignore), and rules of
+# .gartignore files in added directories (and their parents) take precedence
+# over both. Hidden files and directories are ignored by default, and can be
+# re-included, e.g. !.profile
+*.o
+*.class
+*.jar
+*.pom
+*.lock
+`
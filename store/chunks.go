@@ -0,0 +1,313 @@
+// Doost!
+
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"github.com/alphazero/gart/repo"
+	"github.com/alphazero/gart/syslib/cdc"
+	"github.com/alphazero/gart/syslib/debug"
+	"github.com/alphazero/gart/syslib/digest"
+	"github.com/alphazero/gart/syslib/errors"
+	"github.com/alphazero/gart/syslib/fs"
+	"github.com/alphazero/gart/system"
+)
+
+/// chunked objects ////////////////////////////////////////////////////////////
+
+// Chunked objects are split into content-defined chunks (see syslib/cdc). Each
+// chunk is stored once, addressed by its Blake2B digest, and shared by all
+// objects that contain it:
+//
+//	.gart/objects/chunks/<md[:2]>/<md[2:]>
+//
+// The object (oid) is a chunk manifest, listing the chunks of the object
+// content in order:
+//
+//	.gart/objects/<oid[:2]>/<oid[2:]>.cdc
+//
+// The chunks of an object are stored and synced before its manifest, so a
+// manifest only refers to stored chunks.
+
+const manifestExt = ".cdc"
+
+const manifestHeaderSize = 32
+const manifestRecordSize = digest.HashSize + 4
+const manifest_ftype uint64 = 0x3c7b0e9d52a8f461
+
+// manifest header - crc64 is the checksum of the full manifest (buf[16:]).
+type manifestHeader struct {
+	ftype uint64
+	crc64 uint64
+	size  int64 // object content size
+	ccnt  int64 // number of chunks
+}
+
+type chunkRef struct {
+	md   [digest.HashSize]byte
+	size uint32
+}
+
+func manifestFilename(oid *system.Oid) string {
+	return Filename(oid) + manifestExt
+}
+
+func chunkFilename(md []byte) string {
+	var mdstr = fmt.Sprintf("%x", md)
+	return filepath.Join(repo.ObjectChunksPath, mdstr[:2], mdstr[2:])
+}
+
+// IsChunked returns true if the object is stored as chunks.
+func IsChunked(oid *system.Oid) bool {
+	_, e := os.Stat(manifestFilename(oid))
+	return e == nil
+}
+
+// PutChunked stores the content of the named file as content-defined chunks.
+// Only chunks not already in the store are written. The digest of the file
+// content is verified against the oid. If the object is already stored, the
+// file is not copied. See Put.
+//
+// Returns true if the object was added to the store, and nil on success.
+// Returns ErrDigestMismatch if the file content does not match the oid. On
+// error, the object is not added to the store, but chunks may have been.
+func PutChunked(oid *system.Oid, filename string) (bool, error) {
+	var err = errors.For("store.PutChunked")
+	var debug = debug.For("store.PutChunked")
+
+	if oid == nil {
+		return false, err.InvalidArg("oid is nil")
+	}
+	if Exists(oid) {
+		debug.Printf("%s is stored", oid.Fingerprint())
+		return false, nil
+	}
+
+	src, e := os.Open(filename)
+	if e != nil {
+		return false, e
+	}
+	defer src.Close()
+
+	/// store chunks ////////////////////////////////////////////////
+
+	var h = digest.New()
+	var chunker = cdc.NewChunker(io.TeeReader(src, h))
+	var refs []chunkRef
+	var size int64
+	var added int
+	var dirs = make(map[string]struct{})
+	for {
+		chunk, e := chunker.Next()
+		if e == io.EOF {
+			break
+		} else if e != nil {
+			return false, err.ErrorWithCause(e, "on chunk %q", filename)
+		}
+		var ref = chunkRef{
+			md:   digest.Sum(chunk),
+			size: uint32(len(chunk)),
+		}
+		var chunkfile = chunkFilename(ref.md[:])
+		if _, e := os.Stat(chunkfile); os.IsNotExist(e) {
+			if e := writeFile(chunkfile, chunk); e != nil {
+				return false, err.ErrorWithCause(e, "chunk %d", len(refs))
+			}
+			dirs[filepath.Dir(chunkfile)] = struct{}{}
+			added++
+		}
+		refs = append(refs, ref)
+		size += int64(len(chunk))
+	}
+	if md := h.Sum(nil); !bytes.Equal(md, oid.Bytes()) {
+		debug.Printf("digest mismatch - oid:%s md:%x", oid.Fingerprint(), md)
+		return false, ErrDigestMismatch
+	}
+	for dir := range dirs {
+		if e := syncDir(dir); e != nil {
+			return false, err.ErrorWithCause(e, "on dir sync")
+		}
+	}
+
+	/// manifest ////////////////////////////////////////////////////
+
+	var header = &manifestHeader{
+		ftype: manifest_ftype,
+		size:  size,
+		ccnt:  int64(len(refs)),
+	}
+	var buf = make([]byte, manifestHeaderSize+len(refs)*manifestRecordSize)
+	for i, ref := range refs {
+		var rec = buf[manifestHeaderSize+i*manifestRecordSize:]
+		copy(rec, ref.md[:])
+		*(*uint32)(unsafe.Pointer(&rec[digest.HashSize])) = ref.size
+	}
+	header.encode(buf)
+
+	var manifest = manifestFilename(oid)
+	if e := writeFile(manifest, buf); e != nil {
+		return false, err.ErrorWithCause(e, "manifest")
+	}
+	if e := syncDir(filepath.Dir(manifest)); e != nil {
+		return false, err.ErrorWithCause(e, "on dir sync")
+	}
+	debug.Printf("stored %s - %d bytes - chunks:%d (added:%d)", oid.Fingerprint(), size, len(refs), added)
+
+	return true, nil
+}
+
+// MissingChunks returns the number of chunks of the chunked object that are
+// not in the store.
+//
+// Returns 0, error if the object manifest can not be read.
+func MissingChunks(oid *system.Oid) (int, error) {
+	refs, e := readManifest(oid)
+	if e != nil {
+		return 0, e
+	}
+	var n int
+	for _, ref := range refs {
+		if _, e := os.Stat(chunkFilename(ref.md[:])); e != nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// encode writes the header to the head of the full manifest buffer, and
+// computes the checksum of the manifest.
+func (h *manifestHeader) encode(buf []byte) {
+	*(*uint64)(unsafe.Pointer(&buf[0])) = h.ftype
+	*(*int64)(unsafe.Pointer(&buf[16])) = h.size
+	*(*int64)(unsafe.Pointer(&buf[24])) = h.ccnt
+
+	h.crc64 = digest.Checksum64(buf[16:])
+	*(*uint64)(unsafe.Pointer(&buf[8])) = h.crc64
+}
+
+// decode reads and verifies the header of the full manifest buffer.
+func (h *manifestHeader) decode(buf []byte) error {
+	var err = errors.For("manifestHeader.decode")
+	if len(buf) < manifestHeaderSize {
+		return err.InvalidArg("len(buf):%d < %d", len(buf), manifestHeaderSize)
+	}
+	*h = *(*manifestHeader)(unsafe.Pointer(&buf[0]))
+
+	if h.ftype != manifest_ftype {
+		return err.Bug("ftype:%x - expect: %x", h.ftype, manifest_ftype)
+	}
+	if crc64 := digest.Checksum64(buf[16:]); crc64 != h.crc64 {
+		return err.Bug("crc64:%x - expect: %x", crc64, h.crc64)
+	}
+	if n := int64(manifestHeaderSize) + h.ccnt*manifestRecordSize; n != int64(len(buf)) {
+		return err.Bug("len(buf):%d - expect: %d (ccnt:%d)", len(buf), n, h.ccnt)
+	}
+	return nil
+}
+
+// readManifest reads the chunk refs of the chunked object.
+func readManifest(oid *system.Oid) ([]chunkRef, error) {
+	var err = errors.For("store.readManifest")
+
+	buf, e := ioutil.ReadFile(manifestFilename(oid))
+	if e != nil {
+		return nil, e
+	}
+	var header manifestHeader
+	if e := header.decode(buf); e != nil {
+		return nil, err.ErrorWithCause(e, "oid:%s", oid.Fingerprint())
+	}
+	var refs = make([]chunkRef, header.ccnt)
+	for i := range refs {
+		var rec = buf[manifestHeaderSize+i*manifestRecordSize:]
+		copy(refs[i].md[:], rec[:digest.HashSize])
+		refs[i].size = *(*uint32)(unsafe.Pointer(&rec[digest.HashSize]))
+	}
+	return refs, nil
+}
+
+// writeFile writes the stored file (object or chunk) via its swapfile. The
+// file is synced, but not its directory.
+func writeFile(filename string, data []byte) error {
+	var err = errors.For("store.writeFile")
+
+	if e := os.MkdirAll(filepath.Dir(filename), repo.DirPerm); e != nil {
+		return err.ErrorWithCause(e, "dir:%q", filepath.Dir(filename))
+	}
+	var swapfile = fs.SwapfileName(filename)
+	file, _, e := fs.OpenNewSwapfile(swapfile, false)
+	if e != nil {
+		return err.ErrorWithCause(e, "swapfile %q open-new", swapfile)
+	}
+	var abort = func(e error) error {
+		file.Close()
+		os.Remove(swapfile)
+		return e
+	}
+	if _, e := file.Write(data); e != nil {
+		return abort(err.ErrorWithCause(e, "on swapfile write"))
+	}
+	if e := file.Sync(); e != nil {
+		return abort(err.ErrorWithCause(e, "on swapfile sync"))
+	}
+	if e := file.Chmod(objectPerm); e != nil {
+		return abort(err.ErrorWithCause(e, "on swapfile chmod"))
+	}
+	if e := file.Close(); e != nil {
+		return abort(err.ErrorWithCause(e, "on swapfile close"))
+	}
+	if e := os.Rename(swapfile, filename); e != nil {
+		return abort(err.ErrorWithCause(e, "os.Rename %q %q", swapfile, filename))
+	}
+	return nil
+}
+
+/// chunk reader ///////////////////////////////////////////////////////////////
+
+// chunkReader reads the content of a chunked object.
+type chunkReader struct {
+	refs []chunkRef
+	file *os.File // current chunk
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.file == nil {
+			if len(r.refs) == 0 {
+				return 0, io.EOF
+			}
+			file, e := os.Open(chunkFilename(r.refs[0].md[:]))
+			if e != nil {
+				return 0, errors.ErrorWithCause(e, "store.chunkReader: chunk %x", r.refs[0].md)
+			}
+			r.file = file
+			r.refs = r.refs[1:]
+		}
+		n, e := r.file.Read(p)
+		if e == io.EOF {
+			r.file.Close()
+			r.file = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, e
+	}
+}
+
+func (r *chunkReader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	e := r.file.Close()
+	r.file = nil
+	return e
+}
@@ -0,0 +1,168 @@
+// Doost!
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alphazero/gart/repo"
+)
+
+func chunkFiles(t *testing.T) []string {
+	files, e := filepath.Glob(filepath.Join(repo.ObjectChunksPath, "??", "*"))
+	if e != nil {
+		t.Fatalf("%v", e)
+	}
+	return files
+}
+
+func TestPutChunked(t *testing.T) {
+	dir, done := testStore(t)
+	defer done()
+
+	var content = randomContent(3, 1<<20)
+	filename, oid := testFile(t, dir, "file", content)
+
+	if added, e := PutChunked(oid, filename); e != nil || !added {
+		t.Fatalf("PutChunked - added:%t e:%v", added, e)
+	}
+	if !Exists(oid) || !IsChunked(oid) {
+		t.Fatalf("Exists:%t IsChunked:%t", Exists(oid), IsChunked(oid))
+	}
+	expectNotExist(t, Filename(oid))
+	if n, e := MissingChunks(oid); e != nil || n != 0 {
+		t.Fatalf("MissingChunks - n:%d e:%v", n, e)
+	}
+	var chunks = len(chunkFiles(t))
+	if chunks < 2 {
+		t.Fatalf("chunks have:%d - expect more than 1", chunks)
+	}
+	// stored once - as chunks or whole
+	if added, e := PutChunked(oid, filename); e != nil || added {
+		t.Fatalf("PutChunked - added:%t e:%v", added, e)
+	}
+	if added, e := Put(oid, filename); e != nil || added {
+		t.Fatalf("Put - added:%t e:%v", added, e)
+	}
+
+	// round trip
+	var restored = filepath.Join(dir, "restored")
+	n, e := Get(oid, restored)
+	if e != nil || n != int64(len(content)) {
+		t.Fatalf("Get - n:%d e:%v", n, e)
+	}
+	expectContent(t, restored, content)
+
+	// near duplicate - bytes inserted in the middle. Only the chunks at the
+	// edit are added.
+	var edited = append(append(append([]byte{}, content[:len(content)/2]...), "inserted"...), content[len(content)/2:]...)
+	filename, oid2 := testFile(t, dir, "edited", edited)
+	if added, e := PutChunked(oid2, filename); e != nil || !added {
+		t.Fatalf("PutChunked - added:%t e:%v", added, e)
+	}
+	var added = len(chunkFiles(t)) - chunks
+	if added < 1 || added > 3 {
+		t.Fatalf("chunks added by near duplicate have:%d of %d", added, chunks)
+	}
+	if _, e := Get(oid2, restored); e != nil {
+		t.Fatalf("Get - %v", e)
+	}
+	expectContent(t, restored, edited)
+}
+
+func TestPutChunkedDigestMismatch(t *testing.T) {
+	dir, done := testStore(t)
+	defer done()
+
+	filename, _ := testFile(t, dir, "file", randomContent(4, 100000))
+	var oid = contentOid(t, []byte("indexed content"))
+
+	if added, e := PutChunked(oid, filename); e != ErrDigestMismatch || added {
+		t.Fatalf("PutChunked - added:%t have:%v - expect:%v", added, e, ErrDigestMismatch)
+	}
+	if Exists(oid) {
+		t.Fatalf("Exists - object is stored")
+	}
+	expectNotExist(t, manifestFilename(oid))
+}
+
+func TestChunkedCorrupt(t *testing.T) {
+	dir, done := testStore(t)
+	defer done()
+
+	var content = randomContent(5, 1<<19)
+	filename, oid := testFile(t, dir, "file", content)
+	if _, e := PutChunked(oid, filename); e != nil {
+		t.Fatalf("PutChunked - %v", e)
+	}
+	var restored = filepath.Join(dir, "restored")
+
+	// corrupt manifest
+	var manifest = manifestFilename(oid)
+	buf, e := ioutil.ReadFile(manifest)
+	if e != nil {
+		t.Fatalf("%v", e)
+	}
+	var corrupt = append([]byte{}, buf...)
+	corrupt[len(corrupt)-1] ^= 0xff
+	writeCorrupt(t, manifest, corrupt)
+	if _, e := Open(oid); e == nil || e == ErrObjectNotStored {
+		t.Fatalf("Open - corrupt manifest - have:%v", e)
+	}
+	if _, e := MissingChunks(oid); e == nil {
+		t.Fatalf("MissingChunks - corrupt manifest - expected error")
+	}
+	if _, e := Get(oid, restored); e == nil {
+		t.Fatalf("Get - corrupt manifest - expected error")
+	}
+	expectNotExist(t, restored)
+
+	// truncated manifest
+	writeCorrupt(t, manifest, buf[:len(buf)-1])
+	if _, e := Open(oid); e == nil || e == ErrObjectNotStored {
+		t.Fatalf("Open - truncated manifest - have:%v", e)
+	}
+	writeCorrupt(t, manifest, buf)
+
+	// corrupt chunk
+	refs, e := readManifest(oid)
+	if e != nil {
+		t.Fatalf("readManifest - %v", e)
+	}
+	var chunkfile = chunkFilename(refs[0].md[:])
+	chunk, e := ioutil.ReadFile(chunkfile)
+	if e != nil {
+		t.Fatalf("%v", e)
+	}
+	chunk[0] ^= 0xff
+	writeCorrupt(t, chunkfile, chunk)
+	if _, e := Get(oid, restored); e != ErrDigestMismatch {
+		t.Fatalf("Get - corrupt chunk - have:%v - expect:%v", e, ErrDigestMismatch)
+	}
+	expectNotExist(t, restored)
+
+	// missing chunk
+	if e := os.Remove(chunkfile); e != nil {
+		t.Fatalf("%v", e)
+	}
+	if n, e := MissingChunks(oid); e != nil || n != 1 {
+		t.Fatalf("MissingChunks - n:%d e:%v - expect:1", n, e)
+	}
+	if _, e := Get(oid, restored); e == nil {
+		t.Fatalf("Get - missing chunk - expected error")
+	}
+	expectNotExist(t, restored)
+}
+
+// writeCorrupt replaces the content of the (read-only) stored file.
+func writeCorrupt(t *testing.T, filename string, buf []byte) {
+	if e := os.Chmod(filename, 0644); e != nil {
+		t.Fatalf("%v", e)
+	}
+	if e := ioutil.WriteFile(filename, buf, 0644); e != nil {
+		t.Fatalf("%v", e)
+	}
+}
@@ -0,0 +1,281 @@
+// Doost!
+
+// package store is the content-addressed object (blob) store of the gart
+// repo. Object content is stored, as is, in the repo objects directory and
+// is addressed by the object's oid (Blake2B digest of the content):
+//
+//	.gart/objects/<oid[:2]>/<oid[2:]>
+//
+// Large objects can be stored as deduplicated content-defined chunks. See
+// chunks.go.
+//
+// Stored objects are immutable. Content is written to a swapfile, verified
+// against the oid, synced, and only then renamed to the object file. A
+// partially written object is never visible in the store.
+package store
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/alphazero/gart/repo"
+	"github.com/alphazero/gart/syslib/debug"
+	"github.com/alphazero/gart/syslib/digest"
+	"github.com/alphazero/gart/syslib/errors"
+	"github.com/alphazero/gart/syslib/fs"
+	"github.com/alphazero/gart/system"
+)
+
+// Error returned by Put if the digest of the copied content does not match
+// the object oid, e.g. the source file was modified after it was indexed. Also
+// returned by Get if the stored content is corrupt.
+var ErrDigestMismatch = errors.Error("content digest does not match oid")
+
+// Error returned by Open and Get if the object is not in the store.
+var ErrObjectNotStored = errors.Error("object is not stored")
+
+// stored objects are read-only
+const objectPerm = 0444
+
+// swapfile basename of PutReader
+const streamFilename = "stream"
+
+/// api ////////////////////////////////////////////////////////////////////////
+
+// Filename returns the filename of the (whole) object in the store. The
+// object may not exist.
+func Filename(oid *system.Oid) string {
+	oidstr := oid.String()
+	return filepath.Join(repo.ObjectsPath, oidstr[:2], oidstr[2:])
+}
+
+// Exists returns true if the object is in the store, either whole or
+// chunked.
+func Exists(oid *system.Oid) bool {
+	_, e := os.Stat(Filename(oid))
+	return e == nil || IsChunked(oid)
+}
+
+// Put copies the content of the named file to the store, as object oid. The
+// digest of the copied content is verified against the oid. If the object
+// is already stored, the file is not copied.
+//
+// Returns true if the object was added to the store, and nil on success.
+// Returns ErrDigestMismatch if the file content does not match the oid. The
+// store is not modified on error.
+func Put(oid *system.Oid, filename string) (bool, error) {
+	var err = errors.For("store.Put")
+	var debug = debug.For("store.Put")
+
+	if oid == nil {
+		return false, err.InvalidArg("oid is nil")
+	}
+	if Exists(oid) {
+		debug.Printf("%s is stored", oid.Fingerprint())
+		return false, nil
+	}
+
+	src, e := os.Open(filename)
+	if e != nil {
+		return false, e
+	}
+	defer src.Close()
+
+	var objfile = Filename(oid)
+	if e := os.MkdirAll(filepath.Dir(objfile), repo.DirPerm); e != nil {
+		return false, err.ErrorWithCause(e, "dir:%q", filepath.Dir(objfile))
+	}
+
+	// note: a stale swapfile (of an interrupted put) is replaced.
+	var swapfile = fs.SwapfileName(objfile)
+	dst, _, e := fs.OpenNewSwapfile(swapfile, false)
+	if e != nil {
+		return false, err.ErrorWithCause(e, "swapfile %q open-new", swapfile)
+	}
+	var abort = func(e error) (bool, error) {
+		dst.Close()
+		os.Remove(swapfile)
+		return false, e
+	}
+
+	var h = digest.New()
+	n, e := io.Copy(io.MultiWriter(dst, h), src)
+	if e != nil {
+		return abort(err.ErrorWithCause(e, "on copy %q", filename))
+	}
+	if md := h.Sum(nil); !bytes.Equal(md, oid.Bytes()) {
+		debug.Printf("digest mismatch - oid:%s md:%x", oid.Fingerprint(), md)
+		return abort(ErrDigestMismatch)
+	}
+	if e := dst.Sync(); e != nil {
+		return abort(err.ErrorWithCause(e, "on swapfile sync"))
+	}
+	if e := dst.Chmod(objectPerm); e != nil {
+		return abort(err.ErrorWithCause(e, "on swapfile chmod"))
+	}
+	if e := dst.Close(); e != nil {
+		return abort(err.ErrorWithCause(e, "on swapfile close"))
+	}
+	if e := os.Rename(swapfile, objfile); e != nil {
+		return abort(err.ErrorWithCause(e, "os.Rename %q %q", swapfile, objfile))
+	}
+	if e := syncDir(filepath.Dir(objfile)); e != nil {
+		return false, err.ErrorWithCause(e, "on dir sync")
+	}
+	debug.Printf("stored %s - %d bytes", oid.Fingerprint(), n)
+
+	return true, nil
+}
+
+// PutReader copies the content read from r to the store. The oid of the
+// object is the digest of the content, and is only known once r is read to
+// EOF, so the content is first written to the (single) stream swapfile of the
+// store. The caller must hold the repo write lock. If the object is already
+// stored, the swapfile is removed.
+//
+// Returns the object oid, the content size, true if the object was added to
+// the store, and nil on success. The store is not modified on error.
+func PutReader(r io.Reader) (*system.Oid, int64, bool, error) {
+	var err = errors.For("store.PutReader")
+	var debug = debug.For("store.PutReader")
+
+	if e := os.MkdirAll(repo.ObjectsPath, repo.DirPerm); e != nil {
+		return nil, 0, false, err.ErrorWithCause(e, "dir:%q", repo.ObjectsPath)
+	}
+	// note: a stale swapfile (of an interrupted put) is replaced.
+	var swapfile = fs.SwapfileName(filepath.Join(repo.ObjectsPath, streamFilename))
+	dst, _, e := fs.OpenNewSwapfile(swapfile, false)
+	if e != nil {
+		return nil, 0, false, err.ErrorWithCause(e, "swapfile %q open-new", swapfile)
+	}
+	var abort = func(e error) (*system.Oid, int64, bool, error) {
+		dst.Close()
+		os.Remove(swapfile)
+		return nil, 0, false, e
+	}
+
+	var h = digest.New()
+	n, e := io.Copy(io.MultiWriter(dst, h), r)
+	if e != nil {
+		return abort(err.ErrorWithCause(e, "on copy"))
+	}
+	oid, e := system.NewOid(h.Sum(nil))
+	if e != nil {
+		return abort(err.BugWithCause(e, "unexpected"))
+	}
+	if Exists(oid) {
+		debug.Printf("%s is stored", oid.Fingerprint())
+		dst.Close()
+		os.Remove(swapfile)
+		return oid, n, false, nil
+	}
+	if e := dst.Sync(); e != nil {
+		return abort(err.ErrorWithCause(e, "on swapfile sync"))
+	}
+	if e := dst.Chmod(objectPerm); e != nil {
+		return abort(err.ErrorWithCause(e, "on swapfile chmod"))
+	}
+	if e := dst.Close(); e != nil {
+		return abort(err.ErrorWithCause(e, "on swapfile close"))
+	}
+	var objfile = Filename(oid)
+	if e := os.MkdirAll(filepath.Dir(objfile), repo.DirPerm); e != nil {
+		return abort(err.ErrorWithCause(e, "dir:%q", filepath.Dir(objfile)))
+	}
+	if e := os.Rename(swapfile, objfile); e != nil {
+		return abort(err.ErrorWithCause(e, "os.Rename %q %q", swapfile, objfile))
+	}
+	if e := syncDir(filepath.Dir(objfile)); e != nil {
+		return nil, 0, false, err.ErrorWithCause(e, "on dir sync")
+	}
+	debug.Printf("stored %s - %d bytes", oid.Fingerprint(), n)
+
+	return oid, n, true, nil
+}
+
+// Open opens the stored object for reading. The content of chunked objects
+// is read from the chunks of the object.
+//
+// Returns ErrObjectNotStored if the object is not in the store.
+func Open(oid *system.Oid) (io.ReadCloser, error) {
+	var err = errors.For("store.Open")
+
+	if oid == nil {
+		return nil, err.InvalidArg("oid is nil")
+	}
+	file, e := os.Open(Filename(oid))
+	if e == nil {
+		return file, nil
+	} else if !os.IsNotExist(e) {
+		return nil, err.ErrorWithCause(e, "oid:%s", oid.Fingerprint())
+	}
+
+	refs, e := readManifest(oid)
+	if os.IsNotExist(e) {
+		return nil, ErrObjectNotStored
+	} else if e != nil {
+		return nil, err.ErrorWithCause(e, "oid:%s", oid.Fingerprint())
+	}
+	return &chunkReader{refs: refs}, nil
+}
+
+// Get writes the content of the stored object to the named file. The digest
+// of the content is verified against the oid. The file is written via a
+// swapfile and is only created if the content is verified. An existing file
+// is replaced.
+//
+// Returns the number of bytes written, and nil on success.
+// Returns ErrObjectNotStored if the object is not in the store, and
+// ErrDigestMismatch if the stored content does not match the oid.
+func Get(oid *system.Oid, filename string) (int64, error) {
+	var err = errors.For("store.Get")
+	var debug = debug.For("store.Get")
+
+	src, e := Open(oid)
+	if e != nil {
+		return 0, e
+	}
+	defer src.Close()
+
+	// note: an existing (user) file with the swapfile name is not replaced.
+	var swapfile = fs.SwapfileName(filename)
+	dst, _, e := fs.OpenNewSwapfile(swapfile, true)
+	if e != nil {
+		return 0, err.ErrorWithCause(e, "swapfile %q open-new", swapfile)
+	}
+	var abort = func(e error) (int64, error) {
+		dst.Close()
+		os.Remove(swapfile)
+		return 0, e
+	}
+
+	var h = digest.New()
+	n, e := io.Copy(io.MultiWriter(dst, h), src)
+	if e != nil {
+		return abort(err.ErrorWithCause(e, "on copy %s", oid.Fingerprint()))
+	}
+	if md := h.Sum(nil); !bytes.Equal(md, oid.Bytes()) {
+		debug.Printf("digest mismatch - oid:%s md:%x", oid.Fingerprint(), md)
+		return abort(ErrDigestMismatch)
+	}
+	if e := dst.Close(); e != nil {
+		return abort(err.ErrorWithCause(e, "on swapfile close"))
+	}
+	if e := os.Rename(swapfile, filename); e != nil {
+		return abort(err.ErrorWithCause(e, "os.Rename %q %q", swapfile, filename))
+	}
+	debug.Printf("restored %s to %q - %d bytes", oid.Fingerprint(), filename, n)
+
+	return n, nil
+}
+
+func syncDir(dir string) error {
+	file, e := os.Open(dir)
+	if e != nil {
+		return e
+	}
+	defer file.Close()
+	return file.Sync()
+}
@@ -0,0 +1,204 @@
+// Doost!
+
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alphazero/gart/repo"
+	"github.com/alphazero/gart/syslib/digest"
+	"github.com/alphazero/gart/syslib/fs"
+	"github.com/alphazero/gart/system"
+)
+
+/// test support ///////////////////////////////////////////////////////////////
+
+// testStore relocates the repo paths to a temp directory. The returned dir is
+// the repo root, and the returned func restores the repo paths and removes the
+// directory.
+func testStore(t *testing.T) (string, func()) {
+	dir, e := ioutil.TempDir("", "gart-store")
+	if e != nil {
+		t.Fatalf("%v", e)
+	}
+	var root = filepath.Dir(repo.RepoPath)
+	system.SetRepoRoot(dir)
+	return dir, func() {
+		system.SetRepoRoot(root)
+		os.RemoveAll(dir)
+	}
+}
+
+// testFile writes the content to the named file in dir, and returns the
+// filename and the oid of the content.
+func testFile(t *testing.T, dir, name string, content []byte) (string, *system.Oid) {
+	var filename = filepath.Join(dir, name)
+	if e := ioutil.WriteFile(filename, content, 0644); e != nil {
+		t.Fatalf("%v", e)
+	}
+	return filename, contentOid(t, content)
+}
+
+func contentOid(t *testing.T, content []byte) *system.Oid {
+	var md = digest.Sum(content)
+	oid, e := system.NewOid(md[:])
+	if e != nil {
+		t.Fatalf("NewOid - %v", e)
+	}
+	return oid
+}
+
+func randomContent(seed int64, size int) []byte {
+	var buf = make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(buf)
+	return buf
+}
+
+func expectNotExist(t *testing.T, filename string) {
+	if _, e := os.Stat(filename); !os.IsNotExist(e) {
+		t.Fatalf("%q exists - %v", filename, e)
+	}
+}
+
+func expectContent(t *testing.T, filename string, expect []byte) {
+	buf, e := ioutil.ReadFile(filename)
+	if e != nil {
+		t.Fatalf("%v", e)
+	}
+	if !bytes.Equal(buf, expect) {
+		t.Fatalf("%q content - len have:%d - expect:%d", filename, len(buf), len(expect))
+	}
+}
+
+/// tests //////////////////////////////////////////////////////////////////////
+
+func TestPutGet(t *testing.T) {
+	dir, done := testStore(t)
+	defer done()
+
+	var content = randomContent(1, 100000)
+	filename, oid := testFile(t, dir, "file", content)
+
+	if Exists(oid) {
+		t.Fatalf("Exists - object is not stored")
+	}
+	if added, e := Put(oid, filename); e != nil || !added {
+		t.Fatalf("Put - added:%t e:%v", added, e)
+	}
+	if !Exists(oid) || IsChunked(oid) {
+		t.Fatalf("Exists:%t IsChunked:%t", Exists(oid), IsChunked(oid))
+	}
+	expectContent(t, Filename(oid), content)
+	expectNotExist(t, fs.SwapfileName(Filename(oid)))
+	if fi, e := os.Stat(Filename(oid)); e != nil || fi.Mode().Perm() != objectPerm {
+		t.Fatalf("stored object - fi:%v e:%v", fi, e)
+	}
+	// stored once
+	if added, e := Put(oid, filename); e != nil || added {
+		t.Fatalf("Put - added:%t e:%v", added, e)
+	}
+
+	var restored = filepath.Join(dir, "restored")
+	n, e := Get(oid, restored)
+	if e != nil || n != int64(len(content)) {
+		t.Fatalf("Get - n:%d e:%v", n, e)
+	}
+	expectContent(t, restored, content)
+	expectNotExist(t, fs.SwapfileName(restored))
+
+	// an existing file is replaced
+	if e := ioutil.WriteFile(restored, []byte("modified"), 0644); e != nil {
+		t.Fatalf("%v", e)
+	}
+	if _, e := Get(oid, restored); e != nil {
+		t.Fatalf("Get - %v", e)
+	}
+	expectContent(t, restored, content)
+
+	// not stored
+	var other = contentOid(t, []byte("other"))
+	if _, e := Get(other, restored); e != ErrObjectNotStored {
+		t.Fatalf("Get - have:%v - expect:%v", e, ErrObjectNotStored)
+	}
+	if _, e := Open(other); e != ErrObjectNotStored {
+		t.Fatalf("Open - have:%v - expect:%v", e, ErrObjectNotStored)
+	}
+}
+
+func TestPutDigestMismatch(t *testing.T) {
+	dir, done := testStore(t)
+	defer done()
+
+	filename, _ := testFile(t, dir, "file", []byte("modified after indexing"))
+	var oid = contentOid(t, []byte("indexed content"))
+
+	if added, e := Put(oid, filename); e != ErrDigestMismatch || added {
+		t.Fatalf("Put - added:%t have:%v - expect:%v", added, e, ErrDigestMismatch)
+	}
+	if Exists(oid) {
+		t.Fatalf("Exists - object is stored")
+	}
+	expectNotExist(t, Filename(oid))
+	expectNotExist(t, fs.SwapfileName(Filename(oid)))
+}
+
+func TestGetDigestMismatch(t *testing.T) {
+	dir, done := testStore(t)
+	defer done()
+
+	var content = []byte("stored content")
+	filename, oid := testFile(t, dir, "file", content)
+	if _, e := Put(oid, filename); e != nil {
+		t.Fatalf("Put - %v", e)
+	}
+
+	// corrupt the stored object
+	var objfile = Filename(oid)
+	if e := os.Chmod(objfile, 0644); e != nil {
+		t.Fatalf("%v", e)
+	}
+	if e := ioutil.WriteFile(objfile, []byte("corrupt content"), 0644); e != nil {
+		t.Fatalf("%v", e)
+	}
+
+	// the existing file is not replaced
+	var restored = filepath.Join(dir, "restored")
+	if e := ioutil.WriteFile(restored, []byte("existing"), 0644); e != nil {
+		t.Fatalf("%v", e)
+	}
+	if _, e := Get(oid, restored); e != ErrDigestMismatch {
+		t.Fatalf("Get - have:%v - expect:%v", e, ErrDigestMismatch)
+	}
+	expectContent(t, restored, []byte("existing"))
+	expectNotExist(t, fs.SwapfileName(restored))
+}
+
+func TestPutReader(t *testing.T) {
+	_, done := testStore(t)
+	defer done()
+
+	var content = randomContent(2, 50000)
+	var expect = contentOid(t, content)
+
+	oid, n, added, e := PutReader(bytes.NewReader(content))
+	if e != nil || !added || n != int64(len(content)) {
+		t.Fatalf("PutReader - n:%d added:%t e:%v", n, added, e)
+	}
+	if oid.String() != expect.String() {
+		t.Fatalf("PutReader - oid have:%s - expect:%s", oid, expect)
+	}
+	expectContent(t, Filename(oid), content)
+
+	// stored once - the stream swapfile is removed
+	var swapfile = fs.SwapfileName(filepath.Join(repo.ObjectsPath, streamFilename))
+	oid, _, added, e = PutReader(bytes.NewReader(content))
+	if e != nil || added || oid.String() != expect.String() {
+		t.Fatalf("PutReader - oid:%s added:%t e:%v", oid, added, e)
+	}
+	expectNotExist(t, swapfile)
+}
@@ -95,6 +95,26 @@ func verifyOr(a, b, or *bitmap.Wahl) error {
 	return nil
 }
 
+// andnot bits must be set in a and not set in b
+func verifyAndNot(a, b, andnot *bitmap.Wahl) error {
+	a_map := mapArray(a.Bits())
+	b_map := mapArray(b.Bits())
+	andnot_map := mapArray(andnot.Bits())
+	ref_map := make(map[int]bool)
+	for bit := range a_map {
+		if !b_map[bit] {
+			ref_map[bit] = true
+		}
+	}
+	compareMaps("verify AND-NOT map", andnot_map, ref_map)
+	for _, bit := range andnot.Bits() {
+		if !a_map[bit] || b_map[bit] {
+			return errors.Bug("AND-NOT: bit %d is not set in a or is set in b\n", bit)
+		}
+	}
+	return nil
+}
+
 func boolToInt(b bool) int {
 	if b {
 		return 1
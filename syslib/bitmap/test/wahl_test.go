@@ -67,6 +67,56 @@ func init() {
 const maxBit = 1 << 20 // large bitmaps to increase prob of testing all edge cases.
 var w0 = bitmap.NewRandomWahl(rnd, maxBit)
 var w1 = bitmap.NewRandomWahl(rnd, maxBit)
+var wc = bitmap.NewRandomClusteredWahl(rnd, 1<<12, 8) // with fill-1 runs
+
+func TestCount(t *testing.T) {
+	for _, w := range []*bitmap.Wahl{bitmap.NewWahl(), w0, w1} {
+		if n, expect := w.Count(), len(w.Bits()); n != expect {
+			t.Fatalf("Count: %d - expected:%d", n, expect)
+		}
+	}
+}
+
+func TestIterator(t *testing.T) {
+	for _, w := range []*bitmap.Wahl{bitmap.NewWahl(), w0, w1, wc} {
+		var expect = []int(w.Bits())
+		var it = w.Iterator()
+		for i, bit := range expect {
+			have, ok := it.Next()
+			if !ok {
+				t.Fatalf("Iterator.Next: done at %d - expected:%d bits", i, len(expect))
+			}
+			if have != bit {
+				t.Fatalf("Iterator.Next: bit[%d]:%d - expected:%d", i, have, bit)
+			}
+		}
+		if bit, ok := it.Next(); ok {
+			t.Fatalf("Iterator.Next: unexpected bit %d", bit)
+		}
+	}
+}
+
+func TestIteratorSkip(t *testing.T) {
+	for _, w := range []*bitmap.Wahl{w0, wc} {
+		var expect = []int(w.Bits())
+		for _, n := range []int{0, 1, 30, 31, 1000, len(expect) / 2, len(expect) - 1, len(expect)} {
+			var it = w.Iterator()
+			if skipped := it.Skip(n); skipped != n {
+				t.Fatalf("Iterator.Skip(%d): %d", n, skipped)
+			}
+			bit, ok := it.Next()
+			if n == len(expect) {
+				if ok {
+					t.Fatalf("Iterator.Skip(%d): unexpected bit %d", n, bit)
+				}
+				continue
+			}
+			if !ok || bit != expect[n] {
+				t.Fatalf("Iterator.Skip(%d): next:%d ok:%t - expected:%d", n, bit, ok, expect[n])
+			}
+		}
+	}
+}
 
 func TestNot(t *testing.T) {
 	w0_not := w0.Not()
@@ -108,6 +158,45 @@ func TestXor(t *testing.T) {
 	}
 }
 
+func TestAndNot(t *testing.T) {
+	var short = bitmap.NewRandomWahl(rnd, maxBit>>4)
+	var sparse = bitmap.NewWahlInit(3, 4000, maxBit+1000) // fill-0 runs, longer than w0
+	var bits = make([]uint, 1<<14)
+	for i := range bits {
+		bits[i] = uint(i)
+	}
+	var ones = bitmap.NewWahlInit(bits...)
+	ones.Compress() // fill-1 run
+	var empty = bitmap.NewWahl()
+
+	var tests = []struct{ w, x *bitmap.Wahl }{
+		{w0, w1},
+		{w1, w0},
+		{w0, short}, // x shorter
+		{short, w0}, // x longer
+		{wc, w0},
+		{w0, wc},
+		{wc, ones},
+		{ones, wc},
+		{ones, sparse},
+		{sparse, ones},
+		{w0, sparse},
+		{sparse, w0},
+		{w0, empty},
+		{empty, w0},
+		{w0, w0},
+	}
+	for i, test := range tests {
+		w_andnot, e := test.w.AndNot(test.x)
+		if e != nil {
+			t.Fatalf("test %d: %v", i, e)
+		}
+		if e := verifyAndNot(test.w, test.x, w_andnot); e != nil {
+			t.Fatalf("test %d: %v", i, e)
+		}
+	}
+}
+
 // TODO verifyXor & testXor
 // TODO test basic ops, clear, set, etc per below
 
@@ -499,6 +499,16 @@ func (w *Wahl) Max() int {
 	return max
 }
 
+// Returns the number of set bits in the bitmap. Count is computed directly
+// from the (compressed) blocks and does not decompress the bitmap.
+func (w *Wahl) Count() int {
+	var n int
+	if e := w.apply(countBitsVisitor(&n)); e != nil {
+		panic(errors.Bug("Wahl.Count: %v", e))
+	}
+	return n
+}
+
 // Note that bits are reversed and printed LSB -> MSB
 func (w Wahl) Print(writer io.Writer) {
 	var max int = -1
@@ -700,6 +710,32 @@ func (w *Wahl) Xor(x *Wahl) (*Wahl, error) {
 	return ri.done(), nil
 }
 
+// AndNot returns a newly allocated bitmap with the bits of the receiver that
+// are not set in x, i.e. w & !x. Unlike w.And(x.Not()), the result does not
+// depend on the relative lengths of the bitmaps: bits of w beyond the end of
+// x are retained, and bits of x beyond the end of w are ignored.
+func (w *Wahl) AndNot(x *Wahl) (*Wahl, error) {
+	var i0 = w.getReader()
+	var ix = x.getReader()
+	var writer = newWriter(nil)
+	for i0.rlen > 0 && ix.rlen > 0 {
+		// min rlens - fill runs are consumed in a single step
+		var rlen = i0.rlen
+		if i0.rlen > ix.rlen {
+			rlen = ix.rlen
+		}
+		writer.writeN(i0.word&^ix.word, rlen)
+		i0.advanceN(rlen)
+		ix.advanceN(rlen)
+	}
+	// tail of w is retained as is. tail of x is ignored.
+	for i0.rlen > 0 {
+		writer.writeN(i0.word, i0.rlen)
+		i0.advanceN(i0.rlen)
+	}
+	return writer.done(), nil
+}
+
 /// wahl iterators /////////////////////////////////////////////////////////////
 
 // wahlIterator for sequential read/write of compressed bitmap
@@ -885,6 +921,97 @@ func (w *Wahl) Decode(buf []byte) error {
 	return nil
 }
 
+/// Wahl bit iterator /////////////////////////////////////////////////////////
+
+// BitIterator iterates over the set bits of a bitmap in ascending order. The
+// bitmap is not decompressed and the bit positions are not materialized. The
+// bitmap must not be modified during iteration.
+type BitIterator struct {
+	arr      []uint32
+	i        int    // index of next block
+	p0       int    // bit position of the LSB of the next block
+	runPos   int    // next bit position in current fill-1 run
+	runEnd   int    // end (exclusive) of current fill-1 run
+	tile     uint32 // remaining set bits of current tile
+	tileBase int    // bit position of the LSB of current tile
+}
+
+// Returns a new iterator positioned before the first set bit.
+func (w *Wahl) Iterator() *BitIterator {
+	return &BitIterator{arr: w.arr}
+}
+
+// Next returns the next set bit position, and false if there are no more
+// set bits.
+func (it *BitIterator) Next() (int, bool) {
+	for {
+		if it.runPos < it.runEnd {
+			it.runPos++
+			return it.runPos - 1, true
+		}
+		if it.tile != 0 {
+			var bit = it.tileBase + bits.TrailingZeros32(it.tile)
+			it.tile &= it.tile - 1
+			return bit, true
+		}
+		if !it.nextBlock() {
+			return 0, false
+		}
+	}
+}
+
+// Skip advances the iterator past the next n set bits. Skipping is by block
+// where possible.
+//
+// Returns the number of bits skipped, which is less than n only if the end
+// of the bitmap is reached.
+func (it *BitIterator) Skip(n int) int {
+	var skipped int
+	for skipped < n {
+		if it.runPos < it.runEnd {
+			k := it.runEnd - it.runPos
+			if k > n-skipped {
+				k = n - skipped
+			}
+			it.runPos += k
+			skipped += k
+			continue
+		}
+		if it.tile != 0 {
+			if k := bits.OnesCount32(it.tile); k <= n-skipped {
+				it.tile = 0
+				skipped += k
+				continue
+			}
+			for ; skipped < n; skipped++ {
+				it.tile &= it.tile - 1
+			}
+			continue
+		}
+		if !it.nextBlock() {
+			break
+		}
+	}
+	return skipped
+}
+
+// loads the next block. Returns false if there are no more blocks.
+func (it *BitIterator) nextBlock() bool {
+	if it.i >= len(it.arr) {
+		return false
+	}
+	block := WahlBlock(it.arr[it.i])
+	it.i++
+	switch {
+	case block.fill && block.fval == 1:
+		it.runPos, it.runEnd = it.p0, it.p0+block.rlen*31
+	case !block.fill:
+		it.tile, it.tileBase = block.val&0x7fffffff, it.p0
+	}
+	it.p0 += block.rlen * 31
+	return true
+}
+
 /// Wahl visitors //////////////////////////////////////////////////////////////
 
 // Visit function type for Wahl blocks.
@@ -925,6 +1052,19 @@ func maxBitsVisitor(max *int) visitFn {
 	}
 }
 
+func countBitsVisitor(n *int) visitFn {
+	return func(bnum int, bval uint32) (bool, error) {
+		block := WahlBlock(bval)
+		switch {
+		case block.fill && block.fval == 1:
+			*n += block.rlen * 31
+		case !block.fill:
+			*n += bits.OnesCount32(block.val)
+		}
+		return false, nil
+	}
+}
+
 func printVisitor(w io.Writer, max *int) visitFn {
 	return func(bnum int, bval uint32) (bool, error) {
 		block := WahlBlock(bval)
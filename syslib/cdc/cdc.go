@@ -0,0 +1,136 @@
+// Doost!
+
+package cdc
+
+import (
+	"io"
+
+	"github.com/alphazero/gart/syslib/errors"
+)
+
+/// consts and vars ///////////////////////////////////////////////////////////
+
+// Chunk size parameters (bytes).
+const (
+	MinSize    = 16 << 10  // no boundary is checked before min size
+	NormalSize = 64 << 10  // normalized (average) chunk size
+	MaxSize    = 256 << 10 // chunks are cut at max size
+)
+
+// boundary masks - the stricter (small) mask applies before NormalSize.
+// Gear hash bits are shifted left, so the high bits reflect the most bytes.
+const (
+	maskS uint64 = (1<<18 - 1) << (64 - 18)
+	maskL uint64 = (1<<14 - 1) << (64 - 14)
+)
+
+const gearSeed uint64 = 0x6761727463646321 // "gartcdc!"
+
+var gear [256]uint64
+
+// gear table is generated with splitmix64. DO NOT CHANGE.
+func init() {
+	var x = gearSeed
+	for i := range gear {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+/// chunker ///////////////////////////////////////////////////////////////////
+
+// Chunker splits the content of a reader into content-defined chunks.
+type Chunker struct {
+	r   io.Reader
+	buf []byte
+	off int   // offset of next chunk in buf
+	end int   // end of buffered data
+	eof bool  // reader is drained
+	e   error // sticky error of reader
+}
+
+// NewChunker returns a chunker for the reader.
+func NewChunker(r io.Reader) *Chunker {
+	return &Chunker{
+		r:   r,
+		buf: make([]byte, 2*MaxSize),
+	}
+}
+
+// Next returns the next chunk. The chunk is only valid until the next call
+// to Next.
+//
+// Returns nil, io.EOF after the last chunk. Returns nil, error on any reader
+// error.
+func (c *Chunker) Next() ([]byte, error) {
+	if c.e != nil {
+		return nil, c.e
+	}
+	if c.end-c.off < MaxSize && !c.eof {
+		if e := c.fill(); e != nil {
+			c.e = errors.ErrorWithCause(e, "cdc.Chunker.Next: on read")
+			return nil, c.e
+		}
+	}
+	if c.off == c.end {
+		return nil, io.EOF
+	}
+
+	var n = cut(c.buf[c.off:c.end])
+	var chunk = c.buf[c.off : c.off+n]
+	c.off += n
+	return chunk, nil
+}
+
+// fill moves the unconsumed data to the head of the buffer and reads until
+// the buffer is full or the reader is drained.
+func (c *Chunker) fill() error {
+	copy(c.buf, c.buf[c.off:c.end])
+	c.end -= c.off
+	c.off = 0
+	for c.end < len(c.buf) {
+		n, e := c.r.Read(c.buf[c.end:])
+		c.end += n
+		if e == io.EOF {
+			c.eof = true
+			return nil
+		} else if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// cut returns the size of the chunk at the head of the data.
+func cut(data []byte) int {
+	var n = len(data)
+	switch {
+	case n <= MinSize:
+		return n
+	case n > MaxSize:
+		n = MaxSize
+	}
+	var normal = NormalSize
+	if n < normal {
+		normal = n
+	}
+
+	var fp uint64
+	var i = MinSize
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
@@ -0,0 +1,77 @@
+// Doost!
+package cdc_test
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/alphazero/gart/syslib/cdc"
+)
+
+func randomData(seed int64, n int) []byte {
+	var data = make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func chunks(t *testing.T, data []byte) [][]byte {
+	var list [][]byte
+	var chunker = cdc.NewChunker(bytes.NewReader(data))
+	for {
+		chunk, e := chunker.Next()
+		if e == io.EOF {
+			return list
+		} else if e != nil {
+			t.Fatalf("err - unexpected: %v", e)
+		}
+		list = append(list, append([]byte(nil), chunk...))
+	}
+}
+
+func TestChunker(t *testing.T) {
+	var data = randomData(17, 4<<20)
+	var list = chunks(t, data)
+
+	var joined []byte
+	for i, chunk := range list {
+		if len(chunk) > cdc.MaxSize {
+			t.Errorf("chunk[%d] size:%d > max:%d", i, len(chunk), cdc.MaxSize)
+		}
+		if len(chunk) < cdc.MinSize && i < len(list)-1 {
+			t.Errorf("chunk[%d] size:%d < min:%d", i, len(chunk), cdc.MinSize)
+		}
+		joined = append(joined, chunk...)
+	}
+	if !bytes.Equal(joined, data) {
+		t.Fatalf("joined chunks do not match data")
+	}
+}
+
+func TestChunkerEmpty(t *testing.T) {
+	if list := chunks(t, []byte{}); len(list) != 0 {
+		t.Fatalf("have: %d chunks - expect: 0", len(list))
+	}
+}
+
+// an insertion should only change the chunks at the edit.
+func TestChunkerShift(t *testing.T) {
+	var data = randomData(18, 4<<20)
+	var edited = append(append(append([]byte(nil), data[:1<<20]...), "insert"...), data[1<<20:]...)
+
+	var known = make(map[string]bool)
+	for _, chunk := range chunks(t, data) {
+		known[string(chunk)] = true
+	}
+	var list = chunks(t, edited)
+	var shared int
+	for _, chunk := range list {
+		if known[string(chunk)] {
+			shared++
+		}
+	}
+	if shared < len(list)-3 {
+		t.Fatalf("shared chunks: %d of %d", shared, len(list))
+	}
+}
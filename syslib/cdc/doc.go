@@ -0,0 +1,14 @@
+// Doost!
+
+// package cdc provides content-defined chunking of byte streams.
+//
+// Chunk boundaries are determined by a rolling (gear) hash of the content, per
+// FastCDC, with normalized chunking: a stricter boundary mask is used before
+// the normal chunk size, and a looser mask after it, which narrows the chunk
+// size distribution around the normal size. As boundaries only depend on the
+// local content, an insertion or deletion in a stream only changes the chunks
+// at the edit, and the chunks of near-duplicate content are largely shared.
+//
+// The gear table is generated from a fixed seed and must never change, as
+// stored chunks (and their deduplication) depend on stable boundaries.
+package cdc
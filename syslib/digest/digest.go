@@ -4,9 +4,11 @@ package digest
 
 import (
 	"blake2b"
+	"hash"
 	"hash/crc32"
 	"hash/crc64"
-	"io/ioutil"
+	"io"
+	"os"
 	"unsafe"
 
 	"github.com/alphazero/gart/syslib/errors"
@@ -50,15 +52,37 @@ func Sum(b []byte) [HashSize]byte {
 	return blake2b.Sum256(b)
 }
 
-// Returns the (32 byte) Blake2B digest of the named file.
+// New returns a Black2B size 256 hash.Hash, for digests of streamed content.
+// The digest is the same as Sum of the full content.
+func New() hash.Hash {
+	return blake2b.New256()
+}
+
+// size of the read buffer of SumReader
+const readBufferSize = 64 << 10
+
+// Returns the (32 byte) Blake2B digest of the named file. The file is read
+// in fixed size blocks (see SumReader) and the digest is identical to Sum of
+// the full file content.
 func SumFile(fname string) ([]byte, error) {
-	buf, e := ioutil.ReadFile(fname)
+	file, e := os.Open(fname)
 	if e != nil {
 		return nil, e
 	}
+	defer file.Close()
 
-	h := Sum(buf)
-	return h[:], nil
+	return SumReader(file)
+}
+
+// Returns the (32 byte) Blake2B digest of the content of the reader, read to
+// EOF. The content is hashed incrementally with a fixed size buffer.
+func SumReader(r io.Reader) ([]byte, error) {
+	var h = New()
+	var buf = make([]byte, readBufferSize)
+	if _, e := io.CopyBuffer(h, r, buf); e != nil {
+		return nil, e
+	}
+	return h.Sum(nil), nil
 }
 
 /// checksums /////////////////////////////////////////////////////////////////
@@ -1,6 +1,8 @@
 package digest_test
 
 import (
+	"bytes"
+	"io/ioutil"
 	"testing"
 
 	"github.com/alphazero/gart/syslib/digest"
@@ -41,3 +43,45 @@ func BenchmarkBlake2bSumUint64(b *testing.B) {
 		digest.SumUint64(tagname)
 	}
 }
+
+func TestNew(t *testing.T) {
+	var data = []byte("Salaam Samad Sultan of LOVE")
+	var expect = digest.Sum(data)
+
+	var h = digest.New()
+	h.Write(data[:7])
+	h.Write(data[7:])
+	if md := h.Sum(nil); string(md) != string(expect[:]) {
+		t.Fatalf("err - digest.New: expected:%x have:%x", expect, md)
+	}
+}
+
+func TestSumFile(t *testing.T) {
+	data, e := ioutil.ReadFile(smallfile)
+	if e != nil {
+		t.Fatalf("err - unexpected: %v", e)
+	}
+	var expect = digest.Sum(data)
+
+	md, e := digest.SumFile(smallfile)
+	if e != nil {
+		t.Fatalf("err - unexpected: %v", e)
+	}
+	if !bytes.Equal(md, expect[:]) {
+		t.Fatalf("err - digest.SumFile: expected:%x have:%x", expect, md)
+	}
+}
+
+func TestSumReader(t *testing.T) {
+	// larger than the read buffer
+	var data = bytes.Repeat([]byte("Given Order Giving Love "), 10000)
+	var expect = digest.Sum(data)
+
+	md, e := digest.SumReader(bytes.NewReader(data))
+	if e != nil {
+		t.Fatalf("err - unexpected: %v", e)
+	}
+	if !bytes.Equal(md, expect[:]) {
+		t.Fatalf("err - digest.SumReader: expected:%x have:%x", expect, md)
+	}
+}
@@ -0,0 +1,107 @@
+// Doost!
+
+package fs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/alphazero/gart/repo"
+)
+
+/// advisory file locks ////////////////////////////////////////////////////////
+
+// FileLock is an advisory, cross-process, whole-file lock. Locks are POSIX
+// record locks (fcntl) so the pid of a conflicting lock holder can be
+// reported. Note that POSIX locks are per process: a process must not lock
+// the same file more than once, as closing any of its descriptors for the
+// file releases all of its locks on that file.
+type FileLock struct {
+	file      *os.File
+	exclusive bool
+}
+
+// LockedError is returned when a lock could not be acquired.
+type LockedError struct {
+	Path      string
+	Pid       int  // pid of a conflicting lock holder, or 0 if not known
+	Exclusive bool // true if the holder's lock is exclusive
+}
+
+func (e *LockedError) Error() string {
+	var mode = "shared"
+	if e.Exclusive {
+		mode = "exclusive"
+	}
+	return fmt.Sprintf("%s is locked (%s) by pid %d", e.Path, mode, e.Pid)
+}
+
+// lock poll interval when waiting for a lock.
+const lockPollInterval = 50 * time.Millisecond
+
+// LockFile acquires a shared (exclusive is false) or exclusive lock on the
+// file, creating it if necessary. If the lock is held by another process,
+// LockFile waits for at most timeout. A zero timeout does not wait.
+//
+// Returns a *LockedError if the lock was not acquired before the timeout.
+// Any other error is returned as is.
+func LockFile(fname string, exclusive bool, timeout time.Duration) (*FileLock, error) {
+	file, e := os.OpenFile(fname, os.O_RDWR|os.O_CREATE, repo.FilePerm)
+	if e != nil {
+		return nil, e
+	}
+
+	var ltype int16 = syscall.F_RDLCK
+	if exclusive {
+		ltype = syscall.F_WRLCK
+	}
+	var deadline = time.Now().Add(timeout)
+	for {
+		var flock = syscall.Flock_t{Type: ltype, Whence: io.SeekStart}
+		e := syscall.FcntlFlock(file.Fd(), syscall.F_SETLK, &flock)
+		if e == nil {
+			return &FileLock{file, exclusive}, nil
+		}
+		if e != syscall.EAGAIN && e != syscall.EACCES {
+			file.Close()
+			return nil, fmt.Errorf("err - fs.LockFile: fcntl - %s", e)
+		}
+		if time.Now().Add(lockPollInterval).After(deadline) {
+			break
+		}
+		time.Sleep(lockPollInterval)
+	}
+
+	// report the holder
+	defer file.Close()
+	var flock = syscall.Flock_t{Type: ltype, Whence: io.SeekStart}
+	if e := syscall.FcntlFlock(file.Fd(), syscall.F_GETLK, &flock); e != nil {
+		return nil, &LockedError{Path: fname}
+	}
+	return nil, &LockedError{
+		Path:      fname,
+		Pid:       int(flock.Pid),
+		Exclusive: flock.Type == syscall.F_WRLCK,
+	}
+}
+
+// Returns true if lock is exclusive.
+func (l *FileLock) IsExclusive() bool { return l.exclusive }
+
+// Unlock releases the lock and closes the lock file. Unlock of a released
+// lock is a nop.
+func (l *FileLock) Unlock() error {
+	if l.file == nil {
+		return nil
+	}
+	var flock = syscall.Flock_t{Type: syscall.F_UNLCK, Whence: io.SeekStart}
+	e := syscall.FcntlFlock(l.file.Fd(), syscall.F_SETLK, &flock)
+	if ec := l.file.Close(); e == nil {
+		e = ec
+	}
+	l.file = nil
+	return e
+}
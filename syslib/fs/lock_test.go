@@ -0,0 +1,163 @@
+// Doost!
+
+package fs
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// helperEnv is set in the env of a helper process - see TestHelperProcess.
+const helperEnv = "GART_TEST_LOCK"
+
+// lockInProcess acquires the lock in a separate process, as POSIX locks are
+// per process. The lock is held until the returned func is called.
+//
+// Returns the pid of the lock holder and the release func.
+func lockInProcess(t *testing.T, fname string, exclusive bool) (int, func()) {
+	var cmd = exec.Command(os.Args[0], "-test.run=^TestHelperProcess$")
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%t:%s", helperEnv, exclusive, fname))
+	stdin, e := cmd.StdinPipe()
+	if e != nil {
+		t.Fatalf("%v", e)
+	}
+	stdout, e := cmd.StdoutPipe()
+	if e != nil {
+		t.Fatalf("%v", e)
+	}
+	if e := cmd.Start(); e != nil {
+		t.Fatalf("%v", e)
+	}
+	var release = func() {
+		stdin.Close()
+		cmd.Wait()
+	}
+	if line, e := bufio.NewReader(stdout).ReadString('\n'); e != nil || line != "locked\n" {
+		release()
+		t.Fatalf("helper process - have:%q %v - expect:locked", line, e)
+	}
+	return cmd.Process.Pid, release
+}
+
+// TestHelperProcess is not a test. It is run by lockInProcess, and holds the
+// lock until its stdin is closed.
+func TestHelperProcess(t *testing.T) {
+	var spec = os.Getenv(helperEnv)
+	if spec == "" {
+		return
+	}
+	var exclusive bool
+	var fname string
+	if _, e := fmt.Sscanf(spec, "%t:%s", &exclusive, &fname); e != nil {
+		fmt.Fprintf(os.Stderr, "invalid spec %q\n", spec)
+		os.Exit(2)
+	}
+	lock, e := LockFile(fname, exclusive, 0)
+	if e != nil {
+		fmt.Fprintf(os.Stderr, "LockFile - %v\n", e)
+		os.Exit(1)
+	}
+	fmt.Println("locked")
+	ioutil.ReadAll(os.Stdin)
+	lock.Unlock()
+	os.Exit(0)
+}
+
+func lockTestFile(t *testing.T) (string, func()) {
+	dir, e := ioutil.TempDir("", "locktest")
+	if e != nil {
+		t.Fatalf("%v", e)
+	}
+	return filepath.Join(dir, "lock"), func() { os.RemoveAll(dir) }
+}
+
+// expectLocked checks that the lock is not acquired before the timeout, and
+// that the holder (pid) and the mode of its lock are reported.
+func expectLocked(t *testing.T, fname string, exclusive bool, timeout time.Duration, pid int, holderExclusive bool) {
+	var start = time.Now()
+	lock, e := LockFile(fname, exclusive, timeout)
+	if e == nil {
+		lock.Unlock()
+		t.Fatalf("LockFile(exclusive:%t) - expected error", exclusive)
+	}
+	le, ok := e.(*LockedError)
+	if !ok {
+		t.Fatalf("LockFile(exclusive:%t) - have:%v - expect:*LockedError", exclusive, e)
+	}
+	if le.Path != fname || le.Pid != pid || le.Exclusive != holderExclusive {
+		t.Fatalf("LockedError have:%+v - expect pid:%d exclusive:%t", *le, pid, holderExclusive)
+	}
+	if elapsed := time.Since(start); elapsed < timeout-lockPollInterval {
+		t.Fatalf("LockFile returned after %s - timeout:%s", elapsed, timeout)
+	}
+}
+
+func TestLockFileShared(t *testing.T) {
+	fname, done := lockTestFile(t)
+	defer done()
+
+	pid, release := lockInProcess(t, fname, false)
+	defer release()
+
+	// shared locks do not exclude each other
+	lock, e := LockFile(fname, false, 0)
+	if e != nil {
+		t.Fatalf("LockFile(shared) - %v", e)
+	}
+	if lock.IsExclusive() {
+		t.Fatalf("shared lock is exclusive")
+	}
+	if e := lock.Unlock(); e != nil {
+		t.Fatalf("Unlock - %v", e)
+	}
+	if e := lock.Unlock(); e != nil {
+		t.Fatalf("Unlock of a released lock - %v", e)
+	}
+
+	// an exclusive lock is not acquired before the timeout expires, and the
+	// holder is reported
+	for _, timeout := range []time.Duration{0, 200 * time.Millisecond} {
+		expectLocked(t, fname, true, timeout, pid, false)
+	}
+
+	// and is acquired once the shared lock is released
+	var released = make(chan time.Time, 1)
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		released <- time.Now()
+		release()
+	}()
+	if lock, e = LockFile(fname, true, 5*time.Second); e != nil {
+		t.Fatalf("LockFile(exclusive) - %v", e)
+	}
+	defer lock.Unlock()
+	if acquired, at := time.Now(), <-released; acquired.Before(at) {
+		t.Fatalf("exclusive lock acquired before release of shared lock")
+	}
+	if !lock.IsExclusive() {
+		t.Fatalf("exclusive lock is not exclusive")
+	}
+}
+
+func TestLockFileExclusive(t *testing.T) {
+	fname, done := lockTestFile(t)
+	defer done()
+
+	pid, release := lockInProcess(t, fname, true)
+	for _, exclusive := range []bool{false, true} {
+		expectLocked(t, fname, exclusive, 0, pid, true)
+	}
+	release()
+
+	lock, e := LockFile(fname, false, 0)
+	if e != nil {
+		t.Fatalf("LockFile(shared) - released - %v", e)
+	}
+	lock.Unlock()
+}
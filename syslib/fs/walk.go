@@ -0,0 +1,108 @@
+// Doost!
+
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+)
+
+/// tree walk //////////////////////////////////////////////////////////////////
+
+// WalkOption specifies the traversal of WalkTree.
+type WalkOption struct {
+	// Maximum depth of visited files, where the files of the root directory
+	// are at depth 1. 0 is unlimited.
+	MaxDepth int
+	// If true, symlinks are followed. Otherwise symlinks (but not the root)
+	// are skipped.
+	FollowSymlinks bool
+	// If not nil, paths for which Prune returns true are skipped. Pruned
+	// directories are not read.
+	Prune func(path string, isDir bool) bool
+}
+
+// WalkTree walks the file tree rooted at root, in lexical order, calling fn
+// for each regular file, and for each path that could not be read (with the
+// error). Other file types are skipped. If root is a symlink it is always
+// followed. Each directory is visited at most once, so symlink cycles are
+// not followed.
+//
+// The walk stops on the first error returned by fn, which is returned.
+func WalkTree(root string, option WalkOption, fn func(path string, e error) error) error {
+	var w = &walker{
+		option:  option,
+		fn:      fn,
+		visited: make(map[fileId]struct{}),
+	}
+	finfo, e := os.Stat(root)
+	if e != nil {
+		return fn(root, e)
+	}
+	return w.walk(root, finfo, 0)
+}
+
+type fileId struct {
+	dev, ino uint64
+}
+
+type walker struct {
+	option  WalkOption
+	fn      func(string, error) error
+	visited map[fileId]struct{} // dirs
+}
+
+func (w *walker) walk(path string, finfo os.FileInfo, depth int) error {
+	switch {
+	case finfo.Mode().IsRegular():
+		return w.fn(path, nil)
+	case !finfo.IsDir():
+		return nil
+	case w.option.MaxDepth > 0 && depth >= w.option.MaxDepth:
+		return nil
+	}
+	if stat, ok := finfo.Sys().(*syscall.Stat_t); ok {
+		var id = fileId{uint64(stat.Dev), uint64(stat.Ino)}
+		if _, ok := w.visited[id]; ok {
+			return nil
+		}
+		w.visited[id] = struct{}{}
+	}
+
+	dir, e := os.Open(path)
+	if e != nil {
+		return w.fn(path, e)
+	}
+	names, e := dir.Readdirnames(-1)
+	dir.Close()
+	if e != nil {
+		return w.fn(path, e)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var child = filepath.Join(path, name)
+		finfo, e := os.Lstat(child)
+		if e == nil && finfo.Mode()&os.ModeSymlink != 0 {
+			if !w.option.FollowSymlinks {
+				continue
+			}
+			finfo, e = os.Stat(child)
+		}
+		if e != nil {
+			if e := w.fn(child, e); e != nil {
+				return e
+			}
+			continue
+		}
+		if w.option.Prune != nil && w.option.Prune(child, finfo.IsDir()) {
+			continue
+		}
+		if e := w.walk(child, finfo, depth+1); e != nil {
+			return e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Doost!
+
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// tree:
+//
+//	root/a.txt
+//	root/b/c.txt
+//	root/b/d/e.txt
+//	root/.git/f.txt
+//	root/link-b -> b
+//	root/b/d/loop -> root
+func walkTestTree(t *testing.T) string {
+	root, e := ioutil.TempDir("", "walktest")
+	if e != nil {
+		t.Fatalf("%v", e)
+	}
+	for _, name := range []string{"a.txt", "b/c.txt", "b/d/e.txt", ".git/f.txt"} {
+		var path = filepath.Join(root, name)
+		if e := os.MkdirAll(filepath.Dir(path), 0755); e != nil {
+			t.Fatalf("%v", e)
+		}
+		if e := ioutil.WriteFile(path, []byte(name), 0644); e != nil {
+			t.Fatalf("%v", e)
+		}
+	}
+	if e := os.Symlink(filepath.Join(root, "b"), filepath.Join(root, "link-b")); e != nil {
+		t.Fatalf("%v", e)
+	}
+	if e := os.Symlink(root, filepath.Join(root, "b/d/loop")); e != nil {
+		t.Fatalf("%v", e)
+	}
+	return root
+}
+
+func TestWalkTree(t *testing.T) {
+	var root = walkTestTree(t)
+	defer os.RemoveAll(root)
+
+	var hidden = func(path string, isDir bool) bool {
+		return strings.HasPrefix(filepath.Base(path), ".")
+	}
+	var tests = []struct {
+		option WalkOption
+		expect []string
+	}{
+		{WalkOption{}, []string{".git/f.txt", "a.txt", "b/c.txt", "b/d/e.txt"}},
+		{WalkOption{Prune: hidden}, []string{"a.txt", "b/c.txt", "b/d/e.txt"}},
+		{WalkOption{MaxDepth: 1}, []string{"a.txt"}},
+		{WalkOption{MaxDepth: 2, Prune: hidden}, []string{"a.txt", "b/c.txt"}},
+		// dirs are visited once: link-b is b, and loop is root
+		{WalkOption{FollowSymlinks: true, Prune: hidden}, []string{"a.txt", "b/c.txt", "b/d/e.txt"}},
+	}
+	for i, test := range tests {
+		var have []string
+		e := WalkTree(root, test.option, func(path string, e error) error {
+			if e != nil {
+				return e
+			}
+			rel, _ := filepath.Rel(root, path)
+			have = append(have, rel)
+			return nil
+		})
+		if e != nil {
+			t.Fatalf("test %d: %v", i, e)
+		}
+		if !reflect.DeepEqual(have, test.expect) {
+			t.Fatalf("test %d: have:%q - expect:%q", i, have, test.expect)
+		}
+	}
+
+	// walk of a file root
+	var have []string
+	var file = filepath.Join(root, "a.txt")
+	WalkTree(file, WalkOption{}, func(path string, e error) error {
+		have = append(have, path)
+		return e
+	})
+	if !reflect.DeepEqual(have, []string{file}) {
+		t.Fatalf("have:%q - expect:%q", have, []string{file})
+	}
+}
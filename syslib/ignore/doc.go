@@ -0,0 +1,22 @@
+// Doost!
+
+// package ignore provides gitignore style path matching rules.
+//
+// Each line of an ignore file is a pattern. Blank lines and lines starting
+// with # are skipped. A pattern prefixed with ! negates (re-includes) paths
+// matched by prior rules. A pattern with a trailing / only matches
+// directories. A pattern without a (non trailing) / matches the basename of
+// paths at any depth below the directory of the ignore file (its base);
+// otherwise it is matched against the path relative to the base. * and ?
+// do not match /, and ** matches any number of directories:
+//
+//	*.o          any file (or dir) named *.o
+//	build/       any directory named build
+//	/TODO        TODO in the base directory only
+//	docs/**/*.md markdown files at any depth below docs
+//	!keep.o      re-include keep.o
+//
+// The last matching rule decides. Rules are matched against single paths:
+// as with git, a path in an excluded directory can not be re-included, and
+// callers must check the ancestor directories of a path.
+package ignore
@@ -0,0 +1,175 @@
+// Doost!
+
+package ignore
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/alphazero/gart/syslib/errors"
+)
+
+/// rules //////////////////////////////////////////////////////////////////////
+
+// Rule is a parsed ignore pattern.
+type Rule struct {
+	Source string // ignore file, or zero-len for built-in rules
+	Line   int    // line number in source
+	Text   string // pattern as written
+	Negate bool   // ! pattern - matched paths are not ignored
+
+	base     string   // absolute dir - anchored patterns are relative to base
+	dirOnly  bool     // trailing /
+	anchored bool     // pattern has a (non trailing) /
+	segs     []string // pattern path segments
+}
+
+// NewRule parses the pattern text. base is the absolute directory of the
+// rule. See Parse.
+//
+// Returns nil, nil if text is blank or a comment.
+func NewRule(text, base string) (*Rule, error) {
+	var err = errors.For("ignore.NewRule")
+
+	var rule = &Rule{
+		Text: text,
+		base: filepath.Clean(base),
+	}
+	var pattern = strings.TrimRight(text, " \t\r")
+	if strings.HasSuffix(pattern, `\`) && len(pattern) < len(text) {
+		pattern += " " // escaped trailing space
+	}
+	switch {
+	case pattern == "", strings.HasPrefix(pattern, "#"):
+		return nil, nil
+	case strings.HasPrefix(pattern, "!"):
+		rule.Negate = true
+		pattern = pattern[1:]
+	case strings.HasPrefix(pattern, `\!`), strings.HasPrefix(pattern, `\#`):
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	rule.anchored = strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	if pattern == "" {
+		return nil, err.InvalidArg("empty pattern %q", text)
+	}
+	rule.segs = strings.Split(pattern, "/")
+	for _, seg := range rule.segs {
+		if _, e := path.Match(seg, ""); e != nil {
+			return nil, err.InvalidArg("pattern %q - %v", text, e)
+		}
+	}
+	return rule, nil
+}
+
+// Parse reads the rules of an ignore file from r, one pattern per line.
+// source names the rules (see Rule) and base is the absolute directory of
+// the rules. Invalid patterns are skipped.
+//
+// Returns the rules, and nil on success. If any pattern is invalid, the valid
+// rules and the error of the first invalid pattern are returned.
+func Parse(r io.Reader, source, base string) ([]*Rule, error) {
+	var rules []*Rule
+	var first error
+	var scanner = bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		rule, e := NewRule(scanner.Text(), base)
+		if e != nil {
+			if first == nil {
+				first = errors.ErrorWithCause(e, "%s:%d", source, n)
+			}
+			continue
+		}
+		if rule == nil {
+			continue
+		}
+		rule.Source, rule.Line = source, n
+		rules = append(rules, rule)
+	}
+	if e := scanner.Err(); e != nil {
+		return rules, errors.ErrorWithCause(e, "ignore.Parse: %s", source)
+	}
+	return rules, first
+}
+
+// ParseFile reads the rules of the named ignore file. The base of the rules
+// is the directory of the file. See Parse.
+func ParseFile(filename string) ([]*Rule, error) {
+	file, e := os.Open(filename)
+	if e != nil {
+		return nil, e
+	}
+	defer file.Close()
+	return Parse(file, filename, filepath.Dir(filename))
+}
+
+// Match returns true if the rule pattern matches the absolute path. Paths not
+// under the rule base are never matched. Note that a negated rule matches the
+// paths that it re-includes.
+func (r *Rule) Match(abspath string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	rel, e := filepath.Rel(r.base, abspath)
+	if e != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return false
+	}
+	var segs = strings.Split(filepath.ToSlash(rel), "/")
+	if !r.anchored {
+		ok, _ := path.Match(r.segs[0], segs[len(segs)-1])
+		return ok
+	}
+	return matchSegs(r.segs, segs)
+}
+
+// ** matches zero or more segments, except as the last pattern segment, where
+// it matches one or more (i.e. all paths in the directory).
+func matchSegs(pattern, segs []string) bool {
+	switch {
+	case len(pattern) == 0:
+		return len(segs) == 0
+	case pattern[0] == "**" && len(pattern) == 1:
+		return len(segs) > 0
+	case pattern[0] == "**":
+		for i := 0; i <= len(segs); i++ {
+			if matchSegs(pattern[1:], segs[i:]) {
+				return true
+			}
+		}
+		return false
+	case len(segs) == 0:
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], segs[0]); !ok {
+		return false
+	}
+	return matchSegs(pattern[1:], segs[1:])
+}
+
+func (r *Rule) String() string {
+	if r.Source == "" {
+		return fmt.Sprintf("built-in: %s", r.Text)
+	}
+	return fmt.Sprintf("%s:%d: %s", r.Source, r.Line, r.Text)
+}
+
+// Match returns the last of the rules that matches the absolute path, or nil
+// if no rule matches. The path is ignored if the returned rule is not nil and
+// is not negated.
+func Match(rules []*Rule, abspath string, isDir bool) *Rule {
+	for i := len(rules) - 1; i >= 0; i-- {
+		if rules[i].Match(abspath, isDir) {
+			return rules[i]
+		}
+	}
+	return nil
+}
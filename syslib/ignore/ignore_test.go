@@ -0,0 +1,108 @@
+// Doost!
+
+package ignore
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRuleMatch(t *testing.T) {
+	var tests = []struct {
+		pattern string
+		path    string
+		isDir   bool
+		expect  bool
+	}{
+		{"*.o", "/r/a.o", false, true},
+		{"*.o", "/r/x/y/a.o", false, true},
+		{"*.o", "/r/a.oo", false, false},
+		{"*.o", "/a.o", false, false}, // not under base
+		{"build/", "/r/x/build", true, true},
+		{"build/", "/r/x/build", false, false},
+		{"/TODO", "/r/TODO", false, true},
+		{"/TODO", "/r/x/TODO", false, false},
+		{"x/*.md", "/r/x/a.md", false, true},
+		{"x/*.md", "/r/x/y/a.md", false, false},
+		{"x/*.md", "/r/y/x/a.md", false, false},
+		{"**/foo", "/r/foo", false, true},
+		{"**/foo", "/r/a/b/foo", true, true},
+		{"docs/**/*.md", "/r/docs/a.md", false, true},
+		{"docs/**/*.md", "/r/docs/a/b/c.md", false, true},
+		{"docs/**/*.md", "/r/x/docs/a.md", false, false},
+		{"abc/**", "/r/abc/x", false, true},
+		{"abc/**", "/r/abc/x/y", false, true},
+		{"abc/**", "/r/abc", true, false},
+		{"a?c", "/r/abc", false, true},
+		{"a[0-9]", "/r/a7", false, true},
+		{"a[0-9]", "/r/ab", false, false},
+		{`\#note`, "/r/#note", false, true},
+		{`\!bang`, "/r/!bang", false, true},
+		{`trail\ `, "/r/trail ", false, true},
+		{"trail  ", "/r/trail", false, true},
+	}
+	for _, test := range tests {
+		rule, e := NewRule(test.pattern, "/r")
+		if e != nil {
+			t.Fatalf("NewRule(%q) - %v", test.pattern, e)
+		}
+		if have := rule.Match(test.path, test.isDir); have != test.expect {
+			t.Fatalf("rule %q match(%q, isDir:%t) have:%t - expect:%t",
+				test.pattern, test.path, test.isDir, have, test.expect)
+		}
+	}
+
+	for _, text := range []string{"", "   ", "# comment"} {
+		if rule, e := NewRule(text, "/r"); rule != nil || e != nil {
+			t.Fatalf("NewRule(%q) have:%v, %v - expect: nil, nil", text, rule, e)
+		}
+	}
+	for _, text := range []string{"/", "!", "a[", "x/[z-"} {
+		if _, e := NewRule(text, "/r"); e == nil {
+			t.Fatalf("NewRule(%q) - expected error", text)
+		}
+	}
+}
+
+func TestParseAndMatch(t *testing.T) {
+	var spec = `
+# objects
+*.o
+!keep.o
+build/
+bad[
+/local.conf
+`
+	rules, e := Parse(strings.NewReader(spec), "test", "/r")
+	if e == nil || !strings.Contains(e.Error(), "test:6") {
+		t.Fatalf("expected error of line 6 - have:%v", e)
+	}
+	if len(rules) != 4 {
+		t.Fatalf("rules have:%d - expect:4", len(rules))
+	}
+
+	var tests = []struct {
+		path   string
+		isDir  bool
+		expect string // matching rule text, or zero-len
+	}{
+		{"/r/a.o", false, "*.o"},
+		{"/r/x/keep.o", false, "!keep.o"},
+		{"/r/x/build", true, "build/"},
+		{"/r/local.conf", false, "/local.conf"},
+		{"/r/x/local.conf", false, ""},
+		{"/r/a.c", false, ""},
+	}
+	for _, test := range tests {
+		var have string
+		if rule := Match(rules, test.path, test.isDir); rule != nil {
+			have = rule.Text
+		}
+		if have != test.expect {
+			t.Fatalf("Match(%q) have:%q - expect:%q", test.path, have, test.expect)
+		}
+	}
+	if rule := Match(rules, "/r/a.o", false); rule.String() != "test:3: *.o" {
+		t.Fatalf("rule.String() have:%q", rule.String())
+	}
+}
@@ -14,7 +14,9 @@ var (
 	ErrIndexNotExist error
 )
 
-//var	ErrTagNotFound = errors.Error("Tag for name not found") // REVU ???
+var ErrTagNotFound = errors.Error("Tag for name not found")
+
+var ErrInvalidURL = errors.Error("invalid url - expecting an absolute url")
 
 /// defined bugs ///////////////////////////////////////////////////////////////
 
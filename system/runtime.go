@@ -7,6 +7,7 @@ package system
 import (
 	"os"
 	"os/user"
+	"path/filepath"
 
 	"github.com/alphazero/gart/repo"
 	"github.com/alphazero/gart/syslib/debug"
@@ -39,20 +40,83 @@ func init() {
 		}
 	}
 
-	// gart repo is in user's home dir.
-	user, e := user.Current()
+	debug.Printf("debug.Writer is %s", debug.Writer.(*os.File).Name())
+
+	/// initialize non-const system vars ////////////////////////////
+
+	home, e := homeDir()
 	if e != nil {
 		panic(err.FaultWithCause(e, "unexpected error"))
 	}
+	repo.InitHomePaths(home)
 
-	/// initialize non-const system vars ////////////////////////////
+	// default repo location - see LocateRepo. The command-line may relocate it.
+	root, e := LocateRepo("", false)
+	if e != nil {
+		panic(err.FaultWithCause(e, "unexpected error"))
+	}
+	SetRepoRoot(root)
+}
 
-	// repo.Paths
-	repo.InitPaths(user.HomeDir)
+/// repo location //////////////////////////////////////////////////////////////
+
+// RepoEnv is the env var specifying the repo location. See LocateRepo.
+const RepoEnv = "GART_DIR"
+
+// LocateRepo returns the root directory of the repo, i.e. the directory of
+// the repo dir (.gart). spec is the location specified on the command-line,
+// and may be zero-len. In order of precedence, the repo is located:
+//
+//	spec          - the repo dir or its root directory
+//	$GART_DIR     - as spec
+//	cwd           - if init is true (i.e. a new repo)
+//	the repo nearest to cwd (see repo.FindRoot)
+//	user's home directory
+func LocateRepo(spec string, init bool) (string, error) {
+	var err = errors.For("system.LocateRepo")
+
+	if spec == "" {
+		spec = os.Getenv(RepoEnv)
+	}
+	if spec != "" {
+		path, e := filepath.Abs(spec)
+		if e != nil {
+			return "", err.ErrorWithCause(e, "spec:%q", spec)
+		}
+		if filepath.Base(path) == repo.RepoDir {
+			path = filepath.Dir(path)
+		}
+		return path, nil
+	}
 
-	debug.Printf("debug.Writer is %s", debug.Writer.(*os.File).Name())
-	debug := debug.For("system.init")
-	debug.Printf("--- system.init() ---------------------")
+	cwd, e := os.Getwd()
+	if e != nil {
+		return "", err.ErrorWithCause(e, "on os.Getwd")
+	}
+	if init {
+		return cwd, nil
+	}
+	if root, ok := repo.FindRoot(cwd); ok {
+		return root, nil
+	}
+	return homeDir()
+}
+
+func homeDir() (string, error) {
+	user, e := user.Current()
+	if e != nil {
+		return "", errors.ErrorWithCause(e, "system.homeDir: on user.Current")
+	}
+	return user.HomeDir, nil
+}
+
+// SetRepoRoot (re)initializes the repo paths (see repo.InitPaths) and the
+// dependent system vars for the repo in the root directory. Panics on error.
+func SetRepoRoot(root string) {
+	repo.InitPaths(root)
+
+	var debug = debug.For("system.SetRepoRoot")
+	debug.Printf("--- repo paths ------------------------")
 	debug.Printf("RepoPath:          %q", repo.RepoPath)
 	debug.Printf("TagsPath:          %q", repo.TagsPath)
 	debug.Printf("TagDictionaryPath: %q", repo.TagDictionaryPath)
@@ -60,12 +124,12 @@ func init() {
 	debug.Printf("IndexCardsPath:    %q", repo.IndexCardsPath)
 	debug.Printf("IndexTagmapsPath:  %q", repo.IndexTagmapsPath)
 	debug.Printf("ObjectIndexPath:   %q", repo.ObjectIndexPath)
-	debug.Printf("--- system.init() ------------- end ---")
-	// end sanity check
+	debug.Printf("ObjectsPath:       %q", repo.ObjectsPath)
+	debug.Printf("ObjectChunksPath:  %q", repo.ObjectChunksPath)
+	debug.Printf("--- repo paths ---------------- end ---")
 
 	// errors
 
 	ErrIndexExist = errors.Error("%q exists", repo.IndexPath)
 	ErrIndexNotExist = errors.Error("%q does not exist", repo.IndexPath)
-
 }
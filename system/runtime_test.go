@@ -0,0 +1,62 @@
+// Doost!
+
+package system
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alphazero/gart/repo"
+)
+
+func TestLocateRepo(t *testing.T) {
+	tmp, e := ioutil.TempDir("", "locaterepo")
+	if e != nil {
+		t.Fatalf("%v", e)
+	}
+	defer os.RemoveAll(tmp)
+	tmp, _ = filepath.EvalSymlinks(tmp) // cwd is resolved
+
+	var root = filepath.Join(tmp, "proj")
+	var cwd = filepath.Join(root, "a", "b")
+	if e := os.MkdirAll(filepath.Join(root, repo.RepoDir), 0755); e != nil {
+		t.Fatalf("%v", e)
+	}
+	if e := os.MkdirAll(cwd, 0755); e != nil {
+		t.Fatalf("%v", e)
+	}
+
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	if e := os.Chdir(cwd); e != nil {
+		t.Fatalf("%v", e)
+	}
+	defer os.Setenv(RepoEnv, os.Getenv(RepoEnv))
+	os.Unsetenv(RepoEnv)
+
+	var tests = []struct {
+		env, spec string
+		init      bool
+		expect    string
+	}{
+		{"", "", false, root},                        // discovered
+		{"", "", true, cwd},                          // init in cwd
+		{"", "/mnt/drive", false, "/mnt/drive"},      // spec
+		{"", "/mnt/drive/.gart", true, "/mnt/drive"}, // spec is repo dir
+		{"/x/y/.gart", "", false, "/x/y"},            // env
+		{"/x/y", "/mnt/drive", false, "/mnt/drive"},  // spec overrides env
+		{"", filepath.Join("..", ".."), false, root}, // relative to cwd
+	}
+	for i, test := range tests {
+		os.Setenv(RepoEnv, test.env)
+		have, e := LocateRepo(test.spec, test.init)
+		if e != nil {
+			t.Fatalf("test %d: %v", i, e)
+		}
+		if have != test.expect {
+			t.Fatalf("test %d: have:%q - expect:%q", i, have, test.expect)
+		}
+	}
+}
@@ -14,7 +14,47 @@ func ExtTag(name string) string  { return fmt.Sprintf("systemic:ext:%s", strings
 func TypeTag(name string) string { return fmt.Sprintf("systemic:type:%s", name) }
 func TodayTag() string           { return DayTag(time.Now()) }
 
+// url objects are tagged with the scheme and host of the (normalized) url.
+func SchemeTag(scheme string) string { return fmt.Sprintf("systemic:scheme:%s", scheme) }
+func HostTag(host string) string     { return fmt.Sprintf("systemic:host:%s", host) }
+
+// IsSystemic returns true if tag is a systemic tag. Systemic tags are managed
+// by gart and can not be applied or removed by users.
+func IsSystemic(tag string) bool { return strings.HasPrefix(tag, "systemic:") }
+
+// Objects are tagged with the day, and the month and year rollups of the day,
+// they were added to gart. The rollups allow date ranges to be queried as a
+// few month and year tags, and the day tags of partial months only.
+const (
+	dayTagPrefix   = "systemic:day:"
+	monthTagPrefix = "systemic:month:"
+	yearTagPrefix  = "systemic:year:"
+)
+
+// DateTags returns the day, month, and year tags of t.
+func DateTags(t time.Time) []string { return []string{DayTag(t), MonthTag(t), YearTag(t)} }
+
 func DayTag(t time.Time) string {
 	y, m, d := t.Date()
-	return fmt.Sprintf("systemic:day:%s-%02d-%d", strings.ToLower(m.String()[:3]), d, y)
+	return fmt.Sprintf("%s%s-%02d-%d", dayTagPrefix, strings.ToLower(m.String()[:3]), d, y)
+}
+
+func MonthTag(t time.Time) string {
+	y, m, _ := t.Date()
+	return fmt.Sprintf("%s%s-%d", monthTagPrefix, strings.ToLower(m.String()[:3]), y)
+}
+
+func YearTag(t time.Time) string { return fmt.Sprintf("%s%d", yearTagPrefix, t.Year()) }
+
+// ParseDayTag returns the (local time) date of the day tag. Returns false if
+// tag is not a day tag.
+func ParseDayTag(tag string) (time.Time, bool) {
+	if !strings.HasPrefix(tag, dayTagPrefix) {
+		return time.Time{}, false
+	}
+	t, e := time.ParseInLocation("Jan-02-2006", tag[len(dayTagPrefix):], time.Local)
+	if e != nil {
+		return time.Time{}, false
+	}
+	return t, true
 }
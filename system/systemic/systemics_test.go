@@ -0,0 +1,45 @@
+// Doost!
+
+package systemic
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDayTag(t *testing.T) {
+	var days = []time.Time{
+		time.Date(2018, time.March, 1, 0, 0, 0, 0, time.Local),
+		time.Date(2018, time.December, 31, 0, 0, 0, 0, time.Local),
+		time.Date(2026, time.October, 17, 0, 0, 0, 0, time.Local),
+	}
+	for _, day := range days {
+		tag := DayTag(day)
+		have, ok := ParseDayTag(tag)
+		if !ok {
+			t.Fatalf("ParseDayTag(%q) returned false", tag)
+		}
+		if !have.Equal(day) {
+			t.Fatalf("ParseDayTag(%q) have:%s - expect:%s", tag, have, day)
+		}
+	}
+	for _, tag := range []string{"jazz", ExtTag("txt"), "systemic:day:xyz-01-2018"} {
+		if _, ok := ParseDayTag(tag); ok {
+			t.Fatalf("ParseDayTag(%q) returned true", tag)
+		}
+	}
+}
+
+func TestDateTags(t *testing.T) {
+	var day = time.Date(2018, time.March, 1, 13, 30, 0, 0, time.Local)
+	var expect = []string{"systemic:day:mar-01-2018", "systemic:month:mar-2018", "systemic:year:2018"}
+	tags := DateTags(day)
+	if len(tags) != len(expect) {
+		t.Fatalf("DateTags have:%q - expect:%q", tags, expect)
+	}
+	for i := range tags {
+		if tags[i] != expect[i] {
+			t.Fatalf("DateTags have:%q - expect:%q", tags, expect)
+		}
+	}
+}
@@ -28,7 +28,7 @@ const (
 	URL
 )
 
-func SupportedTypes() []Otype { return []Otype{Text, File} }
+func SupportedTypes() []Otype { return []Otype{Data, Text, File, URL} }
 func (v Otype) Verify() error {
 	switch v {
 	case Data:
@@ -181,6 +181,11 @@ type TagManager interface {
 	// is indicative of a bug or fault.
 	IncrRefcnt(name string) (refcnt int, id int, err error)
 
+	// Decrements the named tag's refcnt and returns the new refcnt. Tags
+	// with a zero refcnt are retained. Returns ErrTagNotFound error if tag
+	// does not exist. Any other error is indicative of a bug or fault.
+	DecrRefcnt(name string) (refcnt int, id int, err error)
+
 	// Returns ids of selected tags. These are used to build index bitmaps.
 	// notDefined is never nil. If not empty, it contains all
 	// tag names that are not defined.
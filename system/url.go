@@ -0,0 +1,71 @@
+// Doost!
+
+package system
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/alphazero/gart/syslib/debug"
+)
+
+/// URL normalization //////////////////////////////////////////////////////////
+
+// default ports of schemes, removed on normalization
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ftp":   "21",
+	"ws":    "80",
+	"wss":   "443",
+}
+
+// NormalizeURL returns the normal form of an absolute URL, as used for the
+// identity of URL objects. The scheme and host are lowercased, the default
+// port of the scheme is removed, an empty path is set to "/", and query
+// params are sorted by key (values of the same key retain their order).
+//
+// Returns the normalized URL and its (lowercased) scheme and host, and nil on
+// success. Returns ErrInvalidURL if spec is not an absolute URL with a host.
+func NormalizeURL(spec string) (string, *url.URL, error) {
+	var debug = debug.For("system.NormalizeURL")
+
+	u, e := url.Parse(strings.TrimSpace(spec))
+	if e != nil {
+		debug.Printf("spec:%q - %v", spec, e)
+		return "", nil, ErrInvalidURL
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", nil, ErrInvalidURL
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	var host, port = strings.ToLower(u.Hostname()), u.Port()
+	if port == defaultPorts[u.Scheme] {
+		port = ""
+	}
+	switch {
+	case port != "":
+		u.Host = net.JoinHostPort(host, port)
+	case strings.Contains(host, ":"): // ipv6
+		u.Host = "[" + host + "]"
+	default:
+		u.Host = host
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	// note: url.Values.Encode sorts by key
+	if u.RawQuery != "" {
+		query, e := url.ParseQuery(u.RawQuery)
+		if e != nil {
+			debug.Printf("spec:%q - %v", spec, e)
+			return "", nil, ErrInvalidURL
+		}
+		u.RawQuery = query.Encode()
+	}
+	u.ForceQuery = false
+
+	return u.String(), u, nil
+}
@@ -0,0 +1,42 @@
+// Doost!
+
+package system
+
+import (
+	"testing"
+)
+
+func TestNormalizeURL(t *testing.T) {
+	var tests = []struct {
+		spec, expect, scheme, host string
+	}{
+		{"http://example.com", "http://example.com/", "http", "example.com"},
+		{"HTTP://Example.COM:80/a/B", "http://example.com/a/B", "http", "example.com"},
+		{"https://example.com:443/x?b=2&a=1", "https://example.com/x?a=1&b=2", "https", "example.com"},
+		{"https://example.com:8443/x?", "https://example.com:8443/x", "https", "example.com"},
+		{"http://example.com/?q=2&a=z&q=1#top", "http://example.com/?a=z&q=2&q=1#top", "http", "example.com"},
+		{"http://[::1]:80/", "http://[::1]/", "http", "::1"},
+		{" ftp://user@Files.example.org:21/pub ", "ftp://user@files.example.org/pub", "ftp", "files.example.org"},
+	}
+	for _, test := range tests {
+		have, u, e := NormalizeURL(test.spec)
+		if e != nil {
+			t.Fatalf("NormalizeURL(%q) - %v", test.spec, e)
+		}
+		if have != test.expect {
+			t.Fatalf("NormalizeURL(%q) have:%q - expect:%q", test.spec, have, test.expect)
+		}
+		if u.Scheme != test.scheme || u.Hostname() != test.host {
+			t.Fatalf("NormalizeURL(%q) scheme:%q host:%q", test.spec, u.Scheme, u.Hostname())
+		}
+		// normal form is a fixed point
+		if again, _, _ := NormalizeURL(have); again != have {
+			t.Fatalf("NormalizeURL(%q) have:%q - expect:%q", have, again, have)
+		}
+	}
+	for _, spec := range []string{"", "example.com/x", "/a/b", "mailto:me@example.com", "http://%zz"} {
+		if _, _, e := NormalizeURL(spec); e != ErrInvalidURL {
+			t.Fatalf("NormalizeURL(%q) have:%v - expect:%v", spec, e, ErrInvalidURL)
+		}
+	}
+}